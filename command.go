@@ -0,0 +1,98 @@
+package main
+
+import (
+	cs "ARS_Projekat/configstore"
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runCommand runs one of the commands below against the store configured
+// by the environment, the same one the server would use:
+//
+//	export [-namespace name] [-o file]
+//	import [-mode skip|overwrite|fail] [-i file]
+//
+// The archive is written to stdout or read from stdin unless a file is
+// given. With DB=file the server must not be running at the same time.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "export":
+		return exportCommand(args[1:])
+	case "import":
+		return importCommand(args[1:])
+	}
+	return fmt.Errorf("unknown command %q, expected export or import", args[0])
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	namespace := flags.String("namespace", "", "export only this namespace")
+	output := flags.String("o", "", "write the archive to this file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := cs.New()
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	buf := bufio.NewWriter(w)
+	if err := store.ExportArchive(context.Background(), buf, *namespace); err != nil {
+		if errors.Is(err, cs.ErrNotFound) {
+			return fmt.Errorf("namespace %q does not exist", *namespace)
+		}
+		return err
+	}
+	return buf.Flush()
+}
+
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	mode := flags.String("mode", string(cs.ConflictFail), "what to do with items the store has already: skip, overwrite or fail")
+	input := flags.String("i", "", "read the archive from this file instead of stdin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := cs.New()
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	result, err := store.ImportArchive(context.Background(), bufio.NewReader(r), cs.ConflictMode(*mode))
+	if result != nil {
+		fmt.Fprintf(os.Stderr, "imported %d, overwritten %d, skipped %d\n", result.Imported, result.Overwritten, result.Skipped)
+	}
+	var importErr *cs.ImportError
+	if errors.As(err, &importErr) {
+		// Items are written one at a time, the ones counted above stay.
+		fmt.Fprintf(os.Stderr, "import stopped at item %d, the items before it were written\n", importErr.Item)
+	}
+	return err
+}
@@ -0,0 +1,493 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// ArchiveFormat and ArchiveVersion are in the header of every archive.
+	// Archives of an unknown format or a newer version are rejected.
+	ArchiveFormat  = "configstore-archive"
+	ArchiveVersion = 1
+
+	KindNamespace = "namespace"
+	KindSchema    = "schema"
+	KindPromotion = "promotion"
+	KindTombstone = "tombstone"
+)
+
+// ConflictMode tells an import what to do with an item the store has
+// already.
+type ConflictMode string
+
+const (
+	// ConflictSkip keeps what the store has.
+	ConflictSkip ConflictMode = "skip"
+	// ConflictOverwrite replaces it with the item of the archive.
+	ConflictOverwrite ConflictMode = "overwrite"
+	// ConflictFail imports nothing if any item is in the store already.
+	ConflictFail ConflictMode = "fail"
+)
+
+var (
+	ErrInvalidArchive      = errors.New("Invalid archive")
+	ErrInvalidConflictMode = fmt.Errorf("Conflict mode must be %s, %s or %s", ConflictSkip, ConflictOverwrite, ConflictFail)
+)
+
+// ArchiveHeader is the first line of an archive.
+type ArchiveHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ArchiveItem is one line of an archive after the header: a config or group
+// version, a tombstone, a schema, a promotion or a namespace, exactly as
+// stored. Key is relative to the namespace and environment. Children holds
+// the label keys of a group version relative to Key in archives exported
+// before groups kept their labels in one document, imports ignore them and
+// build the label document from the group.
+type ArchiveItem struct {
+	Kind        string          `json:"kind"`
+	Namespace   string          `json:"namespace"`
+	Environment string          `json:"environment,omitempty"`
+	Key         string          `json:"key"`
+	Value       json.RawMessage `json:"value"`
+	Children    []*ArchivePair  `json:"children,omitempty"`
+}
+
+type ArchivePair struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ImportResult counts what an import did with the items of the archive.
+type ImportResult struct {
+	Imported    int `json:"imported"`
+	Overwritten int `json:"overwritten"`
+	Skipped     int `json:"skipped"`
+}
+
+// ImportError is returned when writing an item of an archive failed. Item is
+// its number, the line of the archive it is on. The items before it were
+// written and stay in the store.
+type ImportError struct {
+	Item int
+	Kind string
+	Key  string
+	Err  error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("Importing item %d (%s %s) failed: %v", e.Item, e.Kind, e.Key, e.Err)
+}
+
+func (e *ImportError) Unwrap() error {
+	return e.Err
+}
+
+// ExportArchive writes every namespace, or only the one given, with all its
+// environments to w as newline delimited JSON. Secrets stay sealed, so the
+// archive is only readable with the same master keys. The search index,
+// group label documents and idempotency keys are left out, imports rebuild
+// the index and the label documents.
+func (cs *ConfigStore) ExportArchive(ctx context.Context, w io.Writer, namespace string) error {
+	span := tracer.StartSpanFromContext(ctx, "ExportArchive")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	namespaces, err := cs.FindNamespaces(childCtx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if namespace != "" {
+		var found []*Namespace
+		for _, ns := range namespaces {
+			if ns.Name == namespace {
+				found = append(found, ns)
+			}
+		}
+		if len(found) == 0 {
+			return ErrNotFound
+		}
+		namespaces = found
+	}
+
+	enc := json.NewEncoder(w)
+	// Values are written as stored, escaping them again would change them.
+	enc.SetEscapeHTML(false)
+
+	header := &ArchiveHeader{Format: ArchiveFormat, Version: ArchiveVersion, CreatedAt: time.Now().UTC()}
+	if err := enc.Encode(header); err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	for _, ns := range namespaces {
+		nsCtx := WithNamespace(childCtx, ns.Name)
+		items, err := cs.exportNamespace(nsCtx)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		for _, item := range items {
+			if err := enc.Encode(item); err != nil {
+				tracer.LogError(span, err)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// exportNamespace returns the items of the namespace in ctx in the order
+// they are imported: the namespace itself, its schemas and promotions, then
+// the configs, groups and tombstones of every environment.
+func (cs *ConfigStore) exportNamespace(ctx context.Context) ([]*ArchiveItem, error) {
+	span := tracer.StartSpanFromContext(ctx, "exportNamespace")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	var items []*ArchiveItem
+	if name := NamespaceFrom(childCtx); name != DefaultNamespace {
+		key := constructNamespaceKey(childCtx, name)
+		pair, err := cs.db.Get(ctx, key)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if pair == nil {
+			return nil, ErrNotFound
+		}
+		items = append(items, &ArchiveItem{Kind: KindNamespace, Namespace: name, Key: key, Value: pair.Value})
+	}
+
+	for _, kind := range []string{KindSchema, KindPromotion} {
+		scoped, err := cs.exportPrefix(childCtx, kind, namespacePrefix(childCtx), kind+"/")
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		items = append(items, scoped...)
+	}
+
+	for _, scopeCtx := range cs.environmentContexts(childCtx) {
+		prefix := scopePrefix(scopeCtx)
+		for _, kind := range []string{KindConfig, KindGroup, KindTombstone} {
+			scoped, err := cs.exportPrefix(scopeCtx, kind, prefix, kind+"/")
+			if err != nil {
+				tracer.LogError(span, err)
+				return nil, err
+			}
+			items = append(items, scoped...)
+		}
+	}
+	return items, nil
+}
+
+// exportPrefix returns the items stored under prefix+root. The label
+// documents below group versions are left out.
+func (cs *ConfigStore) exportPrefix(ctx context.Context, kind, prefix, root string) ([]*ArchiveItem, error) {
+	span := tracer.StartSpanFromContext(ctx, "exportPrefix")
+	defer span.Finish()
+
+	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
+	pairs, err := cs.db.List(ctx, prefix+root)
+	if err != nil {
+		tracer.LogError(listSpan, err)
+		return nil, err
+	}
+	listSpan.Finish()
+
+	var items []*ArchiveItem
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(pair.Key, prefix)
+		if kind == KindGroup && strings.Count(key, "/") > 2 {
+			continue
+		}
+		items = append(items, &ArchiveItem{
+			Kind:        kind,
+			Namespace:   NamespaceFrom(ctx),
+			Environment: EnvironmentFrom(ctx),
+			Key:         key,
+			Value:       pair.Value,
+		})
+	}
+	return items, nil
+}
+
+// ImportArchive writes the items of an archive made by ExportArchive, each
+// to the namespace and environment it was exported from. Items the store
+// has already are handled as mode says. The archive is read and checked as
+// a whole before anything is written, then the items are written one at a
+// time. The import is not atomic: if writing an item fails, the items before
+// it stay in the store, the result counts them and the error is an
+// *ImportError naming the item.
+func (cs *ConfigStore) ImportArchive(ctx context.Context, r io.Reader, mode ConflictMode) (*ImportResult, error) {
+	span := tracer.StartSpanFromContext(ctx, "ImportArchive")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if mode != ConflictSkip && mode != ConflictOverwrite && mode != ConflictFail {
+		tracer.LogError(span, ErrInvalidConflictMode)
+		return nil, ErrInvalidConflictMode
+	}
+
+	items, err := cs.readArchive(childCtx, r)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	existing := make([]*KVPair, len(items))
+	for i, item := range items {
+		existing[i], err = cs.db.Get(ctx, itemKey(childCtx, item))
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if existing[i] != nil && mode == ConflictFail {
+			err := fmt.Errorf("%w: %s %s is in the store already", ErrConflict, item.Kind, item.Key)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+	}
+
+	result := &ImportResult{}
+	for i, item := range items {
+		if existing[i] != nil && mode == ConflictSkip {
+			result.Skipped++
+			continue
+		}
+		if err := cs.importItem(childCtx, item, existing[i]); err != nil {
+			// Items are numbered as readArchive does, after the header.
+			err := &ImportError{Item: i + 2, Kind: item.Kind, Key: item.Key, Err: err}
+			tracer.LogError(span, err)
+			return result, err
+		}
+		if existing[i] != nil {
+			result.Overwritten++
+		} else {
+			result.Imported++
+		}
+	}
+	return result, nil
+}
+
+// readArchive reads and checks the header and the items of an archive.
+func (cs *ConfigStore) readArchive(ctx context.Context, r io.Reader) ([]*ArchiveItem, error) {
+	span := tracer.StartSpanFromContext(ctx, "readArchive")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	header := &ArchiveHeader{}
+	if err := dec.Decode(header); err != nil {
+		return nil, fmt.Errorf("%w: reading the header: %v", ErrInvalidArchive, err)
+	}
+	if header.Format != ArchiveFormat {
+		return nil, fmt.Errorf("%w: format is %q, expected %q", ErrInvalidArchive, header.Format, ArchiveFormat)
+	}
+	if header.Version < 1 || header.Version > ArchiveVersion {
+		return nil, fmt.Errorf("%w: version %d is not supported", ErrInvalidArchive, header.Version)
+	}
+
+	var items []*ArchiveItem
+	namespaces := make(map[string]bool)
+	for line := 2; ; line++ {
+		item := &ArchiveItem{}
+		err := dec.Decode(item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: item %d: %v", ErrInvalidArchive, line, err)
+		}
+		if err := cs.checkItem(item); err != nil {
+			return nil, fmt.Errorf("%w: item %d: %v", ErrInvalidArchive, line, err)
+		}
+		if item.Kind == KindNamespace {
+			namespaces[item.Namespace] = true
+		}
+		items = append(items, item)
+	}
+
+	// Items of a namespace need the namespace, from the archive or the
+	// store.
+	for _, item := range items {
+		name := item.Namespace
+		if namespaces[name] {
+			continue
+		}
+		ok, err := cs.NamespaceExists(childCtx, name)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: namespace %q is neither in the archive nor in the store", ErrInvalidArchive, name)
+		}
+		namespaces[name] = true
+	}
+	return items, nil
+}
+
+// checkItem makes sure an item can only be written where its kind is
+// stored.
+func (cs *ConfigStore) checkItem(item *ArchiveItem) error {
+	if item.Namespace != DefaultNamespace && !namespaceName.MatchString(item.Namespace) {
+		return fmt.Errorf("invalid namespace %q", item.Namespace)
+	}
+	if item.Environment != "" && !cs.EnvironmentExists(item.Environment) {
+		return fmt.Errorf("environment %q does not exist", item.Environment)
+	}
+	if !json.Valid(item.Value) {
+		return fmt.Errorf("value of %q is not JSON", item.Key)
+	}
+
+	switch item.Kind {
+	case KindNamespace:
+		if item.Namespace == DefaultNamespace || item.Key != fmt.Sprintf(namespace, item.Namespace) {
+			return fmt.Errorf("namespace key %q does not match namespace %q", item.Key, item.Namespace)
+		}
+	case KindSchema, KindPromotion:
+		if item.Environment != "" {
+			return fmt.Errorf("%ss are not kept per environment", item.Kind)
+		}
+	case KindConfig, KindGroup, KindTombstone:
+	default:
+		return fmt.Errorf("unknown kind %q", item.Kind)
+	}
+	if item.Kind != KindNamespace {
+		if !strings.HasPrefix(item.Key, item.Kind+"/") {
+			return fmt.Errorf("%s key %q does not start with %s/", item.Kind, item.Key, item.Kind)
+		}
+		// Config and group versions are <kind>/<id>/<ver>, a deeper key
+		// would land among the label keys.
+		segments := strings.Count(item.Key, "/")
+		if segments < 2 || (segments > 2 && (item.Kind == KindConfig || item.Kind == KindGroup)) {
+			return fmt.Errorf("%s key %q has the wrong number of segments", item.Kind, item.Key)
+		}
+	}
+
+	if len(item.Children) > 0 && item.Kind != KindGroup {
+		return fmt.Errorf("only groups have label keys, %s %q has some", item.Kind, item.Key)
+	}
+	for _, child := range item.Children {
+		if child.Key == "" || !json.Valid(child.Value) {
+			return fmt.Errorf("invalid label key %q of %q", child.Key, item.Key)
+		}
+	}
+	if item.Kind == KindConfig {
+		if err := json.Unmarshal(item.Value, &Config{}); err != nil {
+			return fmt.Errorf("config %q can not be read: %v", item.Key, err)
+		}
+	}
+	if item.Kind == KindGroup {
+		group := &Group{}
+		if err := json.Unmarshal(item.Value, group); err != nil {
+			return fmt.Errorf("group %q can not be read: %v", item.Key, err)
+		}
+		for _, config := range group.Configs {
+			if len(config) == 0 {
+				return fmt.Errorf("group %q has a config without labels", item.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// itemKey is the store key of an item.
+func itemKey(ctx context.Context, item *ArchiveItem) string {
+	if item.Kind == KindNamespace {
+		return item.Key
+	}
+	scopeCtx := WithEnvironment(WithNamespace(ctx, item.Namespace), item.Environment)
+	if item.Kind == KindSchema || item.Kind == KindPromotion {
+		return namespacePrefix(scopeCtx) + item.Key
+	}
+	return scopePrefix(scopeCtx) + item.Key
+}
+
+// importItem writes an item, replacing the existing pair and its label keys
+// if there is one. The CAS fails if the key changed since it was read. The
+// label document of a group version is built from the group rather than
+// copied, so a group is written in three operations however large it is.
+func (cs *ConfigStore) importItem(ctx context.Context, item *ArchiveItem, existing *KVPair) error {
+	span := tracer.StartSpanFromContext(ctx, "importItem")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+	scopeCtx := WithEnvironment(WithNamespace(childCtx, item.Namespace), item.Environment)
+
+	key := itemKey(childCtx, item)
+	op := &TxnOp{Verb: TxnCAS, Key: key, Value: item.Value}
+
+	var old *Config
+	var ops []*TxnOp
+	if existing != nil {
+		op.Index = existing.ModifyIndex
+		if item.Kind == KindGroup {
+			ops = append(ops, &TxnOp{Verb: TxnDeleteTree, Key: key + "/"})
+		}
+		if item.Kind == KindConfig {
+			old = &Config{}
+			if err := json.Unmarshal(existing.Value, old); err != nil {
+				old = nil
+			}
+		}
+	}
+	ops = append(ops, op)
+
+	var index, undo []*TxnOp
+	if item.Kind == KindConfig {
+		config := &Config{}
+		if err := json.Unmarshal(item.Value, config); err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		var err error
+		index, undo, err = indexOps(scopeCtx, old, config)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+	}
+	if item.Kind == KindGroup {
+		group := &Group{}
+		if err := json.Unmarshal(item.Value, group); err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		parts := strings.Split(item.Key, "/")
+		labels, err := labelOps(scopeCtx, group, parts[1], parts[2])
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		ops = append(ops, labels...)
+	}
+
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	if err := cs.txnIndexed(ctx, ops, index, undo); err != nil {
+		tracer.LogError(txnSpan, err)
+		return err
+	}
+	txnSpan.Finish()
+
+	return nil
+}
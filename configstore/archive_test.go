@@ -0,0 +1,154 @@
+package configstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// failingTxn is a backend failing every transaction that writes key.
+type failingTxn struct {
+	Backend
+	key string
+}
+
+var errTxnFailed = errors.New("transaction failed")
+
+func (f failingTxn) Txn(ctx context.Context, ops []*TxnOp) error {
+	for _, op := range ops {
+		if op.Key == f.key {
+			return errTxnFailed
+		}
+	}
+	return f.Backend.Txn(ctx, ops)
+}
+
+func TestImportLargeGroup(t *testing.T) {
+	ctx := context.Background()
+	from := newTestStore()
+
+	group, err := from.CreateGroup(ctx, &Group{Version: "v1", Configs: bigGroup(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := from.ExportArchive(ctx, &archive, ""); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(archive.String(), `"children"`) {
+		t.Errorf("archive holds label documents:\n%s", archive.String())
+	}
+
+	to := newTestStore()
+	tests := []struct {
+		mode ConflictMode
+		want ImportResult
+	}{
+		{ConflictFail, ImportResult{Imported: 1}},
+		{ConflictOverwrite, ImportResult{Overwritten: 1}},
+		{ConflictSkip, ImportResult{Skipped: 1}},
+	}
+	for _, tt := range tests {
+		result, err := to.ImportArchive(ctx, bytes.NewReader(archive.Bytes()), tt.mode)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.mode, err)
+		}
+		if *result != tt.want {
+			t.Errorf("%s: result = %+v, want %+v", tt.mode, *result, tt.want)
+		}
+
+		// i%6 == 3 among 0..99
+		labels, err := to.FindLabelsMatching(ctx, group.ID, "v1", map[string]string{"env": "prod", "tier": "web"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(labels) != 17 {
+			t.Errorf("%s: got %d configs, want 17", tt.mode, len(labels))
+		}
+	}
+}
+
+func TestImportIgnoresLabelKeys(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	// Archives exported before label documents carry one label key per
+	// config, more than fit in a transaction.
+	group := &Group{ID: "old", Version: "v1", Configs: bigGroup(100)}
+	value, err := json.Marshal(group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := &ArchiveItem{Kind: KindGroup, Namespace: DefaultNamespace, Key: "group/old/v1", Value: value}
+	for i, config := range group.Configs {
+		labels, _ := json.Marshal(config)
+		item.Children = append(item.Children, &ArchivePair{Key: fmt.Sprintf("%s/%d", LabelString(config), i), Value: labels})
+	}
+	line, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := fmt.Sprintf("{\"format\": %q, \"version\": %d}\n%s\n", ArchiveFormat, ArchiveVersion, line)
+
+	if _, err := cs.ImportArchive(ctx, strings.NewReader(archive), ConflictFail); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := cs.db.Keys(ctx, constructGroupKey(ctx, "old", "v1")+"/", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != constructGroupLabelsKey(ctx, "old", "v1") {
+		t.Errorf("group has keys %q, want only its label document", keys)
+	}
+	labels, err := cs.FindLabelsMatching(ctx, "old", "v1", map[string]string{"name": "c42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 {
+		t.Errorf("got %d configs named c42, want 1", len(labels))
+	}
+}
+
+func TestImportStopsAtFailedItem(t *testing.T) {
+	ctx := context.Background()
+	from := newTestStore()
+
+	for i := 0; i < 3; i++ {
+		if _, err := from.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var archive bytes.Buffer
+	if err := from.ExportArchive(ctx, &archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The header is line 1, the second item line 3.
+	second := &ArchiveItem{}
+	if err := json.Unmarshal(bytes.Split(archive.Bytes(), []byte("\n"))[2], second); err != nil {
+		t.Fatal(err)
+	}
+	to := NewWithBackend(failingTxn{NewMemoryBackend(), itemKey(ctx, second)})
+	result, err := to.ImportArchive(ctx, &archive, ConflictFail)
+
+	var importErr *ImportError
+	if !errors.As(err, &importErr) {
+		t.Fatalf("err = %v, want an ImportError", err)
+	}
+	if !errors.Is(err, errTxnFailed) {
+		t.Errorf("err = %v, want it to wrap the backend error", err)
+	}
+	if importErr.Item != 3 || importErr.Kind != KindConfig {
+		t.Errorf("failed item = %d %s, want 3 %s", importErr.Item, importErr.Kind, KindConfig)
+	}
+	if importErr.Key != second.Key {
+		t.Errorf("failed item = %s, want %s", importErr.Key, second.Key)
+	}
+	if result == nil || result.Imported != 1 {
+		t.Errorf("result = %+v, want the one config written before", result)
+	}
+}
@@ -0,0 +1,95 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	defaultDBPath = "configstore.db"
+)
+
+// KVPair is a single key in the backing store. ModifyIndex is bumped on
+// every write to the key and is unique across the whole store.
+type KVPair struct {
+	Key         string
+	Value       []byte
+	ModifyIndex uint64
+}
+
+type TxnVerb string
+
+const (
+	TxnSet        TxnVerb = "set"
+	TxnDelete     TxnVerb = "delete"
+	TxnDeleteTree TxnVerb = "delete-tree"
+	// TxnCAS sets the key only if its ModifyIndex still equals Index. An
+	// Index of 0 means the key must not exist yet.
+	TxnCAS TxnVerb = "cas"
+	// TxnDeleteCAS deletes the key only if its ModifyIndex still equals
+	// Index.
+	TxnDeleteCAS TxnVerb = "delete-cas"
+)
+
+// ErrConflict is returned by Backend.Txn when a TxnCAS or TxnDeleteCAS op
+// does not match.
+var ErrConflict = errors.New("key already exists or was modified concurrently")
+
+// TxnOp is one operation of a transaction passed to Backend.Txn.
+type TxnOp struct {
+	Verb  TxnVerb `json:"verb"`
+	Key   string  `json:"key"`
+	Value []byte  `json:"value,omitempty"`
+	Index uint64  `json:"index,omitempty"`
+}
+
+// Backend is the key/value storage ConfigStore is built on. Keys are plain
+// slash separated strings and List/DeleteTree operate on raw key prefixes,
+// the same way Consul KV does.
+type Backend interface {
+	Get(ctx context.Context, key string) (*KVPair, error)
+	Put(ctx context.Context, p *KVPair) error
+	List(ctx context.Context, prefix string) ([]*KVPair, error)
+	// Keys lists the keys under prefix without their values, sorted. With a
+	// separator, keys are cut right after the first separator following
+	// the prefix and reported once, like a directory listing.
+	Keys(ctx context.Context, prefix, separator string) ([]string, error)
+	// ListIndexes is List without the values, the pairs only hold the key
+	// and its ModifyIndex.
+	ListIndexes(ctx context.Context, prefix string) ([]*KVPair, error)
+	Delete(ctx context.Context, key string) error
+	DeleteTree(ctx context.Context, prefix string) error
+	// Txn applies all ops or none of them.
+	Txn(ctx context.Context, ops []*TxnOp) error
+	// Watch blocks until a key under prefix is written or deleted after
+	// index, or wait passes, and returns the current index of the prefix.
+	// An index of 0 never blocks.
+	Watch(ctx context.Context, prefix string, index uint64, wait time.Duration) (uint64, error)
+	// WatchKey is Watch for a single key, keys it is a prefix of do not
+	// wake it.
+	WatchKey(ctx context.Context, key string, index uint64, wait time.Duration) (uint64, error)
+}
+
+// NewBackend picks the backend from the environment. DB holds either the
+// backend name (memory, file) or, for Consul, the agent host. The file
+// backend keeps its log at DBPATH.
+func NewBackend() (Backend, error) {
+	db := os.Getenv("DB")
+	dbport := os.Getenv("DBPORT")
+
+	switch db {
+	case "memory":
+		return NewMemoryBackend(), nil
+	case "file":
+		path := os.Getenv("DBPATH")
+		if path == "" {
+			path = defaultDBPath
+		}
+		return NewFileBackend(path)
+	default:
+		return NewConsulBackend(fmt.Sprintf("%s:%s", db, dbport))
+	}
+}
@@ -6,30 +6,115 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/google/uuid"
-	"github.com/hashicorp/consul/api"
 	"log"
+	"net/url"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LatestVersion can be used instead of a version to address the newest
+	// version of a config or group.
+	LatestVersion = "latest"
+
+	// MetaRollbackFrom is the metadata key holding the version a rolled
+	// back config was copied from.
+	MetaRollbackFrom = "rollbackFrom"
+)
+
+var (
+	ErrNotFound       = errors.New("That item does not exist!")
+	ErrDanglingRef    = errors.New("Referenced config does not exist")
+	ErrNoMatch        = errors.New("No config in the group matches the selector")
+	ErrAmbiguousMatch = errors.New("Selector matches more than one config in the group")
 )
 
 type ConfigStore struct {
-	cli *api.Client
+	db Backend
+	// semver makes new versions be rejected unless they are semantic
+	// versions.
+	semver bool
+	// retention is how long deleted versions can be restored.
+	retention time.Duration
+	// keyring encrypts secret entries, without it configs can not have
+	// secrets.
+	keyring *Keyring
+	// environments are the environments versions are promoted through, in
+	// order.
+	environments []string
+	// envPrefix starts the name of every environment variable ${env:}
+	// references may read, see ResolveConfig.
+	envPrefix string
+	// schemas caches compiled schema versions by key, see compileSchema.
+	schemas sync.Map
 }
 
 func New() (*ConfigStore, error) {
-	db := os.Getenv("DB")
-	dbport := os.Getenv("DBPORT")
+	db, err := NewBackend()
+	if err != nil {
+		return nil, err
+	}
 
-	config := api.DefaultConfig()
-	config.Address = fmt.Sprintf("%s:%s", db, dbport)
-	client, err := api.NewClient(config)
+	store := NewWithBackend(db)
+	store.semver = os.Getenv("SEMVER") == "true"
+	if retention := os.Getenv("RETENTION"); retention != "" {
+		store.retention, err = time.ParseDuration(retention)
+		if err != nil || store.retention <= 0 {
+			return nil, fmt.Errorf("invalid RETENTION %q, expected a positive duration such as 72h", retention)
+		}
+	}
+
+	if prefix, ok := os.LookupEnv("RESOLVE_ENV_PREFIX"); ok {
+		if prefix == "" {
+			return nil, errors.New("RESOLVE_ENV_PREFIX must not be empty, it would let ${env:} read every environment variable")
+		}
+		store.envPrefix = prefix
+	}
+
+	store.environments, err = parseEnvironments()
 	if err != nil {
 		return nil, err
 	}
 
+	if path := os.Getenv("MASTER_KEY_FILE"); path != "" {
+		store.keyring, err = LoadKeyring(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading MASTER_KEY_FILE: %w", err)
+		}
+	}
+
+	store.buildIndexes(context.Background())
+	return store, nil
+}
+
+// buildIndexes builds the indexes missing from data written by earlier
+// versions, in every namespace and environment.
+func (cs *ConfigStore) buildIndexes(ctx context.Context) {
+	scopeCtxs, err := cs.scopeContexts(ctx)
+	if err != nil {
+		log.Default().Printf("building the indexes failed: %v", err)
+		return
+	}
+	for _, scopeCtx := range scopeCtxs {
+		if err := cs.BuildSearchIndex(scopeCtx); err != nil {
+			log.Default().Printf("building the search index of %q failed: %v", scopePrefix(scopeCtx), err)
+		}
+		if err := cs.BuildLabelIndex(scopeCtx); err != nil {
+			log.Default().Printf("building the label index of %q failed: %v", scopePrefix(scopeCtx), err)
+		}
+	}
+}
+
+func NewWithBackend(db Backend) *ConfigStore {
 	return &ConfigStore{
-		cli: client,
-	}, nil
+		db:           db,
+		retention:    defaultRetention,
+		environments: strings.Split(defaultEnvironments, ","),
+		envPrefix:    defaultEnvPrefix,
+	}
 }
 
 func (cs *ConfigStore) CreateConfig(ctx context.Context, config *Config) (*Config, error) {
@@ -37,43 +122,85 @@ func (cs *ConfigStore) CreateConfig(ctx context.Context, config *Config) (*Confi
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if err := validateVersion(config.Version, cs.semver); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
 	sid, rid := generateConfigKey(childCtx, config.Version)
 	config.ID = rid
 
-	data, err := json.Marshal(config)
+	if err := cs.checkParent(childCtx, config); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if err := cs.validateConfig(childCtx, config); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	stored, err := cs.sealConfig(childCtx, config)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base put")
-	kv := cs.cli.KV()
-	c := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(c, nil)
+	data, err := json.Marshal(stored)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	index, undo, err := indexOps(childCtx, nil, stored)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base cas")
+	err = cs.txnIndexed(ctx, []*TxnOp{{Verb: TxnCAS, Key: sid, Value: data}}, index, undo)
 	if err != nil {
 		tracer.LogError(putSpan, err)
 		return nil, err
 	}
 	putSpan.Finish()
 
-	return config, nil
+	return redactConfig(stored), nil
 }
 
+// FindConfig returns a config version with its secret entries redacted, see
+// RevealConfig.
 func (cs *ConfigStore) FindConfig(ctx context.Context, id string, ver string) (*Config, error) {
 	span := tracer.StartSpanFromContext(ctx, "FindConfig")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
+	config, err := cs.findConfig(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return redactConfig(config), nil
+}
+
+// findConfig returns a config version as stored, secrets still sealed.
+func (cs *ConfigStore) findConfig(ctx context.Context, id string, ver string) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "findConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
 	key := constructConfigKey(childCtx, id, ver)
 
 	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
-	kv := cs.cli.KV()
-	data, _, err := kv.Get(key, nil)
+	data, err := cs.db.Get(ctx, key)
 
 	if err != nil || data == nil {
 		tracer.LogError(getSpan, err)
-		return nil, errors.New("That item does not exist!")
+		return nil, ErrNotFound
 	}
 	getSpan.Finish()
 
@@ -95,9 +222,8 @@ func (cs *ConfigStore) FindConfVersions(ctx context.Context, id string) ([]*Conf
 
 	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
 
-	key := constructConfigIdKey(childCtx, id)
-	kv := cs.cli.KV()
-	data, _, err := kv.List(key, nil)
+	key := constructConfigIdKey(childCtx, id) + "/"
+	data, err := cs.db.List(ctx, key)
 	if err != nil {
 		tracer.LogError(listSpan, err)
 		return nil, err
@@ -114,53 +240,176 @@ func (cs *ConfigStore) FindConfVersions(ctx context.Context, id string) ([]*Conf
 			return nil, err
 		}
 
-		configs = append(configs, config)
+		configs = append(configs, redactConfig(config))
 	}
 
+	sort.SliceStable(configs, func(i, j int) bool {
+		return compareVersions(configs[i].Version, configs[j].Version) < 0
+	})
+
 	return configs, nil
 }
 
+// ConfigVersion resolves LatestVersion to the newest existing version of the
+// config. Any other version is returned as is.
+func (cs *ConfigStore) ConfigVersion(ctx context.Context, id, ver string) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ConfigVersion")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if ver != LatestVersion {
+		return ver, nil
+	}
+
+	configs, err := cs.FindConfVersions(childCtx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		return "", err
+	}
+	if len(configs) == 0 {
+		return "", ErrNotFound
+	}
+	return configs[len(configs)-1].Version, nil
+}
+
 func (cs *ConfigStore) UpdateConfigVersion(ctx context.Context, config *Config) (*Config, error) {
-	span := tracer.StartSpanFromContext(context.Background(), "UpdateConfigVersion")
+	span := tracer.StartSpanFromContext(ctx, "UpdateConfigVersion")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	return cs.putConfig(childCtx, config)
+}
+
+// putConfig writes a new version of an existing config ID, together with
+// extra ops in the same transaction.
+func (cs *ConfigStore) putConfig(ctx context.Context, config *Config, extra ...*TxnOp) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "putConfig")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	data, err := json.Marshal(config)
+	if err := validateVersion(config.Version, cs.semver); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if err := cs.checkParent(childCtx, config); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if err := cs.validateConfig(childCtx, config); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	stored, err := cs.sealConfig(childCtx, config)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
-	_, err = cs.FindConfig(childCtx, config.ID, config.Version)
+	data, err := json.Marshal(stored)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
 
-	//Does exist
-	if err == nil {
+	index, undo, err := indexOps(childCtx, nil, stored)
+	if err != nil {
 		tracer.LogError(span, err)
-		return nil, errors.New("Given config version already exists! ")
+		return nil, err
 	}
 
-	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base put")
-	c := &api.KVPair{Key: constructConfigKey(childCtx, config.ID, config.Version), Value: data}
-	kv := cs.cli.KV()
-	_, err = kv.Put(c, nil)
+	// Versions are immutable, the CAS fails with ErrConflict if another
+	// request created this version first.
+	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base cas")
+	key := constructConfigKey(childCtx, config.ID, config.Version)
+	ops := append([]*TxnOp{{Verb: TxnCAS, Key: key, Value: data}}, extra...)
+	err = cs.txnIndexed(ctx, ops, index, undo)
 	if err != nil {
 		tracer.LogError(putSpan, err)
 		return nil, err
 	}
 	putSpan.Finish()
-	return config, nil
+
+	return redactConfig(stored), nil
 }
 
+// RollbackConfig publishes the entries of version from as the new version
+// ver. Versions are immutable, so this is how a bad change is reverted.
+func (cs *ConfigStore) RollbackConfig(ctx context.Context, id, from, ver string) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "RollbackConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	from, err := cs.ConfigVersion(childCtx, id, from)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// The secrets are decrypted to be sealed again under the new version.
+	source, err := cs.RevealConfig(childCtx, id, from)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	config := &Config{
+		ID:       id,
+		Version:  ver,
+		Entries:  source.Entries,
+		Metadata: map[string]string{MetaRollbackFrom: source.Version},
+		Secrets:  source.Secrets,
+		Parent:   source.Parent,
+	}
+	return cs.UpdateConfigVersion(childCtx, config)
+}
+
+// DeleteConfig moves the config version into a tombstone, from which
+// RestoreConfig can bring it back until the retention expires.
 func (cs *ConfigStore) DeleteConfig(ctx context.Context, id, ver string) (map[string]string, error) {
 	span := tracer.StartSpanFromContext(ctx, "DeleteConfig")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	deleteSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base delete")
-	kv := cs.cli.KV()
-	_, err := kv.Delete(constructConfigKey(childCtx, id, ver), nil)
+	key := constructConfigKey(childCtx, id, ver)
+
+	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
+	pair, err := cs.db.Get(ctx, key)
+	if err != nil || pair == nil {
+		tracer.LogError(getSpan, err)
+		return nil, ErrNotFound
+	}
+	getSpan.Finish()
+
+	tombstone, err := cs.tombstoneOp(childCtx, KindConfig, id, ver, []*KVPair{pair})
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// A malformed version has nothing in the index.
+	config := &Config{}
+	if err := json.Unmarshal(pair.Value, config); err != nil {
+		config = nil
+	}
+	index, undo, err := indexOps(childCtx, config, nil)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	deleteSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.txnIndexed(ctx, []*TxnOp{
+		{Verb: TxnDeleteCAS, Key: key, Index: pair.ModifyIndex},
+		tombstone,
+	}, index, undo)
 	if err != nil {
 		tracer.LogError(deleteSpan, err)
 		return nil, err
@@ -176,6 +425,18 @@ func (cs *ConfigStore) CreateGroup(ctx context.Context, group *Group) (*Group, e
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
+	err := validateVersion(group.Version, cs.semver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	err = cs.checkRefs(childCtx, group.Refs)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
 	sid, rid := generateGroupKey(childCtx, group.Version)
 	group.ID = rid
 
@@ -185,22 +446,20 @@ func (cs *ConfigStore) CreateGroup(ctx context.Context, group *Group) (*Group, e
 		return nil, err
 	}
 
-	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base put")
-	g := &api.KVPair{Key: sid, Value: data}
-	kv := cs.cli.KV()
-	_, err = kv.Put(g, nil)
-
+	ops, err := labelOps(childCtx, group, group.ID, group.Version)
 	if err != nil {
-		tracer.LogError(putSpan, err)
+		tracer.LogError(span, err)
 		return nil, err
 	}
-	putSpan.Finish()
+	ops = append([]*TxnOp{{Verb: TxnCAS, Key: sid, Value: data}}, ops...)
 
-	err = cs.CreateLabels(childCtx, group.Configs, group.ID, group.Version)
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.db.Txn(ctx, ops)
 	if err != nil {
-		tracer.LogError(span, err)
+		tracer.LogError(txnSpan, err)
 		return nil, err
 	}
+	txnSpan.Finish()
 
 	return group, nil
 }
@@ -211,15 +470,26 @@ func (cs *ConfigStore) FindGroup(ctx context.Context, id string, ver string) (*G
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
+	group, _, err := cs.findGroupPair(childCtx, id, ver)
+	return group, err
+}
+
+// findGroupPair returns the group together with the ModifyIndex of its
+// document, for callers that rewrite the document with a CAS.
+func (cs *ConfigStore) findGroupPair(ctx context.Context, id string, ver string) (*Group, uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "findGroupPair")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
 	key := constructGroupKey(childCtx, id, ver)
 
 	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
-	kv := cs.cli.KV()
-	data, _, err := kv.Get(key, nil)
+	data, err := cs.db.Get(ctx, key)
 
 	if err != nil || data == nil {
 		tracer.LogError(getSpan, err)
-		return nil, errors.New("That item does not exist!")
+		return nil, 0, ErrNotFound
 	}
 	getSpan.Finish()
 
@@ -227,135 +497,686 @@ func (cs *ConfigStore) FindGroup(ctx context.Context, id string, ver string) (*G
 	err = json.Unmarshal(data.Value, group)
 	if err != nil {
 		tracer.LogError(span, err)
+		return nil, 0, err
+	}
+
+	return group, data.ModifyIndex, nil
+}
+
+// FindGroupVersions returns every version of the group in version order.
+func (cs *ConfigStore) FindGroupVersions(ctx context.Context, id string) ([]*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindGroupVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
+	data, err := cs.db.List(ctx, constructGroupIdKey(childCtx, id))
+	if err != nil {
+		tracer.LogError(listSpan, err)
 		return nil, err
 	}
+	listSpan.Finish()
 
-	return group, nil
+	var groups []*Group
+	for _, pair := range data {
+		// Label keys live below the group versions.
+		if _, _, _, ok := parseVersionKey(pair.Key); !ok {
+			continue
+		}
+
+		group := &Group{}
+		err := json.Unmarshal(pair.Value, group)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		return compareVersions(groups[i].Version, groups[j].Version) < 0
+	})
+
+	return groups, nil
 }
 
-func (cs *ConfigStore) UpdateGroupVersion(ctx context.Context, group *Group) (*Group, error) {
-	span := tracer.StartSpanFromContext(ctx, "UpdateGroupVersion")
+// GroupVersion resolves LatestVersion to the newest existing version of the
+// group. Any other version is returned as is.
+func (cs *ConfigStore) GroupVersion(ctx context.Context, id, ver string) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "GroupVersion")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	data, err := json.Marshal(group)
+	if ver != LatestVersion {
+		return ver, nil
+	}
+
+	groups, err := cs.FindGroupVersions(childCtx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		return "", err
+	}
+	if len(groups) == 0 {
+		return "", ErrNotFound
+	}
+	return groups[len(groups)-1].Version, nil
+}
+
+// ResolveGroup looks up the config every reference of the group points at.
+// References to versions that were deleted are reported as dangling.
+func (cs *ConfigStore) ResolveGroup(ctx context.Context, group *Group) (*ResolvedGroup, error) {
+	span := tracer.StartSpanFromContext(ctx, "ResolveGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	resolved := &ResolvedGroup{Group: group}
+	for _, ref := range group.Refs {
+		config, err := cs.findRef(childCtx, ref)
+		resolved.Refs = append(resolved.Refs, &ResolvedRef{
+			ConfigRef: ref,
+			Config:    config,
+			Dangling:  err != nil,
+		})
+	}
+	return resolved, nil
+}
+
+func (cs *ConfigStore) findRef(ctx context.Context, ref *ConfigRef) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "findRef")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	ver, err := cs.ConfigVersion(childCtx, ref.ID, ref.Version)
 	if err != nil {
+		tracer.LogError(span, err)
 		return nil, err
 	}
+	return cs.FindConfig(childCtx, ref.ID, ver)
+}
+
+// checkRefs makes sure every reference points at an existing config when
+// the group is written.
+func (cs *ConfigStore) checkRefs(ctx context.Context, refs []*ConfigRef) error {
+	span := tracer.StartSpanFromContext(ctx, "checkRefs")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	for _, ref := range refs {
+		if ref == nil || ref.ID == "" || ref.Version == "" {
+			return errors.New("Config reference needs an id and a version")
+		}
+		if _, err := cs.findRef(childCtx, ref); err != nil {
+			return fmt.Errorf("%w: %s/%s", ErrDanglingRef, ref.ID, ref.Version)
+		}
+	}
+	return nil
+}
 
-	_, err = cs.FindGroup(childCtx, group.ID, group.Version)
+func (cs *ConfigStore) UpdateGroupVersion(ctx context.Context, group *Group) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "UpdateGroupVersion")
+	defer span.Finish()
 
-	//Does exist
-	if err == nil {
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	return cs.putGroup(childCtx, group)
+}
+
+// putGroup writes a new version of an existing group ID, together with
+// extra ops in the same transaction.
+func (cs *ConfigStore) putGroup(ctx context.Context, group *Group, extra ...*TxnOp) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "putGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	err := validateVersion(group.Version, cs.semver)
+	if err != nil {
 		tracer.LogError(span, err)
-		return nil, errors.New("Given group version already exists! ")
+		return nil, err
 	}
 
-	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base put")
+	err = cs.checkRefs(childCtx, group.Refs)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
 
-	c := &api.KVPair{Key: constructGroupKey(childCtx, group.ID, group.Version), Value: data}
-	kv := cs.cli.KV()
-	_, err = kv.Put(c, nil)
+	data, err := json.Marshal(group)
 	if err != nil {
-		tracer.LogError(putSpan, err)
 		return nil, err
 	}
-	putSpan.Finish()
 
-	err = cs.CreateLabels(childCtx, group.Configs, group.ID, group.Version)
+	sid := constructGroupKey(childCtx, group.ID, group.Version)
+
+	ops, err := labelOps(childCtx, group, group.ID, group.Version)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	ops = append([]*TxnOp{{Verb: TxnCAS, Key: sid, Value: data}}, ops...)
+	ops = append(ops, extra...)
+
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.db.Txn(ctx, ops)
+	if err != nil {
+		tracer.LogError(txnSpan, err)
+		return nil, err
+	}
+	txnSpan.Finish()
 
 	return group, nil
 
 }
 
+// DeleteGroup moves the group version into a tombstone and deletes its
+// label document, see DeleteConfig.
 func (cs *ConfigStore) DeleteGroup(ctx context.Context, id, ver string) error {
 	span := tracer.StartSpanFromContext(ctx, "DeleteGroup")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	deleteSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base delete")
-	kv := cs.cli.KV()
-	_, err := kv.DeleteTree(constructGroupKey(childCtx, id, ver), nil)
+	key := constructGroupKey(childCtx, id, ver)
+
+	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
+	pair, err := cs.db.Get(ctx, key)
+	if err != nil || pair == nil {
+		tracer.LogError(getSpan, err)
+		return ErrNotFound
+	}
+	getSpan.Finish()
+
+	// The label document is rebuilt from the group document on restore.
+	tombstone, err := cs.tombstoneOp(childCtx, KindGroup, id, ver, []*KVPair{pair})
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	// Deleting the tree under key itself would also take every version
+	// starting with ver (v1 -> v10), so only the labels below key+"/" go.
+	// Label changes always CAS the group key, so the delete-cas fails if
+	// the group changed after it was read.
+	deleteSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.db.Txn(ctx, []*TxnOp{
+		{Verb: TxnDeleteCAS, Key: key, Index: pair.ModifyIndex},
+		{Verb: TxnDeleteTree, Key: key + "/"},
+		tombstone,
+	})
+	if err != nil {
+		tracer.LogError(deleteSpan, err)
+	}
 	deleteSpan.Finish()
 
 	return err
 }
 
-func (cs *ConfigStore) CreateLabels(ctx context.Context, configs []map[string]string, id, ver string) error {
-	span := tracer.StartSpanFromContext(ctx, "CreateLabels")
+// labelDoc is the label document of a group version: the labels of every
+// config and reference of the group and, per label, the members carrying
+// it. It is derived from the group document and written in the same
+// transaction, so a group version costs two ops however many members and
+// labels it has. Members are numbered inline configs first, then refs.
+type labelDoc struct {
+	Configs []map[string]string         `json:"configs"`
+	Refs    []map[string]string         `json:"refs,omitempty"`
+	Index   map[string]map[string][]int `json:"index"`
+}
+
+func newLabelDoc(group *Group) *labelDoc {
+	doc := &labelDoc{Configs: group.Configs, Index: make(map[string]map[string][]int)}
+	for _, ref := range group.Refs {
+		labels := ref.Labels
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		doc.Refs = append(doc.Refs, labels)
+	}
+
+	for i := 0; i < doc.size(); i++ {
+		for k, v := range doc.labels(i) {
+			if doc.Index[k] == nil {
+				doc.Index[k] = make(map[string][]int)
+			}
+			doc.Index[k][v] = append(doc.Index[k][v], i)
+		}
+	}
+	return doc
+}
+
+// size is the number of members of the group.
+func (doc *labelDoc) size() int {
+	return len(doc.Configs) + len(doc.Refs)
+}
+
+// labels returns the labels of member i.
+func (doc *labelDoc) labels(i int) map[string]string {
+	if i < len(doc.Configs) {
+		return doc.Configs[i]
+	}
+	return doc.Refs[i-len(doc.Configs)]
+}
+
+// labelOps returns the op writing the label document of a group version.
+func labelOps(ctx context.Context, group *Group, id, ver string) ([]*TxnOp, error) {
+	span := tracer.StartSpanFromContext(ctx, "labelOps")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	kv := cs.cli.KV()
-	if keys, _, err := kv.Get(constructGroupKey(childCtx, id, ver), nil); err != nil || keys == nil {
+	for _, config := range group.Configs {
+		if len(config) == 0 {
+			err := errors.New("Group config must have at least one label")
+			tracer.LogError(span, err)
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(newLabelDoc(group))
+	if err != nil {
 		tracer.LogError(span, err)
-		return errors.New("Group doesn't exists")
+		return nil, err
 	}
+	return []*TxnOp{{Verb: TxnSet, Key: constructGroupLabelsKey(childCtx, id, ver), Value: data}}, nil
+}
 
-	for _, config := range configs {
-		cid := constructGroupLabel(childCtx, id, ver, uuid.New().String(), config)
-		cdata, err := json.Marshal(config)
+// findLabelDoc reads the label document of a group version. Versions
+// written before there were label documents get one built from the group
+// document, and a missing version has no configs.
+func (cs *ConfigStore) findLabelDoc(ctx context.Context, id, ver string) (*labelDoc, error) {
+	span := tracer.StartSpanFromContext(ctx, "findLabelDoc")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
 
-		log.Default().Printf("adding new config: %q. under key %q", config, cdata)
+	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
+	pair, err := cs.db.Get(ctx, constructGroupLabelsKey(childCtx, id, ver))
+	if err != nil {
+		tracer.LogError(getSpan, err)
+		return nil, err
+	}
+	getSpan.Finish()
+
+	if pair == nil {
+		group, _, err := cs.findGroupPair(childCtx, id, ver)
+		if errors.Is(err, ErrNotFound) {
+			return newLabelDoc(&Group{}), nil
+		}
 		if err != nil {
 			tracer.LogError(span, err)
-			return err
+			return nil, err
 		}
+		return newLabelDoc(group), nil
+	}
+
+	doc := &labelDoc{}
+	if err := json.Unmarshal(pair.Value, doc); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return doc, nil
+}
+
+// BuildLabelIndex writes the label document of every group version in the
+// namespace and environment of ctx that has none yet, and deletes the label
+// keys earlier layouts kept below the version. It only runs once per scope.
+func (cs *ConfigStore) BuildLabelIndex(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "BuildLabelIndex")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	marker := scopePrefix(childCtx) + labelsMarker
+	pair, err := cs.db.Get(ctx, marker)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if pair != nil {
+		return nil
+	}
+
+	root := constructGroupRoot(childCtx)
+	pairs, err := cs.db.List(ctx, root)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	var groups []*KVPair
+	hasDoc := make(map[string]bool)
+	var stale []*TxnOp
+	for _, pair := range pairs {
+		parts := strings.Split(strings.TrimPrefix(pair.Key, root), "/")
+		switch {
+		case len(parts) == 2:
+			groups = append(groups, pair)
+		case len(parts) == 3 && parts[2] == "labels":
+			hasDoc[root+parts[0]+"/"+parts[1]] = true
+		default:
+			stale = append(stale, &TxnOp{Verb: TxnDelete, Key: pair.Key})
+		}
+	}
 
-		c := &api.KVPair{Key: cid, Value: cdata}
-		_, err = kv.Put(c, nil)
+	for _, pair := range groups {
+		if hasDoc[pair.Key] {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(pair.Key, root), "/")
+		group := &Group{}
+		if err := json.Unmarshal(pair.Value, group); err != nil {
+			log.Default().Printf("skipping malformed group %q", pair.Key)
+			continue
+		}
+		data, err := json.Marshal(newLabelDoc(group))
 		if err != nil {
 			tracer.LogError(span, err)
 			return err
 		}
+		// Both CAS ops fail if a label change wrote the document meanwhile.
+		ops := []*TxnOp{
+			{Verb: TxnCAS, Key: constructGroupLabelsKey(childCtx, parts[0], parts[1]), Value: data},
+			{Verb: TxnCAS, Key: pair.Key, Value: pair.Value, Index: pair.ModifyIndex},
+		}
+		if err := cs.db.Txn(ctx, ops); err != nil && !errors.Is(err, ErrConflict) {
+			tracer.LogError(span, err)
+			return err
+		}
 	}
-	return nil
+
+	if err := cs.txnBatches(childCtx, stale); err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	return cs.db.Put(ctx, &KVPair{Key: marker, Value: []byte("1")})
 }
 
+// FindLabels returns the configs of the group version whose labels are
+// exactly kvpairs, an encoded query such as env=prod&tier=web.
 func (cs *ConfigStore) FindLabels(ctx context.Context, id, ver, kvpairs string) ([]map[string]string, error) {
 	span := tracer.StartSpanFromContext(ctx, "FindLabels")
 	defer span.Finish()
 
-	kv := cs.cli.KV()
-	labelkey := fmt.Sprintf(group, id, ver, kvpairs) + "/"
-	keys, _, err := kv.List(labelkey, nil)
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	query, err := url.ParseQuery(kvpairs)
 	if err != nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	labels := make(map[string]string, len(query))
+	for k := range query {
+		labels[k] = query.Get(k)
+	}
 
-	configs := make([]map[string]string, len(keys))
-	for i, k := range keys {
-		var config map[string]string
-		json.Unmarshal(k.Value, &config)
-		log.Default().Printf("%q", config)
-		configs[i] = config
+	doc, err := cs.findLabelDoc(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
 	}
 
+	configs := []map[string]string{}
+	for _, entry := range doc.findEntries(labels) {
+		if len(entry.labels) == len(labels) {
+			configs = append(configs, entry.labels)
+		}
+	}
 	return configs, nil
 }
 
+// FindLabelsMatching returns every config of the group version whose labels
+// contain all of the given labels. No labels returns the whole group.
+func (cs *ConfigStore) FindLabelsMatching(ctx context.Context, id, ver string, labels map[string]string) ([]map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindLabelsMatching")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	doc, err := cs.findLabelDoc(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return entryLabels(doc.findEntries(labels)), nil
+}
+
+// FindLabelsSelector returns the configs of the group version matching sel.
+func (cs *ConfigStore) FindLabelsSelector(ctx context.Context, id, ver string, sel *Selector) ([]map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindLabelsSelector")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	doc, err := cs.findLabelDoc(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return entryLabels(doc.selectEntries(sel)), nil
+}
+
+// groupEntry is one member of a group version together with its position
+// in the label document.
+type groupEntry struct {
+	index  int
+	labels map[string]string
+}
+
+// findEntries intersects the index lists of the given labels, the members
+// come back in group order.
+func (doc *labelDoc) findEntries(labels map[string]string) []*groupEntry {
+	var matched []int
+	if len(labels) == 0 {
+		matched = make([]int, doc.size())
+		for i := range matched {
+			matched[i] = i
+		}
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for i, k := range keys {
+		found := doc.Index[k][labels[k]]
+		if i == 0 {
+			matched = found
+		} else {
+			matched = intersect(matched, found)
+		}
+		if len(matched) == 0 {
+			break
+		}
+	}
+
+	entries := make([]*groupEntry, 0, len(matched))
+	for _, i := range matched {
+		if i >= 0 && i < doc.size() {
+			entries = append(entries, &groupEntry{index: i, labels: doc.labels(i)})
+		}
+	}
+	return entries
+}
+
+// intersect returns the ints found in both a and b, which are sorted.
+func intersect(a, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// selectEntries looks the k=v requirements of sel up in the label index and
+// checks the rest against the entries found.
+func (doc *labelDoc) selectEntries(sel *Selector) []*groupEntry {
+	labels, ok := sel.equalities()
+	if !ok {
+		return nil
+	}
+
+	var entries []*groupEntry
+	for _, entry := range doc.findEntries(labels) {
+		if sel.Matches(entry.labels) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func entryLabels(entries []*groupEntry) []map[string]string {
+	configs := make([]map[string]string, len(entries))
+	for i, entry := range entries {
+		configs[i] = entry.labels
+	}
+	return configs
+}
+
+// RemoveLabelsFromGroup removes every config and reference matching sel
+// from the group version, both from the group document and from its label
+// document, and returns the labels of the removed members.
+func (cs *ConfigStore) RemoveLabelsFromGroup(ctx context.Context, id, ver string, sel *Selector) ([]map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "RemoveLabelsFromGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	gr, index, err := cs.findGroupPair(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	entries := newLabelDoc(gr).selectEntries(sel)
+	if len(entries) == 0 {
+		return nil, ErrNoMatch
+	}
+
+	removed := make(map[int]bool, len(entries))
+	for _, entry := range entries {
+		removed[entry.index] = true
+	}
+	configs := []map[string]string{}
+	for i, config := range gr.Configs {
+		if !removed[i] {
+			configs = append(configs, config)
+		}
+	}
+	var refs []*ConfigRef
+	for i, ref := range gr.Refs {
+		if !removed[len(gr.Configs)+i] {
+			refs = append(refs, ref)
+		}
+	}
+	gr.Configs, gr.Refs = configs, refs
+
+	ops, err := labelOps(childCtx, gr, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	data, err := json.Marshal(gr)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	sid := constructGroupKey(childCtx, id, ver)
+	ops = append([]*TxnOp{{Verb: TxnCAS, Key: sid, Value: data, Index: index}}, ops...)
+
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.db.Txn(ctx, ops)
+	if err != nil {
+		tracer.LogError(txnSpan, err)
+		return nil, err
+	}
+	txnSpan.Finish()
+
+	return entryLabels(entries), nil
+}
+
+// ReplaceLabelsInGroup replaces the single config matching sel with config.
+// A matching reference keeps pointing at its config and gets config as its
+// labels.
+func (cs *ConfigStore) ReplaceLabelsInGroup(ctx context.Context, id, ver string, sel *Selector, config map[string]string) (map[string]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ReplaceLabelsInGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	gr, index, err := cs.findGroupPair(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	entries := newLabelDoc(gr).selectEntries(sel)
+	if len(entries) == 0 {
+		return nil, ErrNoMatch
+	}
+	if len(entries) > 1 {
+		return nil, ErrAmbiguousMatch
+	}
+	if i := entries[0].index; i < len(gr.Configs) {
+		gr.Configs[i] = config
+	} else {
+		gr.Refs[i-len(gr.Configs)].Labels = config
+	}
+
+	ops, err := labelOps(childCtx, gr, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	data, err := json.Marshal(gr)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	sid := constructGroupKey(childCtx, id, ver)
+	ops = append([]*TxnOp{{Verb: TxnCAS, Key: sid, Value: data, Index: index}}, ops...)
+
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.db.Txn(ctx, ops)
+	if err != nil {
+		tracer.LogError(txnSpan, err)
+		return nil, err
+	}
+	txnSpan.Finish()
+
+	return entries[0].labels, nil
+}
+
 func (cs *ConfigStore) AddLabelsToGroup(ctx context.Context, configs []map[string]string, id, ver string) ([]map[string]string, error) {
 	span := tracer.StartSpanFromContext(ctx, "AddLabelsToGroup")
 	defer span.Finish()
 
 	childCtx := tracer.ContextWithSpan(ctx, span)
 
-	gr, err := cs.FindGroup(childCtx, id, ver)
+	gr, index, err := cs.findGroupPair(childCtx, id, ver)
 	if err != nil || gr == nil {
 		tracer.LogError(span, err)
 		return nil, err
 	}
 
 	for _, config := range configs {
-		log.Default().Printf("%q", configs)
 		gr.Configs = append(gr.Configs, config)
 	}
 
@@ -367,21 +1188,22 @@ func (cs *ConfigStore) AddLabelsToGroup(ctx context.Context, configs []map[strin
 
 	sid := constructGroupKey(childCtx, id, ver)
 
-	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base put")
-	kv := cs.cli.KV()
-	g := &api.KVPair{Key: sid, Value: data}
-	_, err = kv.Put(g, nil)
+	ops, err := labelOps(childCtx, gr, gr.ID, gr.Version)
 	if err != nil {
-		tracer.LogError(putSpan, err)
+		tracer.LogError(span, err)
 		return nil, err
 	}
-	putSpan.Finish()
+	// A concurrent add rewrote the document since we read it, fail with
+	// ErrConflict instead of dropping its configs.
+	ops = append([]*TxnOp{{Verb: TxnCAS, Key: sid, Value: data, Index: index}}, ops...)
 
-	err = cs.CreateLabels(childCtx, configs, gr.ID, gr.Version)
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.db.Txn(ctx, ops)
 	if err != nil {
-		tracer.LogError(span, err)
+		tracer.LogError(txnSpan, err)
 		return nil, err
 	}
+	txnSpan.Finish()
 
 	return gr.Configs, nil
 }
@@ -394,11 +1216,10 @@ func (cs *ConfigStore) SaveRequestId(ctx context.Context) string {
 
 	reqId := generateRequestId(childCtx)
 
-	i := &api.KVPair{Key: reqId, Value: nil}
+	i := &KVPair{Key: constructRequestIdKey(childCtx, reqId), Value: nil}
 
 	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base put")
-	kv := cs.cli.KV()
-	_, err := kv.Put(i, nil)
+	err := cs.db.Put(ctx, i)
 
 	if err != nil {
 		tracer.LogError(putSpan, err)
@@ -413,11 +1234,7 @@ func (cs *ConfigStore) FindRequestId(ctx context.Context, requestId string) bool
 	span := tracer.StartSpanFromContext(ctx, "FindRequestId")
 	defer span.Finish()
 
-	kv := cs.cli.KV()
-	key, _, err := kv.Get(requestId, nil)
-
-	fmt.Println(key)
-
+	key, err := cs.db.Get(ctx, constructRequestIdKey(tracer.ContextWithSpan(ctx, span), requestId))
 	if err != nil || key == nil {
 		tracer.LogError(span, err)
 		return false
@@ -425,3 +1242,85 @@ func (cs *ConfigStore) FindRequestId(ctx context.Context, requestId string) bool
 
 	return true
 }
+
+func (cs *ConfigStore) WaitConfVersions(ctx context.Context, id string, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WaitConfVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	return cs.wait(childCtx, constructConfigIdKey(childCtx, id)+"/", index, wait)
+}
+
+func (cs *ConfigStore) WaitGroupVersions(ctx context.Context, id string, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WaitGroupVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	return cs.wait(childCtx, constructGroupIdKey(childCtx, id), index, wait)
+}
+
+func (cs *ConfigStore) WaitConfig(ctx context.Context, id, ver string, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WaitConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if ver == LatestVersion {
+		return cs.wait(childCtx, constructConfigIdKey(childCtx, id)+"/", index, wait)
+	}
+	return cs.waitKey(childCtx, constructConfigKey(childCtx, id, ver), index, wait)
+}
+
+func (cs *ConfigStore) WaitGroup(ctx context.Context, id, ver string, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WaitGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if ver == LatestVersion {
+		return cs.wait(childCtx, constructGroupIdKey(childCtx, id), index, wait)
+	}
+	return cs.waitKey(childCtx, constructGroupKey(childCtx, id, ver), index, wait)
+}
+
+func (cs *ConfigStore) WaitLabels(ctx context.Context, id, ver string, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "WaitLabels")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	prefix := constructGroupKey(childCtx, id, ver) + "/"
+	if ver == LatestVersion {
+		prefix = constructGroupIdKey(childCtx, id)
+	}
+	return cs.wait(childCtx, prefix, index, wait)
+}
+
+// wait blocks until something under prefix changes after index. Running
+// out of wait is not an error, the caller just reads the unchanged state.
+func (cs *ConfigStore) wait(ctx context.Context, prefix string, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "Base watch")
+	defer span.Finish()
+
+	current, err := cs.db.Watch(ctx, prefix, index, wait)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+	return current, nil
+}
+
+// waitKey is wait for a single key.
+func (cs *ConfigStore) waitKey(ctx context.Context, key string, index uint64, wait time.Duration) (uint64, error) {
+	span := tracer.StartSpanFromContext(ctx, "Base watch")
+	defer span.Finish()
+
+	current, err := cs.db.WatchKey(ctx, key, index, wait)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+	return current, nil
+}
@@ -0,0 +1,169 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// lostRace is a backend where another writer creates every key a CAS is
+// about to create, right before the transaction commits.
+type lostRace struct {
+	Backend
+}
+
+func (lr lostRace) Txn(ctx context.Context, ops []*TxnOp) error {
+	for _, op := range ops {
+		if op.Verb == TxnCAS && op.Index == 0 {
+			if err := lr.Backend.Put(ctx, &KVPair{Key: op.Key, Value: []byte(`{"winner": true}`)}); err != nil {
+				return err
+			}
+		}
+	}
+	return lr.Backend.Txn(ctx, ops)
+}
+
+func TestNewVersionLosesRace(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := cs.CreateGroup(ctx, &Group{Version: "v1", Configs: []map[string]string{{"env": "prod"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cs.db = lostRace{cs.db}
+	_, err = cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v2", Entries: config.Entries})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("config version: err = %v, want ErrConflict", err)
+	}
+	_, err = cs.UpdateGroupVersion(ctx, &Group{ID: group.ID, Version: "v2", Configs: group.Configs})
+	if !errors.Is(err, ErrConflict) {
+		t.Errorf("group version: err = %v, want ErrConflict", err)
+	}
+
+	// The other writer's versions are left as they are.
+	for _, key := range []string{constructConfigKey(ctx, config.ID, "v2"), constructGroupKey(ctx, group.ID, "v2")} {
+		pair, err := cs.db.Get(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pair == nil || string(pair.Value) != `{"winner": true}` {
+			t.Errorf("%s = %+v, want the other writer's value", key, pair)
+		}
+	}
+}
+
+func TestWaitOnlyWakesForItsKeys(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := cs.CreateGroup(ctx, &Group{Version: "v1", Configs: []map[string]string{{"env": "prod"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mb := cs.db.(consulLimits).Backend.(*memoryBackend)
+
+	tests := []struct {
+		name string
+		wait func(index uint64) (uint64, error)
+		// neighbour is a key sharing the prefix of the watched ones.
+		neighbour string
+	}{
+		{"config version", func(index uint64) (uint64, error) {
+			return cs.WaitConfig(ctx, config.ID, "v1", index, 50*time.Millisecond)
+		}, constructConfigKey(ctx, config.ID, "v10")},
+		{"config versions", func(index uint64) (uint64, error) {
+			return cs.WaitConfVersions(ctx, config.ID, index, 50*time.Millisecond)
+		}, constructConfigIdKey(ctx, config.ID+"x") + "/v1"},
+		{"group version", func(index uint64) (uint64, error) {
+			return cs.WaitGroup(ctx, group.ID, "v1", index, 50*time.Millisecond)
+		}, constructGroupKey(ctx, group.ID, "v1-beta")},
+		{"group versions", func(index uint64) (uint64, error) {
+			return cs.WaitGroupVersions(ctx, group.ID, index, 50*time.Millisecond)
+		}, constructGroupIdKey(ctx, group.ID+"x") + "v1"},
+		{"latest group", func(index uint64) (uint64, error) {
+			return cs.WaitGroup(ctx, group.ID, LatestVersion, index, 50*time.Millisecond)
+		}, constructGroupIdKey(ctx, group.ID+"x") + "v1"},
+		{"labels", func(index uint64) (uint64, error) {
+			return cs.WaitLabels(ctx, group.ID, LatestVersion, index, 50*time.Millisecond)
+		}, constructGroupIdKey(ctx, group.ID+"x") + "/v1/labels"},
+	}
+	for _, tt := range tests {
+		index := mb.lastIndex()
+		if err := cs.db.Put(ctx, &KVPair{Key: tt.neighbour, Value: []byte(`{}`)}); err != nil {
+			t.Fatal(err)
+		}
+		written := mb.lastIndex()
+		got, err := tt.wait(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got >= written {
+			t.Errorf("%s: woke up at %d for the write of %s", tt.name, got, tt.neighbour)
+		}
+	}
+
+	// A write to the watched key itself does wake it.
+	index := mb.lastIndex()
+	if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cs.WaitConfig(ctx, config.ID, "v1", index, time.Second); err != nil || got <= index {
+		t.Errorf("after a delete: woke up at %d, %v, want past %d", got, err, index)
+	}
+}
+
+func TestRollbackConfig(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"good"`), "port": Value(`80`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v2", Entries: map[string]Value{"k": Value(`"bad"`)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cs.RollbackConfig(ctx, config.ID, "v1", "v3"); err != nil {
+		t.Fatal(err)
+	}
+	rolled, err := cs.FindConfig(ctx, config.ID, "v3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolled.Entries) != 2 || rolled.Entries["k"].Text() != "good" || string(rolled.Entries["port"]) != "80" {
+		t.Errorf("v3 entries = %v, want those of v1", rolled.Entries)
+	}
+	if rolled.Metadata[MetaRollbackFrom] != "v1" {
+		t.Errorf("v3 metadata = %v, want %s=v1", rolled.Metadata, MetaRollbackFrom)
+	}
+
+	// Rolling back the latest version records the version it resolved to.
+	if _, err := cs.RollbackConfig(ctx, config.ID, LatestVersion, "v4"); err != nil {
+		t.Fatal(err)
+	}
+	if rolled, err := cs.FindConfig(ctx, config.ID, "v4"); err != nil || rolled.Metadata[MetaRollbackFrom] != "v3" {
+		t.Errorf("v4 = %+v, %v, want it rolled back from v3", rolled, err)
+	}
+
+	if _, err := cs.RollbackConfig(ctx, config.ID, "v9", "v5"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing source: err = %v, want ErrNotFound", err)
+	}
+	if _, err := cs.RollbackConfig(ctx, config.ID, "v1", "v2"); !errors.Is(err, ErrConflict) {
+		t.Errorf("existing version: err = %v, want ErrConflict", err)
+	}
+	if v2, err := cs.FindConfig(ctx, config.ID, "v2"); err != nil || v2.Entries["k"].Text() != "bad" {
+		t.Errorf("v2 = %+v, %v, want it left alone", v2, err)
+	}
+}
@@ -0,0 +1,166 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"strings"
+	"time"
+)
+
+const (
+	// Consul rejects transactions with more operations than this.
+	maxConsulTxnOps = 64
+)
+
+type consulBackend struct {
+	kv *api.KV
+}
+
+func NewConsulBackend(address string) (Backend, error) {
+	config := api.DefaultConfig()
+	config.Address = address
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &consulBackend{
+		kv: client.KV(),
+	}, nil
+}
+
+func (cb *consulBackend) Get(ctx context.Context, key string) (*KVPair, error) {
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	pair, _, err := cb.kv.Get(key, q)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+	return fromConsulPair(pair), nil
+}
+
+func (cb *consulBackend) Put(ctx context.Context, p *KVPair) error {
+	w := (&api.WriteOptions{}).WithContext(ctx)
+	_, err := cb.kv.Put(&api.KVPair{Key: p.Key, Value: p.Value}, w)
+	return err
+}
+
+func (cb *consulBackend) List(ctx context.Context, prefix string) ([]*KVPair, error) {
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	pairs, _, err := cb.kv.List(prefix, q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*KVPair, len(pairs))
+	for i, pair := range pairs {
+		result[i] = fromConsulPair(pair)
+	}
+	return result, nil
+}
+
+func (cb *consulBackend) Keys(ctx context.Context, prefix, separator string) ([]string, error) {
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	keys, _, err := cb.kv.Keys(prefix, separator, q)
+	return keys, err
+}
+
+// ListIndexes has to list the values as well, Consul has no listing of
+// keys with their indexes. They are dropped right away.
+func (cb *consulBackend) ListIndexes(ctx context.Context, prefix string) ([]*KVPair, error) {
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	pairs, _, err := cb.kv.List(prefix, q)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*KVPair, len(pairs))
+	for i, pair := range pairs {
+		result[i] = &KVPair{Key: pair.Key, ModifyIndex: pair.ModifyIndex}
+	}
+	return result, nil
+}
+
+func (cb *consulBackend) Delete(ctx context.Context, key string) error {
+	w := (&api.WriteOptions{}).WithContext(ctx)
+	_, err := cb.kv.Delete(key, w)
+	return err
+}
+
+func (cb *consulBackend) DeleteTree(ctx context.Context, prefix string) error {
+	w := (&api.WriteOptions{}).WithContext(ctx)
+	_, err := cb.kv.DeleteTree(prefix, w)
+	return err
+}
+
+// Watch runs a blocking query. Only keys are fetched since the caller reads
+// the values it needs afterwards.
+func (cb *consulBackend) Watch(ctx context.Context, prefix string, index uint64, wait time.Duration) (uint64, error) {
+	q := (&api.QueryOptions{WaitIndex: index, WaitTime: wait}).WithContext(ctx)
+	_, meta, err := cb.kv.Keys(prefix, "", q)
+	if err != nil {
+		return 0, err
+	}
+	return meta.LastIndex, nil
+}
+
+func (cb *consulBackend) WatchKey(ctx context.Context, key string, index uint64, wait time.Duration) (uint64, error) {
+	q := (&api.QueryOptions{WaitIndex: index, WaitTime: wait}).WithContext(ctx)
+	_, meta, err := cb.kv.Get(key, q)
+	if err != nil {
+		return 0, err
+	}
+	return meta.LastIndex, nil
+}
+
+func (cb *consulBackend) Txn(ctx context.Context, ops []*TxnOp) error {
+	if len(ops) > maxConsulTxnOps {
+		return fmt.Errorf("transaction has %d operations, Consul allows at most %d", len(ops), maxConsulTxnOps)
+	}
+
+	txn := make(api.KVTxnOps, len(ops))
+	for i, op := range ops {
+		txn[i] = &api.KVTxnOp{
+			Verb:  api.KVOp(op.Verb),
+			Key:   op.Key,
+			Value: op.Value,
+			Index: op.Index,
+		}
+	}
+
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	ok, resp, _, err := cb.kv.Txn(txn, q)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return txnError(ops, resp)
+	}
+	return nil
+}
+
+// txnError turns the errors of a rolled back transaction into one error,
+// reporting ErrConflict when it was a CAS or delete-cas op that failed.
+func txnError(ops []*TxnOp, resp *api.KVTxnResponse) error {
+	if resp == nil || len(resp.Errors) == 0 {
+		return errors.New("transaction rolled back")
+	}
+
+	msgs := make([]string, len(resp.Errors))
+	for i, e := range resp.Errors {
+		if e.OpIndex < len(ops) && (ops[e.OpIndex].Verb == TxnCAS || ops[e.OpIndex].Verb == TxnDeleteCAS) {
+			return fmt.Errorf("%w: %s", ErrConflict, ops[e.OpIndex].Key)
+		}
+		msgs[i] = fmt.Sprintf("op %d: %s", e.OpIndex, e.What)
+	}
+	return fmt.Errorf("transaction rolled back: %s", strings.Join(msgs, "; "))
+}
+
+func fromConsulPair(pair *api.KVPair) *KVPair {
+	return &KVPair{
+		Key:         pair.Key,
+		Value:       pair.Value,
+		ModifyIndex: pair.ModifyIndex,
+	}
+}
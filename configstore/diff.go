@@ -0,0 +1,252 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ValueChange struct {
+	From Value `json:"from"`
+	To   Value `json:"to"`
+}
+
+// ConfigDiff lists the entries added, removed and changed between two
+// versions of a config.
+type ConfigDiff struct {
+	ID      string                 `json:"id"`
+	From    string                 `json:"from"`
+	To      string                 `json:"to"`
+	Added   map[string]Value       `json:"added"`
+	Removed map[string]Value       `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+}
+
+// GroupDiff lists the label sets and references added and removed between
+// two versions of a group. Label sets are compared as a whole, so a changed
+// label shows up as one removed and one added set. References are compared
+// by id, version and labels the same way.
+type GroupDiff struct {
+	ID          string              `json:"id"`
+	From        string              `json:"from"`
+	To          string              `json:"to"`
+	Added       []map[string]string `json:"added"`
+	Removed     []map[string]string `json:"removed"`
+	AddedRefs   []*ConfigRef        `json:"addedRefs"`
+	RemovedRefs []*ConfigRef        `json:"removedRefs"`
+}
+
+func (cs *ConfigStore) DiffConfigs(ctx context.Context, id, from, to string) (*ConfigDiff, error) {
+	span := tracer.StartSpanFromContext(ctx, "DiffConfigs")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	configs := make([]*Config, 2)
+	for i, ver := range []string{from, to} {
+		ver, err := cs.ConfigVersion(childCtx, id, ver)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		// Secrets are compared decrypted so a changed secret shows up, but
+		// their values are redacted in the diff.
+		configs[i], err = cs.RevealConfig(childCtx, id, ver)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+	}
+
+	diff := &ConfigDiff{
+		ID:      id,
+		From:    configs[0].Version,
+		To:      configs[1].Version,
+		Added:   make(map[string]Value),
+		Removed: make(map[string]Value),
+		Changed: make(map[string]ValueChange),
+	}
+	for k, v := range configs[0].Entries {
+		w, ok := configs[1].Entries[k]
+		switch {
+		case !ok:
+			diff.Removed[k] = redactValue(configs[0], k, v)
+		case !v.Equal(w):
+			diff.Changed[k] = ValueChange{From: redactValue(configs[0], k, v), To: redactValue(configs[1], k, w)}
+		}
+	}
+	for k, w := range configs[1].Entries {
+		if _, ok := configs[0].Entries[k]; !ok {
+			diff.Added[k] = redactValue(configs[1], k, w)
+		}
+	}
+
+	return diff, nil
+}
+
+func (cs *ConfigStore) DiffGroups(ctx context.Context, id, from, to string) (*GroupDiff, error) {
+	span := tracer.StartSpanFromContext(ctx, "DiffGroups")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	groups := make([]*Group, 2)
+	for i, ver := range []string{from, to} {
+		ver, err := cs.GroupVersion(childCtx, id, ver)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		groups[i], err = cs.FindGroup(childCtx, id, ver)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+	}
+
+	diff := &GroupDiff{
+		ID:          id,
+		From:        groups[0].Version,
+		To:          groups[1].Version,
+		Added:       []map[string]string{},
+		Removed:     []map[string]string{},
+		AddedRefs:   []*ConfigRef{},
+		RemovedRefs: []*ConfigRef{},
+	}
+
+	keys := make([][]string, 2)
+	for i, group := range groups {
+		for _, config := range group.Configs {
+			keys[i] = append(keys[i], LabelString(config))
+		}
+	}
+	added, removed := diffMultisets(keys[0], keys[1])
+	for _, i := range added {
+		diff.Added = append(diff.Added, groups[1].Configs[i])
+	}
+	for _, i := range removed {
+		diff.Removed = append(diff.Removed, groups[0].Configs[i])
+	}
+
+	keys = make([][]string, 2)
+	for i, group := range groups {
+		for _, ref := range group.Refs {
+			keys[i] = append(keys[i], refString(ref))
+		}
+	}
+	added, removed = diffMultisets(keys[0], keys[1])
+	for _, i := range added {
+		diff.AddedRefs = append(diff.AddedRefs, groups[1].Refs[i])
+	}
+	for _, i := range removed {
+		diff.RemovedRefs = append(diff.RemovedRefs, groups[0].Refs[i])
+	}
+
+	return diff, nil
+}
+
+// diffMultisets returns the positions of the keys only found in to and of
+// those only found in from. The same label set may be in a group more than
+// once, so keys are compared as multisets.
+func diffMultisets(from, to []string) ([]int, []int) {
+	counts := make(map[string]int)
+	for _, key := range from {
+		counts[key]++
+	}
+
+	var added []int
+	for i, key := range to {
+		if counts[key] > 0 {
+			counts[key]--
+			continue
+		}
+		added = append(added, i)
+	}
+
+	var removed []int
+	for i, key := range from {
+		if counts[key] > 0 {
+			counts[key]--
+			removed = append(removed, i)
+		}
+	}
+	return added, removed
+}
+
+// refString is the canonical id/version?labels form of a reference.
+func refString(ref *ConfigRef) string {
+	s := ref.ID + "/" + ref.Version
+	if len(ref.Labels) > 0 {
+		s += "?" + LabelString(ref.Labels)
+	}
+	return s
+}
+
+// Unified renders the diff as text, one "key=value" line per entry with -
+// for the old and + for the new version. Values are written as JSON.
+func (d *ConfigDiff) Unified() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- config/%s/%s\n+++ config/%s/%s\n", d.ID, d.From, d.ID, d.To)
+
+	keys := make([]string, 0, len(d.Added)+len(d.Removed)+len(d.Changed))
+	for _, m := range []map[string]Value{d.Added, d.Removed} {
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	for k := range d.Changed {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if v, ok := d.Removed[k]; ok {
+			fmt.Fprintf(&b, "-%s=%s\n", k, v)
+		}
+		if c, ok := d.Changed[k]; ok {
+			fmt.Fprintf(&b, "-%s=%s\n+%s=%s\n", k, c.From, k, c.To)
+		}
+		if v, ok := d.Added[k]; ok {
+			fmt.Fprintf(&b, "+%s=%s\n", k, v)
+		}
+	}
+	return b.String()
+}
+
+// Unified renders the diff as text, one line per label set and one
+// "ref config/id/version?labels" line per reference.
+func (d *GroupDiff) Unified() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- group/%s/%s\n+++ group/%s/%s\n", d.ID, d.From, d.ID, d.To)
+
+	for _, config := range d.Removed {
+		fmt.Fprintf(&b, "-%s\n", LabelString(config))
+	}
+	for _, config := range d.Added {
+		fmt.Fprintf(&b, "+%s\n", LabelString(config))
+	}
+	for _, ref := range d.RemovedRefs {
+		fmt.Fprintf(&b, "-ref config/%s\n", refString(ref))
+	}
+	for _, ref := range d.AddedRefs {
+		fmt.Fprintf(&b, "+ref config/%s\n", refString(ref))
+	}
+	return b.String()
+}
+
+// LabelString is the canonical k=v&k=v form of a label set, sorted by key.
+func LabelString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + labels[k]
+	}
+	return strings.Join(pairs, "&")
+}
@@ -0,0 +1,76 @@
+package configstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDiffGroups(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := func(ver string, labels map[string]string) *ConfigRef {
+		return &ConfigRef{ID: config.ID, Version: ver, Labels: labels}
+	}
+
+	group, err := cs.CreateGroup(ctx, &Group{
+		Version: "v1",
+		Configs: []map[string]string{{"env": "prod"}, {"env": "prod"}, {"env": "dev"}},
+		Refs: []*ConfigRef{
+			ref("v1", map[string]string{"tier": "web"}),
+			ref("v1", map[string]string{"tier": "db"}),
+			ref(LatestVersion, nil),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cs.UpdateGroupVersion(ctx, &Group{
+		ID:      group.ID,
+		Version: "v2",
+		Configs: []map[string]string{{"env": "prod"}, {"env": "dev", "tier": "web"}},
+		Refs: []*ConfigRef{
+			ref("v1", map[string]string{"tier": "web"}),
+			ref("v1", map[string]string{"tier": "cache"}),
+			ref(LatestVersion, nil),
+			ref(LatestVersion, nil),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := cs.DiffGroups(ctx, group.ID, "v1", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := config.ID
+	want := strings.Join([]string{
+		"--- group/" + group.ID + "/v1",
+		"+++ group/" + group.ID + "/v2",
+		"-env=prod",
+		"-env=dev",
+		"+env=dev&tier=web",
+		"-ref config/" + id + "/v1?tier=db",
+		"+ref config/" + id + "/v1?tier=cache",
+		"+ref config/" + id + "/latest",
+		"",
+	}, "\n")
+	if got := diff.Unified(); got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+
+	same, err := cs.DiffGroups(ctx, group.ID, "v2", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(same.Added)+len(same.Removed)+len(same.AddedRefs)+len(same.RemovedRefs) != 0 {
+		t.Errorf("diff of a version with itself = %+v", same)
+	}
+}
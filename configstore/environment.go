@@ -0,0 +1,270 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// MetaPromotedFrom is the metadata key holding the environment a
+	// promoted config was copied from.
+	MetaPromotedFrom = "promotedFrom"
+
+	defaultEnvironments = "dev,staging,prod"
+)
+
+var (
+	ErrNoEnvironment   = errors.New("Promotion needs an environment to promote from")
+	ErrLastEnvironment = errors.New("There is no environment to promote to")
+	ErrNoPromoter      = errors.New("Promotion needs promotedBy")
+)
+
+// Promotion records a config or group version copied from one environment
+// to the next one.
+type Promotion struct {
+	Kind       string    `json:"kind"`
+	ID         string    `json:"id"`
+	Version    string    `json:"version"`
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	PromotedBy string    `json:"promotedBy"`
+	PromotedAt time.Time `json:"promotedAt"`
+}
+
+type environmentCtxKey struct{}
+
+// WithEnvironment returns ctx with the environment every store call made
+// with it works in.
+func WithEnvironment(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, environmentCtxKey{}, name)
+}
+
+// EnvironmentFrom returns the environment of ctx, "" if it has none.
+func EnvironmentFrom(ctx context.Context) string {
+	name, _ := ctx.Value(environmentCtxKey{}).(string)
+	return name
+}
+
+// parseEnvironments reads ENVIRONMENTS, the environments in the order
+// versions are promoted through them.
+func parseEnvironments() ([]string, error) {
+	value, ok := os.LookupEnv("ENVIRONMENTS")
+	if !ok {
+		value = defaultEnvironments
+	}
+
+	var envs []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !namespaceName.MatchString(name) {
+			return nil, fmt.Errorf("invalid environment %q in ENVIRONMENTS, expected lowercase letters, digits and dashes", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("environment %q is listed twice in ENVIRONMENTS", name)
+		}
+		seen[name] = true
+		envs = append(envs, name)
+	}
+	return envs, nil
+}
+
+// Environments returns the environments in promotion order.
+func (cs *ConfigStore) Environments() []string {
+	return append([]string(nil), cs.environments...)
+}
+
+// EnvironmentExists reports whether name is one of the environments.
+func (cs *ConfigStore) EnvironmentExists(name string) bool {
+	for _, env := range cs.environments {
+		if env == name {
+			return true
+		}
+	}
+	return false
+}
+
+// nextEnvironment returns the environment versions of the one in ctx are
+// promoted to.
+func (cs *ConfigStore) nextEnvironment(ctx context.Context) (string, error) {
+	from := EnvironmentFrom(ctx)
+	if from == "" {
+		return "", ErrNoEnvironment
+	}
+	for i, env := range cs.environments {
+		if env == from && i+1 < len(cs.environments) {
+			return cs.environments[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("%w after %s", ErrLastEnvironment, from)
+}
+
+// PromoteConfig copies a config version of the environment in ctx into the
+// next environment under the same ID and version. It fails with ErrConflict
+// if the next environment has that version already.
+func (cs *ConfigStore) PromoteConfig(ctx context.Context, id, ver, by string) (*Promotion, error) {
+	span := tracer.StartSpanFromContext(ctx, "PromoteConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if by == "" {
+		return nil, ErrNoPromoter
+	}
+	to, err := cs.nextEnvironment(childCtx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	ver, err = cs.ConfigVersion(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// The secrets are decrypted to be sealed again in the next environment.
+	source, err := cs.RevealConfig(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	promotion := &Promotion{
+		Kind:       KindConfig,
+		ID:         id,
+		Version:    ver,
+		From:       EnvironmentFrom(childCtx),
+		To:         to,
+		PromotedBy: by,
+		PromotedAt: time.Now().UTC(),
+	}
+	record, err := cs.promotionOp(childCtx, promotion)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	config := &Config{
+		ID:       id,
+		Version:  ver,
+		Entries:  source.Entries,
+		Metadata: map[string]string{MetaPromotedFrom: promotion.From},
+		Secrets:  source.Secrets,
+		Parent:   source.Parent,
+	}
+	if _, err := cs.putConfig(WithEnvironment(childCtx, to), config, record); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return promotion, nil
+}
+
+// PromoteGroup copies a group version like PromoteConfig. The configs it
+// references have to be promoted first.
+func (cs *ConfigStore) PromoteGroup(ctx context.Context, id, ver, by string) (*Promotion, error) {
+	span := tracer.StartSpanFromContext(ctx, "PromoteGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if by == "" {
+		return nil, ErrNoPromoter
+	}
+	to, err := cs.nextEnvironment(childCtx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	ver, err = cs.GroupVersion(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	group, err := cs.FindGroup(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	promotion := &Promotion{
+		Kind:       KindGroup,
+		ID:         id,
+		Version:    ver,
+		From:       EnvironmentFrom(childCtx),
+		To:         to,
+		PromotedBy: by,
+		PromotedAt: time.Now().UTC(),
+	}
+	record, err := cs.promotionOp(childCtx, promotion)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	if _, err := cs.putGroup(WithEnvironment(childCtx, to), group, record); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return promotion, nil
+}
+
+// promotionOp writes the record of a promotion in the same transaction as
+// the promoted version.
+func (cs *ConfigStore) promotionOp(ctx context.Context, promotion *Promotion) (*TxnOp, error) {
+	span := tracer.StartSpanFromContext(ctx, "promotionOp")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	data, err := json.Marshal(promotion)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	key := constructPromotionKey(childCtx, promotion.ID, promotion.PromotedAt)
+	return &TxnOp{Verb: TxnCAS, Key: key, Value: data}, nil
+}
+
+// FindPromotions returns the promotions of a config or group ID, as kind
+// tells, in every environment, oldest first. A config and a group may share
+// an ID, their records are told apart by their kind.
+func (cs *ConfigStore) FindPromotions(ctx context.Context, kind, id string) ([]*Promotion, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindPromotions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
+	data, err := cs.db.List(ctx, constructPromotionIdKey(childCtx, id))
+	if err != nil {
+		tracer.LogError(listSpan, err)
+		return nil, err
+	}
+	listSpan.Finish()
+
+	promotions := []*Promotion{}
+	for _, pair := range data {
+		promotion := &Promotion{}
+		if err := json.Unmarshal(pair.Value, promotion); err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if promotion.Kind != kind {
+			continue
+		}
+		promotions = append(promotions, promotion)
+	}
+
+	sort.SliceStable(promotions, func(i, j int) bool {
+		return promotions[i].PromotedAt.Before(promotions[j].PromotedAt)
+	})
+	return promotions, nil
+}
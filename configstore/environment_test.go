@@ -0,0 +1,106 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPromoteConfig(t *testing.T) {
+	cs := newSecretStore(t)
+	dev := WithEnvironment(context.Background(), "dev")
+	staging := WithEnvironment(context.Background(), "staging")
+
+	config := createSecret(t, cs, dev)
+	promotion, err := cs.PromoteConfig(dev, config.ID, LatestVersion, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promotion.Kind != KindConfig || promotion.Version != "v1" || promotion.From != "dev" || promotion.To != "staging" || promotion.PromotedBy != "alice" {
+		t.Errorf("promotion = %+v", promotion)
+	}
+
+	promoted, err := cs.FindConfig(staging, config.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if promoted.Metadata[MetaPromotedFrom] != "dev" || promoted.Entries["user"].Text() != "admin" || !promoted.IsSecret("password") {
+		t.Errorf("promoted %+v", promoted)
+	}
+
+	// The secret is sealed again for the environment it was promoted to.
+	revealed, err := cs.RevealConfig(staging, config.ID, "v1")
+	if err != nil || revealed.Entries["password"].Text() != "hunter2" {
+		t.Errorf("revealed in staging: %v, %v", revealed, err)
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		id   string
+		ver  string
+		by   string
+		want error
+	}{
+		{"again", dev, config.ID, "v1", "alice", ErrConflict},
+		{"without promoter", dev, config.ID, "v1", "", ErrNoPromoter},
+		{"without environment", context.Background(), config.ID, "v1", "alice", ErrNoEnvironment},
+		{"from the last environment", WithEnvironment(context.Background(), "prod"), config.ID, "v1", "alice", ErrLastEnvironment},
+		{"missing version", dev, config.ID, "v9", "alice", ErrNotFound},
+	}
+	for _, tt := range tests {
+		if _, err := cs.PromoteConfig(tt.ctx, tt.id, tt.ver, tt.by); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if promotions, err := cs.FindPromotions(dev, KindConfig, config.ID); err != nil || len(promotions) != 1 {
+		t.Errorf("promotions = %v, %v, want the one that succeeded", promotions, err)
+	}
+}
+
+func TestPromoteGroup(t *testing.T) {
+	cs := newTestStore()
+	dev := WithEnvironment(context.Background(), "dev")
+	staging := WithEnvironment(context.Background(), "staging")
+
+	group, err := cs.CreateGroup(dev, &Group{Version: "v1", Configs: []map[string]string{{"env": "prod"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.PromoteGroup(dev, group.ID, "v1", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.PromoteGroup(staging, group.ID, "v1", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	promoted, err := cs.FindGroup(WithEnvironment(context.Background(), "prod"), group.ID, "v1")
+	if err != nil || len(promoted.Configs) != 1 || promoted.Configs[0]["env"] != "prod" {
+		t.Errorf("promoted to prod: %+v, %v", promoted, err)
+	}
+
+	// A config with the ID of the group has a history of its own.
+	if _, err := cs.UpdateConfigVersion(dev, &Config{ID: group.ID, Version: "v1", Entries: map[string]Value{"k": Value(`1`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.PromoteConfig(dev, group.ID, "v1", "carol"); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := cs.FindPromotions(dev, KindGroup, group.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].To != "staging" || groups[1].To != "prod" {
+		t.Errorf("group promotions = %+v, want dev to staging, then staging to prod", groups)
+	}
+	for _, p := range groups {
+		if p.Kind != KindGroup {
+			t.Errorf("group history has %+v", p)
+		}
+	}
+	configs, err := cs.FindPromotions(dev, KindConfig, group.ID)
+	if err != nil || len(configs) != 1 || configs[0].PromotedBy != "carol" {
+		t.Errorf("config promotions = %+v, %v, want only the config", configs, err)
+	}
+}
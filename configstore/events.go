@@ -0,0 +1,375 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EventCreate     = "create"
+	EventNewVersion = "new-version"
+	EventUpdate     = "update"
+	EventDelete     = "delete"
+
+	eventWait  = 5 * time.Minute
+	eventRetry = time.Second
+)
+
+var eventRoots = []string{"config/", "group/"}
+
+// EventID is the position of an event in the stream, sent to clients as
+// "<index>-<seq>" so they can resume after it.
+type EventID struct {
+	Index uint64
+	Seq   int
+}
+
+func (id EventID) String() string {
+	return fmt.Sprintf("%d-%d", id.Index, id.Seq)
+}
+
+// EventID returns the position of event.
+func (e *Event) EventID() EventID {
+	return EventID{Index: e.Index, Seq: e.Seq}
+}
+
+// ParseEventID parses an ID sent by EventID.String. A bare index, as sent
+// before events had a sequence number, stands for every event at it.
+func ParseEventID(s string) (EventID, error) {
+	parts := strings.SplitN(s, "-", 2)
+	index, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return EventID{}, errors.New("event ID must be <index> or <index>-<seq>")
+	}
+	if len(parts) == 1 {
+		return EventID{Index: index, Seq: math.MaxInt32}, nil
+	}
+	seq, err := strconv.Atoi(parts[1])
+	if err != nil || seq < 0 {
+		return EventID{}, errors.New("event ID must be <index> or <index>-<seq>")
+	}
+	return EventID{Index: index, Seq: seq}, nil
+}
+
+// after reports whether id comes after other in the stream.
+func (id EventID) after(other EventID) bool {
+	if id.Index != other.Index {
+		return id.Index > other.Index
+	}
+	return id.Seq > other.Seq
+}
+
+// Events streams changes to configs and groups of the namespace and environment in ctx under
+// prefix, which must be empty or start with config/ or group/. Changes are found by watching the
+// store, so writes made by other replicas show up as well. With a non zero
+// after every version written after that event is sent first; deletes made
+// before the call can not be replayed. The channel is closed once ctx is
+// done.
+func (cs *ConfigStore) Events(ctx context.Context, prefix string, after EventID) (<-chan *Event, error) {
+	span := tracer.StartSpanFromContext(ctx, "Events")
+	defer span.Finish()
+
+	roots, err := eventPrefixes(prefix)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	events := make(chan *Event)
+	go func() {
+		cs.watchEvents(ctx, roots, after, events)
+		close(events)
+	}()
+
+	return events, nil
+}
+
+func eventPrefixes(prefix string) ([]string, error) {
+	if prefix == "" {
+		return eventRoots, nil
+	}
+	for _, root := range eventRoots {
+		if prefix+"/" == root {
+			return []string{root}, nil
+		}
+		if strings.HasPrefix(prefix, root) {
+			return []string{prefix}, nil
+		}
+	}
+	return nil, errors.New("prefix must start with config/ or group/")
+}
+
+// watchEvents diffs the versions under roots every time the store reports
+// a change and sends an event for every difference. All roots are diffed
+// together so events sharing an index are numbered once.
+func (cs *ConfigStore) watchEvents(ctx context.Context, roots []string, after EventID, events chan<- *Event) {
+	prefixes := make([]string, len(roots))
+	for i, root := range roots {
+		prefixes[i] = scopePrefix(ctx) + root
+	}
+	what := strings.Join(prefixes, ", ")
+
+	var current uint64
+	var pairs []*KVPair
+	for {
+		var err error
+		current, pairs, err = cs.listEvents(ctx, prefixes, true)
+		if err == nil {
+			break
+		}
+		if !retryEvents(ctx, what, err) {
+			return
+		}
+	}
+
+	// A new subscriber only wants what happens from now on.
+	if after.Index == 0 {
+		after = EventID{Index: current, Seq: math.MaxInt32}
+	}
+	known := make(map[string]uint64)
+	batch := diffVersions(known, trimScope(ctx, pairs), after, current)
+	if !sendEvents(ctx, events, batch) {
+		return
+	}
+	last := after.Index
+	if len(batch) > 0 {
+		last = batch[len(batch)-1].Index
+	}
+
+	changes := make(chan uint64, len(prefixes))
+	for _, prefix := range prefixes {
+		go watchPrefix(ctx, cs.db, prefix, current, changes)
+	}
+
+	for {
+		select {
+		case index := <-changes:
+			if index > current {
+				current = index
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			var err error
+			_, pairs, err = cs.listEvents(ctx, prefixes, false)
+			if err == nil {
+				break
+			}
+			if !retryEvents(ctx, what, err) {
+				return
+			}
+		}
+
+		// Deletes are reported at the index of the change that woke us,
+		// which may already have been sent if the delete came after it.
+		// Every write up to the delete is in pairs, so last+1 is free.
+		index := current
+		if index <= last {
+			index = last + 1
+		}
+		batch := diffVersions(known, trimScope(ctx, pairs), EventID{}, index)
+		if !sendEvents(ctx, events, batch) {
+			return
+		}
+		if len(batch) > 0 {
+			last = batch[len(batch)-1].Index
+		}
+	}
+}
+
+// listEvents lists the keys under prefixes with their ModifyIndex, with
+// watch also the current index of the store below them. Values are not
+// needed to tell what changed, so they are not read.
+func (cs *ConfigStore) listEvents(ctx context.Context, prefixes []string, watch bool) (uint64, []*KVPair, error) {
+	var current uint64
+	if watch {
+		for _, prefix := range prefixes {
+			index, err := cs.db.Watch(ctx, prefix, 0, 0)
+			if err != nil {
+				return 0, nil, err
+			}
+			if index > current {
+				current = index
+			}
+		}
+	}
+
+	var pairs []*KVPair
+	for _, prefix := range prefixes {
+		list, err := cs.db.ListIndexes(ctx, prefix)
+		if err != nil {
+			return 0, nil, err
+		}
+		pairs = append(pairs, list...)
+	}
+	return current, pairs, nil
+}
+
+// watchPrefix sends the index of prefix on changes every time it moves
+// past index, the index the caller last listed at, until ctx is done.
+func watchPrefix(ctx context.Context, db Backend, prefix string, index uint64, changes chan<- uint64) {
+	for {
+		current, err := db.Watch(ctx, prefix, index, eventWait)
+		if err != nil {
+			if !retryEvents(ctx, prefix, err) {
+				return
+			}
+			continue
+		}
+		// Either the wait passed or, when current went back, the store was
+		// reset and watching from current blocks again.
+		if current <= index {
+			index = current
+			continue
+		}
+		index = current
+
+		select {
+		case changes <- current:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// diffVersions updates known (key -> ModifyIndex of every version document)
+// to pairs and returns what changed after since, numbered and in stream
+// order. Deletes are reported at index, after the versions written at it.
+// Versions sharing an index are ordered by key so a replay numbers them the
+// same way as the live stream did.
+func diffVersions(known map[string]uint64, pairs []*KVPair, since EventID, index uint64) []*Event {
+	ids := make(map[string]bool)
+	for key := range known {
+		kind, id, _, _ := parseVersionKey(key)
+		ids[kind+"/"+id] = true
+	}
+
+	var created []*KVPair
+	seen := make(map[string]bool)
+	var events []*Event
+	for _, pair := range pairs {
+		kind, id, ver, ok := parseVersionKey(pair.Key)
+		if !ok {
+			continue
+		}
+		seen[pair.Key] = true
+
+		old, exists := known[pair.Key]
+		known[pair.Key] = pair.ModifyIndex
+		switch {
+		case !exists && pair.ModifyIndex >= since.Index:
+			created = append(created, pair)
+		case !exists:
+			ids[kind+"/"+id] = true
+		case old != pair.ModifyIndex:
+			events = append(events, &Event{Index: pair.ModifyIndex, Type: EventUpdate, Kind: kind, ID: id, Version: ver})
+		}
+	}
+
+	sort.Slice(created, func(i, j int) bool {
+		if created[i].ModifyIndex != created[j].ModifyIndex {
+			return created[i].ModifyIndex < created[j].ModifyIndex
+		}
+		return created[i].Key < created[j].Key
+	})
+	for _, pair := range created {
+		kind, id, ver, _ := parseVersionKey(pair.Key)
+		typ := EventNewVersion
+		if !ids[kind+"/"+id] {
+			typ = EventCreate
+			ids[kind+"/"+id] = true
+		}
+		events = append(events, &Event{Index: pair.ModifyIndex, Type: typ, Kind: kind, ID: id, Version: ver})
+	}
+
+	var deleted []*Event
+	for key := range known {
+		if seen[key] {
+			continue
+		}
+		delete(known, key)
+		kind, id, ver, _ := parseVersionKey(key)
+		deleted = append(deleted, &Event{Index: index, Type: EventDelete, Kind: kind, ID: id, Version: ver})
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return eventBefore(events[i], events[j])
+	})
+	sort.Slice(deleted, func(i, j int) bool {
+		return eventBefore(deleted[i], deleted[j])
+	})
+	events = append(events, deleted...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Index < events[j].Index
+	})
+
+	var batch []*Event
+	for i, event := range events {
+		if i > 0 && events[i-1].Index == event.Index {
+			event.Seq = events[i-1].Seq + 1
+		}
+		if event.EventID().after(since) {
+			batch = append(batch, event)
+		}
+	}
+	return batch
+}
+
+// eventBefore orders events of one kind of change by index and key.
+func eventBefore(a, b *Event) bool {
+	if a.Index != b.Index {
+		return a.Index < b.Index
+	}
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+	return a.Version < b.Version
+}
+
+// parseVersionKey splits a config/{id}/{ver} or group/{id}/{ver} key. Label
+// keys below a group version are not versions.
+func parseVersionKey(key string) (string, string, string, bool) {
+	parts := strings.Split(key, "/")
+	if len(parts) != 3 || (parts[0] != "config" && parts[0] != "group") {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+func sendEvents(ctx context.Context, events chan<- *Event, batch []*Event) bool {
+	for _, event := range batch {
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+func retryEvents(ctx context.Context, prefix string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	log.Default().Printf("watching %q failed, retrying: %v", prefix, err)
+
+	select {
+	case <-time.After(eventRetry):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,183 @@
+package configstore
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingWatch is a backend counting its calls of Watch.
+type countingWatch struct {
+	Backend
+	watches *int64
+}
+
+func (cw countingWatch) Watch(ctx context.Context, prefix string, index uint64, wait time.Duration) (uint64, error) {
+	atomic.AddInt64(cw.watches, 1)
+	return cw.Backend.Watch(ctx, prefix, index, wait)
+}
+
+func putVersions(t *testing.T, db Backend, keys ...string) {
+	t.Helper()
+
+	var ops []*TxnOp
+	for _, key := range keys {
+		ops = append(ops, &TxnOp{Verb: TxnSet, Key: key, Value: []byte(`{}`)})
+	}
+	if err := db.Txn(context.Background(), ops); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nextEvents reads n events or fails after a second.
+func nextEvents(t *testing.T, events <-chan *Event, n int) []string {
+	t.Helper()
+
+	var got []string
+	for len(got) < n {
+		select {
+		case event := <-events:
+			got = append(got, event.EventID().String()+" "+event.Type+" "+event.Kind+"/"+event.ID+"/"+event.Version)
+		case <-time.After(time.Second):
+			t.Fatalf("got events %q, want %d", got, n)
+		}
+	}
+	return got
+}
+
+func equalEvents(t *testing.T, got, want []string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got events %q, want %q", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseEventID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want EventID
+		ok   bool
+	}{
+		{"12-3", EventID{Index: 12, Seq: 3}, true},
+		{"12-0", EventID{Index: 12}, true},
+		{"12", EventID{Index: 12, Seq: 1<<31 - 1}, true},
+		{"", EventID{}, false},
+		{"x-1", EventID{}, false},
+		{"12-", EventID{}, false},
+		{"12--1", EventID{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseEventID(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseEventID(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestEventsResume(t *testing.T) {
+	db := NewMemoryBackend()
+	cs := NewWithBackend(db)
+
+	putVersions(t, db, "config/a/v1")
+	putVersions(t, db, "config/c/v1", "config/b/v1", "group/g/v1", "group/g/v1/labels")
+	putVersions(t, db, "config/a/v2")
+	last := "3-0 new-version config/a/v2"
+
+	tests := []struct {
+		after string
+		want  []string
+	}{
+		{"1", []string{"2-0 create config/b/v1", "2-1 create config/c/v1", "2-2 create group/g/v1", last}},
+		{"1-0", []string{"2-0 create config/b/v1", "2-1 create config/c/v1", "2-2 create group/g/v1", last}},
+		{"2-0", []string{"2-1 create config/c/v1", "2-2 create group/g/v1", last}},
+		{"2-2", []string{last}},
+		{"2", []string{last}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.after, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			after, err := ParseEventID(tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			events, err := cs.Events(ctx, "", after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			equalEvents(t, nextEvents(t, events, len(tt.want)), tt.want)
+		})
+	}
+}
+
+func TestEventsUniqueInTransaction(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := NewMemoryBackend()
+	cs := NewWithBackend(db)
+	putVersions(t, db, "config/a/v1")
+	putVersions(t, db, "config/b/v1")
+
+	events, err := cs.Events(ctx, "", EventID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Let the stream list the store before it changes.
+	time.Sleep(50 * time.Millisecond)
+
+	err = db.Txn(ctx, []*TxnOp{
+		{Verb: TxnDelete, Key: "config/a/v1"},
+		{Verb: TxnDelete, Key: "config/b/v1"},
+		{Verb: TxnSet, Key: "config/d/v1", Value: []byte(`{}`)},
+		{Verb: TxnSet, Key: "group/g/v1", Value: []byte(`{}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	equalEvents(t, nextEvents(t, events, 4), []string{
+		"3-0 create config/d/v1",
+		"3-1 create group/g/v1",
+		"3-2 delete config/a/v1",
+		"3-3 delete config/b/v1",
+	})
+}
+
+func TestEventsBlockOnEmptyPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db := NewMemoryBackend()
+	var watches int64
+	cs := NewWithBackend(countingWatch{db, &watches})
+
+	events, err := cs.Events(ctx, "config/a", EventID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Writes elsewhere do not wake the stream either.
+	putVersions(t, db, "config/b/v1")
+	time.Sleep(100 * time.Millisecond)
+
+	// One watch to list the prefix, one blocking on it.
+	if n := atomic.LoadInt64(&watches); n > 2 {
+		t.Errorf("watched an empty prefix %d times, want it to block", n)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("got event %+v on an empty prefix", event)
+	default:
+	}
+
+	putVersions(t, db, "config/a/v1")
+	equalEvents(t, nextEvents(t, events, 1), []string{"2-0 create config/a/v1"})
+}
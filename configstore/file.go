@@ -0,0 +1,356 @@
+package configstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	recordHeaderSize = 8
+	maxRecordSize    = 64 << 20
+
+	// The log is rewritten once it holds this many more records than live
+	// keys, so small stores stay small and big ones are not rewritten on
+	// every write.
+	compactMinRecords = 1000
+)
+
+// errShortRecord is returned by readRecord for a record running past the
+// end of the log.
+var errShortRecord = errors.New("record runs past the end of the log")
+
+// logRecord is one committed write. Every record is framed on disk as
+// [length uint32][crc32 uint32][json payload] so a torn write at the tail of
+// the file can be detected and dropped on the next open.
+type logRecord struct {
+	Index uint64   `json:"index"`
+	Ops   []*TxnOp `json:"ops"`
+}
+
+// fileBackend is a single node backend persisted to an append-only log.
+// The whole keyspace is served from memory; the log is only read on open.
+// Each commit is fsynced before it becomes visible to readers.
+type fileBackend struct {
+	mu      sync.Mutex
+	mem     *memoryBackend
+	path    string
+	file    *os.File
+	records int
+}
+
+func NewFileBackend(path string) (Backend, error) {
+	fb := &fileBackend{
+		mem:  NewMemoryBackend().(*memoryBackend),
+		path: path,
+	}
+
+	// A leftover compaction file means we crashed before the rename, the
+	// original log is still complete.
+	os.Remove(fb.compactPath())
+
+	if err := fb.recover(); err != nil {
+		return nil, err
+	}
+	if fb.needsCompaction() {
+		if err := fb.compact(); err != nil {
+			fb.file.Close()
+			return nil, err
+		}
+	}
+	return fb, nil
+}
+
+func (fb *fileBackend) Get(ctx context.Context, key string) (*KVPair, error) {
+	return fb.mem.Get(ctx, key)
+}
+
+func (fb *fileBackend) Put(ctx context.Context, p *KVPair) error {
+	return fb.Txn(ctx, []*TxnOp{{Verb: TxnSet, Key: p.Key, Value: p.Value}})
+}
+
+func (fb *fileBackend) List(ctx context.Context, prefix string) ([]*KVPair, error) {
+	return fb.mem.List(ctx, prefix)
+}
+
+func (fb *fileBackend) Keys(ctx context.Context, prefix, separator string) ([]string, error) {
+	return fb.mem.Keys(ctx, prefix, separator)
+}
+
+func (fb *fileBackend) ListIndexes(ctx context.Context, prefix string) ([]*KVPair, error) {
+	return fb.mem.ListIndexes(ctx, prefix)
+}
+
+func (fb *fileBackend) Delete(ctx context.Context, key string) error {
+	return fb.Txn(ctx, []*TxnOp{{Verb: TxnDelete, Key: key}})
+}
+
+func (fb *fileBackend) DeleteTree(ctx context.Context, prefix string) error {
+	return fb.Txn(ctx, []*TxnOp{{Verb: TxnDeleteTree, Key: prefix}})
+}
+
+func (fb *fileBackend) Watch(ctx context.Context, prefix string, index uint64, wait time.Duration) (uint64, error) {
+	return fb.mem.Watch(ctx, prefix, index, wait)
+}
+
+func (fb *fileBackend) WatchKey(ctx context.Context, key string, index uint64, wait time.Duration) (uint64, error) {
+	return fb.mem.WatchKey(ctx, key, index, wait)
+}
+
+func (fb *fileBackend) Txn(ctx context.Context, ops []*TxnOp) error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	if err := validateTxn(ops); err != nil {
+		return err
+	}
+	if err := fb.mem.check(ops); err != nil {
+		return err
+	}
+
+	offset, err := fb.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	rec := &logRecord{Index: fb.mem.lastIndex() + 1, Ops: ops}
+	err = writeRecord(fb.file, rec)
+	if err == nil {
+		err = fb.file.Sync()
+	}
+	if err != nil {
+		// Do not leave a half written record behind, later appends would
+		// end up after it and be dropped by recover.
+		fb.file.Truncate(offset)
+		fb.file.Seek(offset, io.SeekStart)
+		return err
+	}
+	fb.mem.replay(rec.Index, rec.Ops)
+	fb.records++
+
+	if fb.needsCompaction() {
+		if err := fb.compact(); err != nil {
+			log.Default().Printf("compacting %q failed: %v", fb.path, err)
+		}
+	}
+	return nil
+}
+
+// Compact rewrites the log so that it holds a single record per live key.
+func (fb *fileBackend) Compact() error {
+	fb.mu.Lock()
+	defer fb.mu.Unlock()
+
+	return fb.compact()
+}
+
+// needsCompaction reports whether the log holds enough dead records to be
+// worth rewriting.
+func (fb *fileBackend) needsCompaction() bool {
+	return fb.records > compactMinRecords+2*fb.mem.size()
+}
+
+// recover replays the log into memory and opens it for appending. A bad
+// record that runs to the end of the file, or is followed by nothing but
+// zeros, is the tail of an interrupted write and is truncated. Anywhere
+// else it is corruption: valid records follow it, so opening fails instead
+// of dropping them.
+func (fb *fileBackend) recover() error {
+	file, err := os.OpenFile(fb.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			torn := errors.Is(err, errShortRecord) || offset+n >= info.Size()
+			if !torn {
+				torn, err = zeroTail(file, offset+n, err)
+			}
+			if !torn {
+				file.Close()
+				return fmt.Errorf("corrupt record at offset %d of %q: %w", offset, fb.path, err)
+			}
+			log.Default().Printf("dropping torn record at offset %d of %q: %v", offset, fb.path, err)
+			if err := file.Truncate(offset); err != nil {
+				file.Close()
+				return err
+			}
+			break
+		}
+		fb.mem.replay(rec.Index, rec.Ops)
+		fb.records++
+		offset += n
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	fb.file = file
+	return nil
+}
+
+// zeroTail reports whether the file holds only zero bytes from offset on,
+// what a crash can leave after preallocated blocks. cause is returned
+// unless reading fails.
+func zeroTail(file *os.File, offset int64, cause error) (bool, error) {
+	r := bufio.NewReader(io.NewSectionReader(file, offset, 1<<62))
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return true, cause
+		}
+		if err != nil {
+			return false, err
+		}
+		if b != 0 {
+			return false, cause
+		}
+	}
+}
+
+// compact writes the live keys to a fresh file and atomically renames it
+// over the log. Callers hold fb.mu.
+func (fb *fileBackend) compact() error {
+	tmp, err := os.OpenFile(fb.compactPath(), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	records, err := fb.writeSnapshot(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(fb.compactPath())
+		return err
+	}
+
+	if err := os.Rename(fb.compactPath(), fb.path); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := syncDir(filepath.Dir(fb.path)); err != nil {
+		log.Default().Printf("syncing directory of %q failed: %v", fb.path, err)
+	}
+
+	fb.file.Close()
+	fb.file = tmp
+	fb.records = records
+	return nil
+}
+
+// writeSnapshot writes one record per live key in ModifyIndex order followed
+// by an empty record carrying the last index, so replaying the snapshot
+// restores every ModifyIndex exactly.
+func (fb *fileBackend) writeSnapshot(w io.Writer) (int, error) {
+	pairs, err := fb.mem.List(context.Background(), "")
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].ModifyIndex < pairs[j].ModifyIndex
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, pair := range pairs {
+		rec := &logRecord{
+			Index: pair.ModifyIndex,
+			Ops:   []*TxnOp{{Verb: TxnSet, Key: pair.Key, Value: pair.Value}},
+		}
+		if err := writeRecord(bw, rec); err != nil {
+			return 0, err
+		}
+	}
+	if err := writeRecord(bw, &logRecord{Index: fb.mem.lastIndex()}); err != nil {
+		return 0, err
+	}
+	return len(pairs) + 1, bw.Flush()
+}
+
+func (fb *fileBackend) compactPath() string {
+	return fb.path + ".compact"
+}
+
+func writeRecord(w io.Writer, rec *logRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[recordHeaderSize:], payload)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// readRecord returns the next record and its size on disk. io.EOF is only
+// returned on a clean record boundary. A bad record still comes with the
+// size its header claims, so the caller can tell where it ends.
+func readRecord(r io.Reader) (*logRecord, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, fmt.Errorf("%w: short header", errShortRecord)
+		}
+		return nil, 0, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	n := int64(recordHeaderSize) + int64(size)
+	if size > maxRecordSize {
+		return nil, n, errors.New("record too large")
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, n, fmt.Errorf("%w: short payload", errShortRecord)
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return nil, n, errors.New("checksum mismatch")
+	}
+
+	rec := &logRecord{}
+	if err := json.Unmarshal(payload, rec); err != nil {
+		return nil, n, err
+	}
+	return rec, n, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,211 @@
+package configstore
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openFileBackend opens the log at path and closes it when the test ends.
+func openFileBackend(t *testing.T, path string) (*fileBackend, error) {
+	t.Helper()
+
+	db, err := NewFileBackend(path)
+	if err != nil {
+		return nil, err
+	}
+	fb := db.(*fileBackend)
+	t.Cleanup(func() { fb.file.Close() })
+	return fb, nil
+}
+
+// writeLog writes n keys to a fresh log and returns its path and the size
+// of the file after every write.
+func writeLog(t *testing.T, n int) (string, []int64) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "configstore.db")
+	fb, err := openFileBackend(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int64
+	for i := 0; i < n; i++ {
+		pair := &KVPair{Key: fmt.Sprintf("config/k%d", i), Value: []byte(fmt.Sprintf(`"v%d"`, i))}
+		if err := fb.Put(context.Background(), pair); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, info.Size())
+	}
+	fb.file.Close()
+	return path, sizes
+}
+
+func countKeys(t *testing.T, fb *fileBackend) int {
+	t.Helper()
+
+	pairs, err := fb.List(context.Background(), "config/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(pairs)
+}
+
+func TestFileBackendReopen(t *testing.T) {
+	path, sizes := writeLog(t, 3)
+
+	fb, err := openFileBackend(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countKeys(t, fb); n != 3 {
+		t.Errorf("got %d keys, want 3", n)
+	}
+	pair, err := fb.Get(context.Background(), "config/k1")
+	if err != nil || pair == nil || string(pair.Value) != `"v1"` {
+		t.Errorf("config/k1 = %+v, %v", pair, err)
+	}
+
+	// A small log is not rewritten on open.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != sizes[2] {
+		t.Errorf("log size = %d after open, want %d", info.Size(), sizes[2])
+	}
+}
+
+func TestFileBackendRecover(t *testing.T) {
+	tests := []struct {
+		name string
+		// damage changes the log of three records, sizes holds the file
+		// size after each of them.
+		damage func(data []byte, sizes []int64) []byte
+		// keep is the number of keys left, -1 if opening must fail.
+		keep int
+	}{
+		{
+			name: "short header at the tail",
+			damage: func(data []byte, sizes []int64) []byte {
+				return append(data, 0, 0, 0)
+			},
+			keep: 3,
+		},
+		{
+			name: "short payload at the tail",
+			damage: func(data []byte, sizes []int64) []byte {
+				return data[:sizes[2]-2]
+			},
+			keep: 2,
+		},
+		{
+			name: "bad checksum at the tail",
+			damage: func(data []byte, sizes []int64) []byte {
+				data[sizes[2]-2] ^= 0xff
+				return data
+			},
+			keep: 2,
+		},
+		{
+			name: "zeros after the last record",
+			damage: func(data []byte, sizes []int64) []byte {
+				return append(data, make([]byte, 4096)...)
+			},
+			keep: 3,
+		},
+		{
+			name: "bad checksum in the middle",
+			damage: func(data []byte, sizes []int64) []byte {
+				data[sizes[0]+recordHeaderSize+2] ^= 0xff
+				return data
+			},
+			keep: -1,
+		},
+		{
+			name: "bad length in the middle",
+			damage: func(data []byte, sizes []int64) []byte {
+				data[sizes[0]+3]++
+				return data
+			},
+			keep: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, sizes := writeLog(t, 3)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, tt.damage(data, sizes), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			fb, err := openFileBackend(t, path)
+			if tt.keep < 0 {
+				if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("offset %d", sizes[0])) {
+					t.Fatalf("open error = %v, want corruption at offset %d", err, sizes[0])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := countKeys(t, fb); n != tt.keep {
+				t.Errorf("got %d keys, want %d", n, tt.keep)
+			}
+
+			// The torn tail is gone, so later writes survive a reopen.
+			if err := fb.Put(context.Background(), &KVPair{Key: "config/new", Value: []byte(`"x"`)}); err != nil {
+				t.Fatal(err)
+			}
+			fb.file.Close()
+			fb, err = openFileBackend(t, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := countKeys(t, fb); n != tt.keep+1 {
+				t.Errorf("got %d keys after reopening, want %d", n, tt.keep+1)
+			}
+		})
+	}
+}
+
+func TestFileBackendCompact(t *testing.T) {
+	path, _ := writeLog(t, 3)
+
+	fb, err := openFileBackend(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := fb.Put(context.Background(), &KVPair{Key: "config/k0", Value: []byte(fmt.Sprintf(`"%d"`, i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fb.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if fb.records != 4 {
+		t.Errorf("records = %d after compacting, want 3 keys and the index record", fb.records)
+	}
+
+	fb.file.Close()
+	fb, err = openFileBackend(t, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := fb.Get(context.Background(), "config/k0")
+	if err != nil || pair == nil || string(pair.Value) != `"9"` {
+		t.Errorf("config/k0 = %+v, %v, want the last write", pair, err)
+	}
+}
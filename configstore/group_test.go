@@ -0,0 +1,391 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// consulLimits is a memory backend rejecting transactions Consul would
+// reject for their size.
+type consulLimits struct {
+	Backend
+}
+
+func (cl consulLimits) Txn(ctx context.Context, ops []*TxnOp) error {
+	if len(ops) > maxConsulTxnOps {
+		return fmt.Errorf("transaction has %d operations, Consul allows at most %d", len(ops), maxConsulTxnOps)
+	}
+	return cl.Backend.Txn(ctx, ops)
+}
+
+func newTestStore() *ConfigStore {
+	return NewWithBackend(consulLimits{NewMemoryBackend()})
+}
+
+// bigGroup returns n configs with three labels each.
+func bigGroup(n int) []map[string]string {
+	configs := make([]map[string]string, n)
+	for i := range configs {
+		configs[i] = map[string]string{
+			"env":  []string{"dev", "prod"}[i%2],
+			"tier": []string{"web", "db", "cache"}[i%3],
+			"name": fmt.Sprintf("c%d", i),
+		}
+	}
+	return configs
+}
+
+func mustSelector(t *testing.T, s string) *Selector {
+	t.Helper()
+
+	sel, err := ParseSelector(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sel
+}
+
+func TestLargeGroupFitsOneTransaction(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	group, err := cs.CreateGroup(ctx, &Group{Version: "v1", Configs: bigGroup(30)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.AddLabelsToGroup(ctx, bigGroup(40), group.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err := cs.FindLabelsMatching(ctx, group.ID, "v1", map[string]string{"env": "prod", "tier": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// i%6 == 3 among 0..29 and 0..39
+	if len(labels) != 5+7 {
+		t.Errorf("got %d configs, want 12", len(labels))
+	}
+
+	removed, err := cs.RemoveLabelsFromGroup(ctx, group.ID, "v1", mustSelector(t, "env=dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 35 {
+		t.Errorf("removed %d configs, want 35", len(removed))
+	}
+	if _, err := cs.ReplaceLabelsInGroup(ctx, group.ID, "v1", mustSelector(t, "name=c31,tier=db"), map[string]string{"name": "c31", "env": "staging"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cs.DeleteGroup(ctx, group.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if labels, _ := cs.FindLabelsMatching(ctx, group.ID, "v1", nil); len(labels) != 0 {
+		t.Errorf("deleted group still has %d configs", len(labels))
+	}
+	if _, err := cs.RestoreGroup(ctx, group.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		labels map[string]string
+		want   int
+	}{
+		{nil, 35},
+		{map[string]string{"env": "staging"}, 1},
+		{map[string]string{"env": "prod"}, 34},
+		{map[string]string{"env": "dev"}, 0},
+		{map[string]string{"name": "c31", "tier": "db"}, 0},
+	}
+	for _, tt := range tests {
+		labels, err := cs.FindLabelsMatching(ctx, group.ID, "v1", tt.labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(labels) != tt.want {
+			t.Errorf("%v: got %d configs after restore, want %d", tt.labels, len(labels), tt.want)
+		}
+	}
+}
+
+func TestFindLabels(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	group, err := cs.CreateGroup(ctx, &Group{Version: "v1", Configs: []map[string]string{
+		{"env": "prod", "tier": "web"},
+		{"env": "prod"},
+		{"env": "dev", "tier": "web"},
+		{"env": "a&b=c"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		exact   string
+		labels  map[string]string
+		matches int
+	}{
+		{"one label", "env=prod", map[string]string{"env": "prod"}, 2},
+		{"two labels", "env=prod&tier=web", map[string]string{"env": "prod", "tier": "web"}, 1},
+		{"unknown value", "env=staging", map[string]string{"env": "staging"}, 0},
+		{"unknown key", "zone=eu", map[string]string{"zone": "eu"}, 0},
+		{"escaped value", "env=a%26b%3Dc", map[string]string{"env": "a&b=c"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exact, err := cs.FindLabels(ctx, group.ID, "v1", tt.exact)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, labels := range exact {
+				if len(labels) != len(tt.labels) {
+					t.Errorf("exact match %v has other labels than %v", labels, tt.labels)
+				}
+			}
+
+			matching, err := cs.FindLabelsMatching(ctx, group.ID, "v1", tt.labels)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matching) != tt.matches {
+				t.Errorf("got %d matching configs, want %d", len(matching), tt.matches)
+			}
+		})
+	}
+}
+
+// Group versions written before the label document existed have none, they
+// are answered from the group document.
+func TestFindLabelsWithoutLabelDocument(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	data, err := json.Marshal(&Group{ID: "old", Version: "v1", Configs: []map[string]string{
+		{"env": "prod", "tier": "web"},
+		{"env": "dev"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.db.Put(ctx, &KVPair{Key: "group/old/v1", Value: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err := cs.FindLabelsMatching(ctx, "old", "v1", map[string]string{"env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 1 || labels[0]["tier"] != "web" {
+		t.Errorf("got %v, want the env=prod config", labels)
+	}
+}
+
+func TestBuildLabelIndex(t *testing.T) {
+	cs := newTestStore()
+
+	for _, ctx := range []context.Context{context.Background(), WithNamespace(context.Background(), "team")} {
+		prefix := scopePrefix(ctx)
+		data, err := json.Marshal(&Group{ID: "old", Version: "v1", Configs: []map[string]string{{"env": "prod"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range map[string][]byte{
+			"group/old/v1":                       data,
+			"group/old/v1/env=prod/0d1c":         []byte(`{"env":"prod"}`),
+			"group/old/v1/index/env=prod/0d1c/x": nil,
+		} {
+			if err := cs.db.Put(ctx, &KVPair{Key: prefix + key, Value: value}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := cs.BuildLabelIndex(ctx); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := cs.db.Keys(ctx, prefix+"group/", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{prefix + "group/old/v1", prefix + "group/old/v1/labels"}
+		if len(keys) != 2 || keys[0] != want[0] || keys[1] != want[1] {
+			t.Errorf("keys after building = %q, want %q", keys, want)
+		}
+
+		// The marker keeps later runs from touching the keys again.
+		cs.db.Delete(ctx, prefix+"group/old/v1/labels")
+		if err := cs.BuildLabelIndex(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if pair, _ := cs.db.Get(ctx, prefix+"group/old/v1/labels"); pair != nil {
+			t.Errorf("label index of %q was built twice", prefix)
+		}
+	}
+}
+
+func TestRefLabels(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := cs.CreateGroup(ctx, &Group{
+		Version: "v1",
+		Configs: []map[string]string{{"env": "prod", "tier": "web"}},
+		Refs: []*ConfigRef{
+			{ID: config.ID, Version: "v1", Labels: map[string]string{"env": "prod", "tier": "db"}},
+			{ID: config.ID, Version: LatestVersion},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(query string) int {
+		t.Helper()
+
+		labels, err := cs.FindLabelsSelector(ctx, group.ID, "v1", mustSelector(t, query))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(labels)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 3},
+		{"env=prod", 2},
+		{"tier=db", 1},
+		{"tier in (db,cache)", 1},
+		{"!tier", 1},
+	}
+	for _, tt := range tests {
+		if got := count(tt.query); got != tt.want {
+			t.Errorf("%q matched %d members, want %d", tt.query, got, tt.want)
+		}
+	}
+	if labels, _ := cs.FindLabels(ctx, group.ID, "v1", "env=prod&tier=db"); len(labels) != 1 {
+		t.Errorf("exact match found %v, want the reference", labels)
+	}
+
+	if _, err := cs.ReplaceLabelsInGroup(ctx, group.ID, "v1", mustSelector(t, "tier=db"), map[string]string{"tier": "cache"}); err != nil {
+		t.Fatal(err)
+	}
+	if count("tier=db") != 0 || count("tier=cache") != 1 {
+		t.Errorf("replacing the labels of a reference did not reindex it")
+	}
+	stored, err := cs.FindGroup(ctx, group.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref := stored.Refs[0]; ref.ID != config.ID || ref.Version != "v1" || ref.Labels["tier"] != "cache" {
+		t.Errorf("replaced reference = %+v", ref)
+	}
+
+	removed, err := cs.RemoveLabelsFromGroup(ctx, group.ID, "v1", mustSelector(t, "tier=cache"))
+	if err != nil || len(removed) != 1 {
+		t.Fatalf("removed %v, %v", removed, err)
+	}
+	stored, err = cs.FindGroup(ctx, group.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Configs) != 1 || len(stored.Refs) != 1 || stored.Refs[0].Version != LatestVersion {
+		t.Errorf("group after removing the reference = %+v", stored)
+	}
+
+	if err := cs.DeleteGroup(ctx, group.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.RestoreGroup(ctx, group.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := count("!tier"); got != 1 {
+		t.Errorf("restored group has %d unlabelled references, want 1", got)
+	}
+}
+
+// checkLabelDoc fails unless the label document of a group version is the
+// one derived from its group document.
+func checkLabelDoc(t *testing.T, cs *ConfigStore, id, ver string) {
+	t.Helper()
+
+	ctx := context.Background()
+	group, err := cs.FindGroup(ctx, id, ver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mustGet(t, cs.db, constructGroupLabelsKey(ctx, id, ver)) == nil {
+		t.Fatalf("group %s/%s has no label document", id, ver)
+	}
+	doc, err := cs.findLabelDoc(ctx, id, ver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := json.Marshal(doc)
+	want, _ := json.Marshal(newLabelDoc(group))
+	if string(got) != string(want) {
+		t.Errorf("label document\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRemoveAndReplaceKeepLabelDoc(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := cs.CreateGroup(ctx, &Group{
+		Version: "v1",
+		Configs: []map[string]string{
+			{"env": "prod", "tier": "web"},
+			{"env": "dev", "tier": "web"},
+			{"env": "dev", "tier": "db"},
+		},
+		Refs: []*ConfigRef{{ID: config.ID, Version: "v1", Labels: map[string]string{"env": "dev", "tier": "cache"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := cs.RemoveLabelsFromGroup(ctx, group.ID, "v1", mustSelector(t, "env=dev,tier in (db,cache)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("removed %v, want the db config and the reference", removed)
+	}
+	checkLabelDoc(t, cs, group.ID, "v1")
+
+	if _, err := cs.ReplaceLabelsInGroup(ctx, group.ID, "v1", mustSelector(t, "env=dev"), map[string]string{"env": "staging", "tier": "web"}); err != nil {
+		t.Fatal(err)
+	}
+	checkLabelDoc(t, cs, group.ID, "v1")
+
+	if _, err := cs.ReplaceLabelsInGroup(ctx, group.ID, "v1", mustSelector(t, "tier=web"), map[string]string{"x": "y"}); !errors.Is(err, ErrAmbiguousMatch) {
+		t.Errorf("replace matching two configs: err = %v, want ErrAmbiguousMatch", err)
+	}
+	if _, err := cs.RemoveLabelsFromGroup(ctx, group.ID, "v1", mustSelector(t, "env=dev")); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("remove matching nothing: err = %v, want ErrNoMatch", err)
+	}
+	checkLabelDoc(t, cs, group.ID, "v1")
+
+	labels, err := cs.FindLabelsMatching(ctx, group.ID, "v1", map[string]string{"tier": "web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 {
+		t.Errorf("found %v, want the prod and staging configs", labels)
+	}
+}
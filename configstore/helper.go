@@ -5,40 +5,100 @@ import (
 	"context"
 	"fmt"
 	"github.com/google/uuid"
-	"sort"
+	"net/url"
+	"time"
 )
 
 const (
-	configId = "config/%s"
-	config   = "config/%s/%s"
+	configRoot = "config/"
+	configId   = "config/%s"
+	config     = "config/%s/%s"
 
-	groupVer       = "group/%s/%s"
-	group          = "group/%s/%s/%s"
-	groupWithLabel = "group/%s/%s/%s/%s"
+	groupRoot   = "group/"
+	groupId     = "group/%s/"
+	groupVer    = "group/%s/%s"
+	groupLabels = "group/%s/%s/labels"
+
+	tombstoneRoot = "tombstone/"
+	tombstone     = "tombstone/%s/%s/%s"
+
+	schemaId = "schema/%s/"
+	schema   = "schema/%s/%d"
+
+	searchKey    = "search/key/%s/%s/%s"
+	searchValue  = "search/value/%s/%s/%s/%s"
+	searchMarker = "search/built"
+
+	labelsMarker = "labels/built"
 
 	requestId = "request/%s"
+
+	namespaceRoot = "namespace/"
+	namespace     = "namespace/%s"
+	namespaceKeys = "ns/%s/"
+
+	environmentKeys = "env/%s/"
+
+	promotionId = "promotion/%s/"
+	promotion   = "promotion/%s/%020d"
 )
 
+// namespacePrefix is put in front of every key of the namespace in ctx. The
+// default namespace has none, so it holds the keys written before there
+// were namespaces.
+func namespacePrefix(ctx context.Context) string {
+	ns := NamespaceFrom(ctx)
+	if ns == DefaultNamespace {
+		return ""
+	}
+	return fmt.Sprintf(namespaceKeys, ns)
+}
+
+// scopePrefix is put in front of every key of the namespace and environment
+// in ctx. Schemas and promotions are kept per namespace, so they are shared
+// by its environments and use namespacePrefix instead.
+func scopePrefix(ctx context.Context) string {
+	env := EnvironmentFrom(ctx)
+	if env == "" {
+		return namespacePrefix(ctx)
+	}
+	return namespacePrefix(ctx) + fmt.Sprintf(environmentKeys, env)
+}
+
+func constructConfigRoot(ctx context.Context) string {
+	span := tracer.StartSpanFromContext(ctx, "constructConfigRoot")
+	defer span.Finish()
+
+	return scopePrefix(ctx) + configRoot
+}
+
+func constructGroupRoot(ctx context.Context) string {
+	span := tracer.StartSpanFromContext(ctx, "constructGroupRoot")
+	defer span.Finish()
+
+	return scopePrefix(ctx) + groupRoot
+}
+
 func generateConfigKey(ctx context.Context, ver string) (string, string) {
 	span := tracer.StartSpanFromContext(ctx, "generateConfigKey")
 	defer span.Finish()
 
 	id := uuid.New().String()
-	return fmt.Sprintf(config, id, ver), id
+	return scopePrefix(ctx) + fmt.Sprintf(config, id, ver), id
 }
 
 func constructConfigKey(ctx context.Context, id string, ver string) string {
 	span := tracer.StartSpanFromContext(ctx, "constructConfigKey")
 	defer span.Finish()
 
-	return fmt.Sprintf(config, id, ver)
+	return scopePrefix(ctx) + fmt.Sprintf(config, id, ver)
 }
 
 func constructConfigIdKey(ctx context.Context, id string) string {
 	span := tracer.StartSpanFromContext(ctx, "constructConfigIdKey")
 	defer span.Finish()
 
-	return fmt.Sprintf(configId, id)
+	return scopePrefix(ctx) + fmt.Sprintf(configId, id)
 }
 
 func generateGroupKey(ctx context.Context, ver string) (string, string) {
@@ -46,32 +106,128 @@ func generateGroupKey(ctx context.Context, ver string) (string, string) {
 	defer span.Finish()
 
 	id := uuid.New().String()
-	return fmt.Sprintf(groupVer, id, ver), id
+	return scopePrefix(ctx) + fmt.Sprintf(groupVer, id, ver), id
+}
+
+func constructGroupIdKey(ctx context.Context, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructGroupIdKey")
+	defer span.Finish()
+
+	return scopePrefix(ctx) + fmt.Sprintf(groupId, id)
 }
 
 func constructGroupKey(ctx context.Context, id string, ver string) string {
 	span := tracer.StartSpanFromContext(ctx, "constructGroupKey")
 	defer span.Finish()
 
-	return fmt.Sprintf(groupVer, id, ver)
+	return scopePrefix(ctx) + fmt.Sprintf(groupVer, id, ver)
 }
-func constructGroupLabel(ctx context.Context, id, ver, index string, config map[string]string) string {
-	span := tracer.StartSpanFromContext(ctx, "constructGroupLabel")
+
+// constructGroupLabelsKey is the key of the label document of a group
+// version, see labelDoc.
+func constructGroupLabelsKey(ctx context.Context, id, ver string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructGroupLabelsKey")
 	defer span.Finish()
 
-	keys := make([]string, 0, len(config))
-	for k := range config {
-		keys = append(keys, k)
+	return scopePrefix(ctx) + fmt.Sprintf(groupLabels, id, ver)
+}
+
+func constructTombstoneKey(ctx context.Context, kind, id, ver string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructTombstoneKey")
+	defer span.Finish()
+
+	return scopePrefix(ctx) + fmt.Sprintf(tombstone, kind, id, ver)
+}
+
+// constructTombstonePrefix lists the tombstones of one kind, or all of them
+// for an empty kind.
+func constructTombstonePrefix(ctx context.Context, kind string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructTombstonePrefix")
+	defer span.Finish()
+
+	if kind == "" {
+		return scopePrefix(ctx) + tombstoneRoot
 	}
+	return scopePrefix(ctx) + tombstoneRoot + kind + "/"
+}
+
+func constructNamespaceKey(ctx context.Context, name string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructNamespaceKey")
+	defer span.Finish()
 
-	sort.Strings(keys)
+	return fmt.Sprintf(namespace, name)
+}
 
-	var kvpairs string
-	for k := range keys {
-		kvpairs = kvpairs + fmt.Sprintf("%s=%s", keys[k], config[keys[k]]+"&")
+// constructNamespacePrefix holds every key of a namespace other than the
+// default one.
+func constructNamespacePrefix(ctx context.Context, name string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructNamespacePrefix")
+	defer span.Finish()
+
+	return fmt.Sprintf(namespaceKeys, name)
+}
+
+func constructPromotionIdKey(ctx context.Context, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructPromotionIdKey")
+	defer span.Finish()
+
+	return namespacePrefix(ctx) + fmt.Sprintf(promotionId, id)
+}
+
+func constructPromotionKey(ctx context.Context, id string, at time.Time) string {
+	span := tracer.StartSpanFromContext(ctx, "constructPromotionKey")
+	defer span.Finish()
+
+	return namespacePrefix(ctx) + fmt.Sprintf(promotion, id, at.UnixNano())
+}
+
+func constructSchemaIdKey(ctx context.Context, id string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructSchemaIdKey")
+	defer span.Finish()
+
+	return namespacePrefix(ctx) + fmt.Sprintf(schemaId, id)
+}
+
+func constructSchemaKey(ctx context.Context, id string, ver int) string {
+	span := tracer.StartSpanFromContext(ctx, "constructSchemaKey")
+	defer span.Finish()
+
+	return namespacePrefix(ctx) + fmt.Sprintf(schema, id, ver)
+}
+
+// searchKeys returns the two search index keys of a config entry, one
+// ordered by entry key and one by entry value.
+func searchKeys(ctx context.Context, id, ver, k, v string) []string {
+	span := tracer.StartSpanFromContext(ctx, "searchKeys")
+	defer span.Finish()
+
+	ek := url.QueryEscape(k)
+	ev := url.QueryEscape(truncateValue(v))
+	return []string{
+		scopePrefix(ctx) + fmt.Sprintf(searchKey, ek, id, ver),
+		scopePrefix(ctx) + fmt.Sprintf(searchValue, ev, ek, id, ver),
+	}
+}
+
+func constructSearchKeyPrefix(ctx context.Context, k string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructSearchKeyPrefix")
+	defer span.Finish()
+
+	return scopePrefix(ctx) + "search/key/" + url.QueryEscape(k)
+}
+
+func constructSearchValuePrefix(ctx context.Context, v string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructSearchValuePrefix")
+	defer span.Finish()
+
+	return scopePrefix(ctx) + "search/value/" + url.QueryEscape(truncateValue(v))
+}
+
+func truncateValue(v string) string {
+	if len(v) > maxIndexedValue {
+		return v[:maxIndexedValue]
 	}
-	kvpairs = kvpairs[:len(kvpairs)-1]
-	return fmt.Sprintf(groupWithLabel, id, ver, kvpairs, index)
+	return v
 }
 
 func generateRequestId(ctx context.Context) string {
@@ -81,3 +237,12 @@ func generateRequestId(ctx context.Context) string {
 	rid := uuid.New().String()
 	return rid
 }
+
+// constructRequestIdKey is the key an idempotency key is kept under, in the
+// scope it was issued in so that it is not known in any other.
+func constructRequestIdKey(ctx context.Context, requestId string) string {
+	span := tracer.StartSpanFromContext(ctx, "constructRequestIdKey")
+	defer span.Finish()
+
+	return scopePrefix(ctx) + requestId
+}
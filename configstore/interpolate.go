@@ -0,0 +1,319 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	RefConfig = "config"
+	RefEnv    = "env"
+
+	// maxResolveDepth is how many references deep a value may be resolved,
+	// a reference to a value that is a reference counting as two.
+	maxResolveDepth = 10
+
+	// defaultEnvPrefix limits ${env:NAME} to variables meant for configs,
+	// so clients can not read the environment of the server.
+	defaultEnvPrefix = "CONFIG_"
+)
+
+// UnresolvedRef is a reference that could not be resolved and why.
+type UnresolvedRef struct {
+	Ref    string `json:"ref"`
+	Reason string `json:"reason"`
+}
+
+// ResolveError lists every reference of a config that could not be
+// resolved.
+type ResolveError struct {
+	Unresolved []UnresolvedRef `json:"unresolved"`
+}
+
+func (e *ResolveError) Error() string {
+	refs := make([]string, len(e.Unresolved))
+	for i, u := range e.Unresolved {
+		refs[i] = fmt.Sprintf("%s: %s", u.Ref, u.Reason)
+	}
+	return "Unresolved references: " + strings.Join(refs, "; ")
+}
+
+// ResolveConfig returns config with the references in its entry values
+// replaced: ${config:<id>/<ver>/<key>} by an entry of another config version
+// (ver may be latest) and ${env:NAME} by an environment variable starting
+// with RESOLVE_ENV_PREFIX (CONFIG_ by default). A value that is a single
+// reference takes the type of the referenced value, anywhere else it is
+// inserted as text. $${ is a literal ${. Referenced secrets only resolve
+// when reveal is set, config itself is used as given.
+func (cs *ConfigStore) ResolveConfig(ctx context.Context, config *Config, reveal bool) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "ResolveConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	r := &resolver{
+		cs:         cs,
+		ctx:        childCtx,
+		reveal:     reveal,
+		envPrefix:  cs.envPrefix,
+		configs:    map[string]*Config{configKey(config.ID, config.Version): config},
+		resolved:   make(map[string]resolvedEntry),
+		unresolved: make(map[UnresolvedRef]bool),
+	}
+
+	resolved := *config
+	resolved.Entries = make(map[string]Value, len(config.Entries))
+	// Entries are resolved in order so the same references are reported
+	// every time.
+	keys := make([]string, 0, len(config.Entries))
+	for k := range config.Entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		self := configKey(config.ID, config.Version) + "/" + k
+		resolved.Entries[k], _ = r.value(config.Entries[k], []string{self})
+	}
+
+	if len(r.unresolved) > 0 {
+		err := &ResolveError{}
+		for u := range r.unresolved {
+			err.Unresolved = append(err.Unresolved, u)
+		}
+		sort.Slice(err.Unresolved, func(i, j int) bool {
+			a, b := err.Unresolved[i], err.Unresolved[j]
+			if a.Ref != b.Ref {
+				return a.Ref < b.Ref
+			}
+			return a.Reason < b.Reason
+		})
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return &resolved, nil
+}
+
+type resolver struct {
+	cs        *ConfigStore
+	ctx       context.Context
+	reveal    bool
+	envPrefix string
+	// configs caches the config versions read so far by id/ver, nil for the
+	// ones that do not exist.
+	configs map[string]*Config
+	// resolved caches the resolved entries by id/ver/key.
+	resolved   map[string]resolvedEntry
+	unresolved map[UnresolvedRef]bool
+}
+
+// resolvedEntry is a resolved entry value and the number of config entries
+// on the longest reference chain from it, itself included, so the depth
+// limit holds for cached entries as well.
+type resolvedEntry struct {
+	value  Value
+	height int
+}
+
+func configKey(id, ver string) string {
+	return id + "/" + ver
+}
+
+// value resolves the references in v and returns it with the number of
+// config entries on the longest reference chain in it. stack holds the entries being resolved to
+// detect cycles. A reference that fails is recorded and left as is.
+func (r *resolver) value(v Value, stack []string) (Value, int) {
+	if !bytes.Contains(v, []byte("${")) {
+		return v, 0
+	}
+
+	node, err := decodeValue(v)
+	if err != nil {
+		return v, 0
+	}
+	height := 0
+	data, err := json.Marshal(r.walk(node, stack, &height))
+	if err != nil {
+		return v, 0
+	}
+	return Value(data), height
+}
+
+func (r *resolver) walk(node interface{}, stack []string, height *int) interface{} {
+	switch n := node.(type) {
+	case string:
+		return r.text(n, stack, height)
+	case map[string]interface{}:
+		for k, child := range n {
+			n[k] = r.walk(child, stack, height)
+		}
+	case []interface{}:
+		for i, child := range n {
+			n[i] = r.walk(child, stack, height)
+		}
+	}
+	return node
+}
+
+// text resolves the references in a string. A string that is one reference
+// and nothing else becomes the referenced value itself.
+func (r *resolver) text(s string, stack []string, height *int) interface{} {
+	if strings.HasPrefix(s, "${") && strings.IndexByte(s, '}') == len(s)-1 {
+		if v, ok := r.ref(s[2:len(s)-1], stack, height); ok {
+			if node, err := decodeValue(v); err == nil {
+				return node
+			}
+		}
+		return s
+	}
+
+	var out strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			out.WriteString(s)
+			return out.String()
+		}
+		if i > 0 && s[i-1] == '$' {
+			out.WriteString(s[:i-1] + "${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			out.WriteString(s)
+			return out.String()
+		}
+
+		out.WriteString(s[:i])
+		ref := s[i : i+end+1]
+		if v, ok := r.ref(ref[2:len(ref)-1], stack, height); ok {
+			out.WriteString(v.Text())
+		} else {
+			out.WriteString(ref)
+		}
+		s = s[i+end+1:]
+	}
+}
+
+// ref resolves one reference, the text between ${ and }, raising height to
+// the number of config entries its reference chain goes through.
+func (r *resolver) ref(ref string, stack []string, height *int) (Value, bool) {
+	fail := func(format string, args ...interface{}) (Value, int, bool) {
+		r.unresolved[UnresolvedRef{Ref: "${" + ref + "}", Reason: fmt.Sprintf(format, args...)}] = true
+		return nil, 0, false
+	}
+
+	v, h, ok := r.resolve(ref, stack, fail)
+	if ok && h > *height {
+		*height = h
+	}
+	return v, ok
+}
+
+// resolve returns the value of ref and the number of config entries its
+// reference chain goes through.
+func (r *resolver) resolve(ref string, stack []string, fail func(string, ...interface{}) (Value, int, bool)) (Value, int, bool) {
+	kind, target := ref, ""
+	if i := strings.IndexByte(ref, ':'); i >= 0 {
+		kind, target = ref[:i], ref[i+1:]
+	}
+
+	switch kind {
+	case RefEnv:
+		if target == "" {
+			return fail("expected ${env:NAME}")
+		}
+		if !strings.HasPrefix(target, r.envPrefix) {
+			return fail("only variables starting with %q can be referenced", r.envPrefix)
+		}
+		value, ok := os.LookupEnv(target)
+		if !ok {
+			return fail("environment variable is not set")
+		}
+		return StringValue(value), 0, true
+
+	case RefConfig:
+		parts := strings.SplitN(target, "/", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return fail("expected ${config:<id>/<ver>/<key>}")
+		}
+		return r.entry(parts[0], parts[1], parts[2], stack, fail)
+	}
+	return fail("unknown reference type %q, expected %s or %s", kind, RefConfig, RefEnv)
+}
+
+func (r *resolver) entry(id, ver, key string, stack []string, fail func(string, ...interface{}) (Value, int, bool)) (Value, int, bool) {
+	ver, err := r.cs.ConfigVersion(r.ctx, id, ver)
+	if err != nil {
+		return fail("config does not exist")
+	}
+
+	name := configKey(id, ver) + "/" + key
+	for i, entry := range stack {
+		if entry == name {
+			return fail("cycle %s", strings.Join(append(stack[i:], name), " → "))
+		}
+	}
+	if len(stack) > maxResolveDepth {
+		return fail("references are nested deeper than %d", maxResolveDepth)
+	}
+	if e, ok := r.resolved[name]; ok {
+		if len(stack)+e.height-1 > maxResolveDepth {
+			return fail("references are nested deeper than %d", maxResolveDepth)
+		}
+		return e.value, e.height, true
+	}
+
+	config, err := r.config(id, ver)
+	if err != nil {
+		return fail("reading config failed: %v", err)
+	}
+	if config == nil {
+		return fail("config version does not exist")
+	}
+	v, ok := config.Entries[key]
+	if !ok {
+		return fail("entry does not exist")
+	}
+	if config.IsSecret(key) && !r.reveal {
+		return fail("entry is a secret, reveal it to resolve")
+	}
+
+	before := len(r.unresolved)
+	v, height := r.value(v, append(stack[:len(stack):len(stack)], name))
+	if len(r.unresolved) > before {
+		return nil, 0, false
+	}
+	r.resolved[name] = resolvedEntry{value: v, height: height + 1}
+	return v, height + 1, true
+}
+
+func (r *resolver) config(id, ver string) (*Config, error) {
+	key := configKey(id, ver)
+	if config, ok := r.configs[key]; ok {
+		return config, nil
+	}
+
+	var config *Config
+	var err error
+	if r.reveal {
+		config, err = r.cs.RevealConfig(r.ctx, id, ver)
+	} else {
+		config, err = r.cs.FindConfig(r.ctx, id, ver)
+	}
+	if errors.Is(err, ErrNotFound) {
+		config, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.configs[key] = config
+	return config, nil
+}
@@ -0,0 +1,136 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolveConfig(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+	t.Setenv("CONFIG_REGION", "eu")
+	t.Setenv("HOME_SECRET", "x")
+
+	base, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{
+		"host": Value(`"db"`),
+		"port": Value(`5432`),
+		"tls":  Value(`{"on": true}`),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: base.ID, Version: "v2", Entries: map[string]Value{
+		"host": Value(`"db2"`),
+		"url":  Value(`"${config:` + base.ID + `/v1/host}:${config:` + base.ID + `/v1/port}"`),
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	ref := func(ver, key string) string {
+		return "${config:" + base.ID + "/" + ver + "/" + key + "}"
+	}
+
+	tests := []struct {
+		name    string
+		entries map[string]string
+		// want is the resolved value of entry k, unless reason is set.
+		want   string
+		reason string
+	}{
+		{"no reference", map[string]string{"k": `"plain"`}, `"plain"`, ""},
+		{"typed reference", map[string]string{"k": `"` + ref("v1", "port") + `"`}, `5432`, ""},
+		{"object reference", map[string]string{"k": `"` + ref("v1", "tls") + `"`}, `{"on":true}`, ""},
+		{"text", map[string]string{"k": `"` + ref("v1", "host") + `:` + ref("v1", "port") + `"`}, `"db:5432"`, ""},
+		{"latest", map[string]string{"k": `"` + ref(LatestVersion, "host") + `"`}, `"db2"`, ""},
+		{"chain", map[string]string{"k": `"` + ref("v2", "url") + `"`}, `"db:5432"`, ""},
+		{"nested in object", map[string]string{"k": `{"a": ["` + ref("v1", "port") + `"]}`}, `{"a":[5432]}`, ""},
+		{"own entry", map[string]string{"k": `"${config:self/v1/o}"`, "o": `1`}, `1`, ""},
+		{"env", map[string]string{"k": `"region-${env:CONFIG_REGION}"`}, `"region-eu"`, ""},
+		{"escaped", map[string]string{"k": `"$${env:CONFIG_REGION}"`}, `"${env:CONFIG_REGION}"`, ""},
+		{"unclosed", map[string]string{"k": `"${env:CONFIG_REGION"`}, `"${env:CONFIG_REGION"`, ""},
+		{"env outside prefix", map[string]string{"k": `"${env:HOME_SECRET}"`}, "", `only variables starting with "CONFIG_"`},
+		{"env not set", map[string]string{"k": `"${env:CONFIG_MISSING}"`}, "", "environment variable is not set"},
+		{"env without name", map[string]string{"k": `"${env:}"`}, "", "expected ${env:NAME}"},
+		{"unknown type", map[string]string{"k": `"${file:/etc/passwd}"`}, "", `unknown reference type "file"`},
+		{"short config reference", map[string]string{"k": `"${config:` + base.ID + `/v1}"`}, "", "expected ${config:<id>/<ver>/<key>}"},
+		{"missing config", map[string]string{"k": `"${config:missing/latest/host}"`}, "", "config does not exist"},
+		{"missing version", map[string]string{"k": `"` + ref("v9", "host") + `"`}, "", "config version does not exist"},
+		{"missing entry", map[string]string{"k": `"` + ref("v1", "user") + `"`}, "", "entry does not exist"},
+		{"self cycle", map[string]string{"k": `"${config:self/v1/k}"`}, "", "cycle self/v1/k → self/v1/k"},
+		{"cycle", map[string]string{"k": `"${config:self/v1/o}"`, "o": `"x${config:self/v1/k}"`}, "", "cycle self/v1/k → self/v1/o → self/v1/k"},
+		{"too deep", chain(maxResolveDepth + 1), "", fmt.Sprintf("nested deeper than %d", maxResolveDepth)},
+		{"deep enough", chain(maxResolveDepth - 1), `"end"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{ID: "self", Version: "v1", Entries: map[string]Value{}}
+			for k, v := range tt.entries {
+				config.Entries[k] = Value(v)
+			}
+
+			resolved, err := cs.ResolveConfig(ctx, config, false)
+			if tt.reason != "" {
+				var resolveErr *ResolveError
+				if !errors.As(err, &resolveErr) {
+					t.Fatalf("err = %v, want a ResolveError", err)
+				}
+				if !strings.Contains(resolveErr.Error(), tt.reason) {
+					t.Errorf("err = %v, want %q", resolveErr, tt.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resolved.Entries["k"]; !got.Equal(Value(tt.want)) {
+				t.Errorf("k = %s, want %s", got, tt.want)
+			}
+			if !config.Entries["k"].Equal(Value(tt.entries["k"])) {
+				t.Errorf("resolving changed the config to %s", config.Entries["k"])
+			}
+		})
+	}
+}
+
+// chain returns entries k → e1 → ... → en, each a reference to the next
+// one, with en holding "end".
+func chain(n int) map[string]string {
+	entries := map[string]string{"k": `"${config:self/v1/e1}"`}
+	for i := 1; i < n; i++ {
+		entries[fmt.Sprintf("e%d", i)] = fmt.Sprintf(`"${config:self/v1/e%d}"`, i+1)
+	}
+	entries[fmt.Sprintf("e%d", n)] = `"end"`
+	return entries
+}
+
+func TestResolveConfigListsEveryReference(t *testing.T) {
+	cs := newTestStore()
+	config := &Config{ID: "self", Version: "v1", Entries: map[string]Value{
+		"a": Value(`"${env:CONFIG_MISSING} ${config:missing/v1/k}"`),
+		"b": Value(`"${env:CONFIG_MISSING}"`),
+	}}
+
+	_, err := cs.ResolveConfig(context.Background(), config, false)
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) {
+		t.Fatalf("err = %v, want a ResolveError", err)
+	}
+	var refs []string
+	for _, u := range resolveErr.Unresolved {
+		refs = append(refs, u.Ref)
+	}
+	if got := strings.Join(refs, " "); got != "${config:missing/v1/k} ${env:CONFIG_MISSING}" {
+		t.Errorf("unresolved = %s", got)
+	}
+}
+
+func TestEmptyEnvPrefix(t *testing.T) {
+	t.Setenv("DB", "memory")
+	t.Setenv("RESOLVE_ENV_PREFIX", "")
+
+	if _, err := New(); err == nil || !strings.Contains(err.Error(), "RESOLVE_ENV_PREFIX") {
+		t.Errorf("err = %v, want an empty RESOLVE_ENV_PREFIX refused", err)
+	}
+}
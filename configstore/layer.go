@@ -0,0 +1,352 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// maxLayers is how many configs a merged config may be built from, itself
+// included.
+const maxLayers = 16
+
+var (
+	ErrLayerCycle     = errors.New("Config layers form a cycle")
+	ErrTooManyLayers  = fmt.Errorf("Config has more than %d layers", maxLayers)
+	ErrInvalidOverlay = errors.New("Invalid overlay")
+)
+
+// Origin is a value of a merged config and the layer it came from. Pointer
+// is the JSON pointer of the value in the merged entries.
+type Origin struct {
+	Pointer string     `json:"pointer"`
+	Layer   *ConfigRef `json:"layer"`
+	Value   Value      `json:"value"`
+}
+
+// Provenance tells which layer each value of a merged config came from.
+// Layers lists the config first, then its parents up to the base config.
+type Provenance struct {
+	ID      string       `json:"id"`
+	Version string       `json:"version"`
+	Layers  []*ConfigRef `json:"layers"`
+	Entries []*Origin    `json:"entries"`
+}
+
+// layer is one node of the merged entries. Objects present in more than one
+// layer are merged key by key and keep their fields, anything else is taken
+// whole from the last layer that set it.
+type layer struct {
+	value  interface{}
+	from   int
+	fields map[string]*layer
+}
+
+func newLayer(v interface{}, from int) *layer {
+	l := &layer{value: v, from: from}
+	if obj, ok := v.(map[string]interface{}); ok {
+		l.fields = make(map[string]*layer, len(obj))
+		for k, child := range obj {
+			l.fields[k] = newLayer(child, from)
+		}
+	}
+	return l
+}
+
+// merge applies an overlay value the way a JSON merge patch (RFC 7396)
+// does: objects are merged, null removes a key, anything else replaces.
+func (l *layer) merge(v interface{}, from int) *layer {
+	obj, ok := v.(map[string]interface{})
+	if !ok || l.fields == nil {
+		return newLayer(v, from)
+	}
+	for k, child := range obj {
+		switch existing, ok := l.fields[k]; {
+		case child == nil:
+			delete(l.fields, k)
+		case ok:
+			l.fields[k] = existing.merge(child, from)
+		default:
+			l.fields[k] = newLayer(child, from)
+		}
+	}
+	return l
+}
+
+func (l *layer) materialize() interface{} {
+	if l.fields == nil {
+		return l.value
+	}
+	obj := make(map[string]interface{}, len(l.fields))
+	for k, child := range l.fields {
+		obj[k] = child.materialize()
+	}
+	return obj
+}
+
+// single reports whether the whole value comes from one layer.
+func (l *layer) single() bool {
+	for _, child := range l.fields {
+		if child.from != l.from || !child.single() {
+			return false
+		}
+	}
+	return true
+}
+
+// origins appends the values of l that each come from a single layer.
+func (l *layer) origins(pointer string, out []*originLayer) []*originLayer {
+	if l.single() {
+		return append(out, &originLayer{pointer: pointer, layer: l})
+	}
+	for k, child := range l.fields {
+		out = child.origins(pointer+"/"+escapePointer(k), out)
+	}
+	return out
+}
+
+type originLayer struct {
+	pointer  string
+	layer    *layer
+	redacted bool
+}
+
+// checkParent makes sure the parent of a new config version exists and does
+// not lead back to the config, which latest would do once the new version
+// is the newest.
+func (cs *ConfigStore) checkParent(ctx context.Context, config *Config) error {
+	span := tracer.StartSpanFromContext(ctx, "checkParent")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if config.Parent == nil {
+		return nil
+	}
+
+	ref := config.Parent
+	for n := 1; ref != nil; n++ {
+		if ref.ID == "" || ref.Version == "" {
+			return errors.New("Parent config needs an id and a version")
+		}
+		if ref.ID == config.ID && (ref.Version == config.Version || ref.Version == LatestVersion) {
+			return fmt.Errorf("%w: %s/%s is layered on itself", ErrLayerCycle, config.ID, config.Version)
+		}
+		if n >= maxLayers {
+			return ErrTooManyLayers
+		}
+
+		parent, err := cs.findRef(childCtx, ref)
+		if err != nil {
+			return fmt.Errorf("%w: %s/%s", ErrDanglingRef, ref.ID, ref.Version)
+		}
+		ref = parent.Parent
+	}
+	return nil
+}
+
+// findLayers returns config followed by its parents up to the base config.
+// Secrets are revealed even without reveal so that they merge like any other
+// value, callers redact what they merged. Without reveal config is taken as
+// a redacted stored version and read again.
+func (cs *ConfigStore) findLayers(ctx context.Context, config *Config, reveal bool) ([]*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "findLayers")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if !reveal && len(config.Secrets) > 0 {
+		var err error
+		config, err = cs.layerConfig(childCtx, config.ID, config.Version)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+	}
+
+	layers := []*Config{config}
+	seen := map[string]bool{configKey(config.ID, config.Version): true}
+	for current := config; current.Parent != nil; {
+		ref := current.Parent
+
+		ver, err := cs.ConfigVersion(childCtx, ref.ID, ref.Version)
+		if err != nil {
+			err = fmt.Errorf("%w: %s/%s", ErrDanglingRef, ref.ID, ref.Version)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if seen[configKey(ref.ID, ver)] {
+			err := fmt.Errorf("%w at %s/%s", ErrLayerCycle, ref.ID, ver)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if len(layers) >= maxLayers {
+			tracer.LogError(span, ErrTooManyLayers)
+			return nil, ErrTooManyLayers
+		}
+		seen[configKey(ref.ID, ver)] = true
+
+		current, err = cs.layerConfig(childCtx, ref.ID, ver)
+		if errors.Is(err, ErrNotFound) {
+			err = fmt.Errorf("%w: %s/%s", ErrDanglingRef, ref.ID, ver)
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		layers = append(layers, current)
+	}
+	return layers, nil
+}
+
+// layerConfig returns a stored config version with its secrets revealed.
+// Without a master key they can only be merged redacted.
+func (cs *ConfigStore) layerConfig(ctx context.Context, id, ver string) (*Config, error) {
+	config, err := cs.RevealConfig(ctx, id, ver)
+	if errors.Is(err, ErrNoMasterKey) {
+		return cs.FindConfig(ctx, id, ver)
+	}
+	return config, err
+}
+
+// mergeLayers merges the entries of layers, given top first, into a tree
+// whose nodes remember the index of the layer they came from.
+func mergeLayers(layers []*Config) (*layer, error) {
+	root := newLayer(map[string]interface{}{}, len(layers)-1)
+	for i := len(layers) - 1; i >= 0; i-- {
+		entries := make(map[string]interface{}, len(layers[i].Entries))
+		for k, v := range layers[i].Entries {
+			node, err := decodeValue(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w: entry %q of %s/%s: %v", ErrInvalidOverlay, k, layers[i].ID, layers[i].Version, err)
+			}
+			entries[k] = node
+		}
+		root = root.merge(entries, i)
+	}
+	return root, nil
+}
+
+// MergeConfig returns config with the entries of its parents merged in, the
+// closer layer winning. Objects are merged key by key and null removes what
+// a parent set. An entry is secret if any layer it came from marks it so.
+// Secrets are merged in plain text and redacted afterwards unless reveal is
+// set.
+func (cs *ConfigStore) MergeConfig(ctx context.Context, config *Config, reveal bool) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "MergeConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if config.Parent == nil {
+		return config, nil
+	}
+
+	layers, err := cs.findLayers(childCtx, config, reveal)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	root, err := mergeLayers(layers)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	merged := *config
+	merged.Entries = make(map[string]Value, len(root.fields))
+	merged.Secrets = nil
+	for k, node := range root.fields {
+		data, err := json.Marshal(node.materialize())
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		merged.Entries[k] = Value(data)
+
+		if secretEntry(layers, k, node) {
+			merged.Secrets = append(merged.Secrets, k)
+		}
+	}
+	sort.Strings(merged.Secrets)
+
+	if !reveal {
+		return redactConfig(&merged), nil
+	}
+	return &merged, nil
+}
+
+// secretEntry reports whether any layer entry k of the merged config came
+// from marks it secret.
+func secretEntry(layers []*Config, k string, node *layer) bool {
+	for _, origin := range node.origins("", nil) {
+		if layers[origin.layer.from].IsSecret(k) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigProvenance merges config like MergeConfig and tells which layer
+// every value of the result came from.
+func (cs *ConfigStore) ConfigProvenance(ctx context.Context, config *Config, reveal bool) (*Provenance, error) {
+	span := tracer.StartSpanFromContext(ctx, "ConfigProvenance")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	layers, err := cs.findLayers(childCtx, config, reveal)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	root, err := mergeLayers(layers)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	prov := &Provenance{
+		ID:      config.ID,
+		Version: config.Version,
+		Layers:  make([]*ConfigRef, len(layers)),
+		Entries: []*Origin{},
+	}
+	for i, l := range layers {
+		prov.Layers[i] = &ConfigRef{ID: l.ID, Version: l.Version}
+	}
+
+	var origins []*originLayer
+	for k, node := range root.fields {
+		from := len(origins)
+		origins = node.origins("/"+escapePointer(k), origins)
+		if !reveal && secretEntry(layers, k, node) {
+			for _, origin := range origins[from:] {
+				origin.redacted = true
+			}
+		}
+	}
+	for _, origin := range origins {
+		data, err := json.Marshal(origin.layer.materialize())
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if origin.redacted {
+			data = StringValue(Redacted)
+		}
+		prov.Entries = append(prov.Entries, &Origin{
+			Pointer: origin.pointer,
+			Layer:   prov.Layers[origin.layer.from],
+			Value:   Value(data),
+		})
+	}
+	sort.Slice(prov.Entries, func(i, j int) bool {
+		return prov.Entries[i].Pointer < prov.Entries[j].Pointer
+	})
+
+	return prov, nil
+}
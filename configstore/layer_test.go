@@ -0,0 +1,176 @@
+package configstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func createLayer(t *testing.T, cs *ConfigStore, config *Config) *Config {
+	t.Helper()
+
+	created, err := cs.CreateConfig(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func TestMergeConfig(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	base := createLayer(t, cs, &Config{Version: "v1", Entries: map[string]Value{
+		"host":   Value(`"db"`),
+		"db":     Value(`{"pool": {"min": 1, "max": 10}, "user": "app"}`),
+		"legacy": Value(`true`),
+	}})
+	mid := createLayer(t, cs, &Config{Version: "v1", Parent: &ConfigRef{ID: base.ID, Version: LatestVersion}, Entries: map[string]Value{
+		"db":     Value(`{"pool": {"max": 20}}`),
+		"legacy": Value(`null`),
+	}})
+	top := createLayer(t, cs, &Config{Version: "v1", Parent: &ConfigRef{ID: mid.ID, Version: "v1"}, Entries: map[string]Value{
+		"host": Value(`"staging-db"`),
+		"db":   Value(`{"user": "staging"}`),
+	}})
+
+	merged, err := cs.MergeConfig(ctx, top, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"host": `"staging-db"`,
+		"db":   `{"pool":{"max":20,"min":1},"user":"staging"}`,
+	}
+	if len(merged.Entries) != len(want) {
+		t.Errorf("merged entries = %v, want %v", merged.Entries, want)
+	}
+	for k, v := range want {
+		if got := string(merged.Entries[k]); got != v {
+			t.Errorf("%s = %s, want %s", k, got, v)
+		}
+	}
+
+	if got, err := cs.MergeConfig(ctx, base, false); err != nil || got != base {
+		t.Errorf("config without a parent: %v, %v, want it as is", got, err)
+	}
+
+	prov, err := cs.ConfigProvenance(ctx, top, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layers []string
+	for _, l := range prov.Layers {
+		layers = append(layers, l.ID)
+	}
+	if fmt.Sprint(layers) != fmt.Sprint([]string{top.ID, mid.ID, base.ID}) {
+		t.Errorf("layers = %q, want the config first", layers)
+	}
+	var origins []string
+	for _, o := range prov.Entries {
+		origins = append(origins, o.Pointer+" "+o.Layer.ID+" "+string(o.Value))
+	}
+	wantOrigins := []string{
+		"/db/pool/max " + mid.ID + " 20",
+		"/db/pool/min " + base.ID + " 1",
+		"/db/user " + top.ID + ` "staging"`,
+		"/host " + top.ID + ` "staging-db"`,
+	}
+	if fmt.Sprint(origins) != fmt.Sprint(wantOrigins) {
+		t.Errorf("origins = %q, want %q", origins, wantOrigins)
+	}
+}
+
+func TestMergeSecrets(t *testing.T) {
+	ctx := context.Background()
+	cs := newSecretStore(t)
+
+	base := createLayer(t, cs, &Config{Version: "v1", Entries: map[string]Value{
+		"db": Value(`{"host": "db", "password": "hunter2"}`),
+	}, Secrets: []string{"db"}})
+	top, err := cs.CreateConfig(ctx, &Config{Version: "v1", Parent: &ConfigRef{ID: base.ID, Version: "v1"}, Entries: map[string]Value{
+		"db": Value(`{"pool": 5}`),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The overlay merges into the secret itself, not into its redaction.
+	revealed, err := cs.MergeConfig(ctx, top, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(revealed.Entries["db"]); got != `{"host":"db","password":"hunter2","pool":5}` {
+		t.Errorf("revealed db = %s", got)
+	}
+
+	merged, err := cs.MergeConfig(ctx, top, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := merged.Entries["db"].Text(); got != Redacted || !merged.IsSecret("db") {
+		t.Errorf("merged db = %s, secrets %q, want it redacted", merged.Entries["db"], merged.Secrets)
+	}
+
+	prov, err := cs.ConfigProvenance(ctx, top, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range prov.Entries {
+		if o.Value.Text() != Redacted {
+			t.Errorf("%s = %s, want it redacted", o.Pointer, o.Value)
+		}
+	}
+	if len(prov.Entries) != 3 {
+		t.Errorf("got %d origins, want the values of both layers", len(prov.Entries))
+	}
+}
+
+func TestMergeRejectsBrokenLayers(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	a := createLayer(t, cs, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`1`)}})
+	b := createLayer(t, cs, &Config{Version: "v1", Parent: &ConfigRef{ID: a.ID, Version: LatestVersion}, Entries: map[string]Value{"k": Value(`2`)}})
+
+	// Writes refuse cycles, stored data that has one anyway must not loop.
+	key := constructConfigKey(ctx, a.ID, "v1")
+	stored := &Config{}
+	if err := json.Unmarshal(mustGet(t, cs.db, key).Value, stored); err != nil {
+		t.Fatal(err)
+	}
+	stored.Parent = &ConfigRef{ID: b.ID, Version: "v1"}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.db.Put(ctx, &KVPair{Key: key, Value: data}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.MergeConfig(ctx, b, false); !errors.Is(err, ErrLayerCycle) {
+		t.Errorf("cycle: err = %v, want ErrLayerCycle", err)
+	}
+	if _, err := cs.ConfigProvenance(ctx, stored, false); !errors.Is(err, ErrLayerCycle) {
+		t.Errorf("cycle: err = %v, want ErrLayerCycle", err)
+	}
+
+	if _, err := cs.CreateConfig(ctx, &Config{Version: "v1", Parent: &ConfigRef{ID: "missing", Version: "v1"}}); !errors.Is(err, ErrDanglingRef) {
+		t.Errorf("missing parent: err = %v, want ErrDanglingRef", err)
+	}
+	if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: a.ID, Version: "v2", Parent: &ConfigRef{ID: a.ID, Version: LatestVersion}}); !errors.Is(err, ErrLayerCycle) {
+		t.Errorf("layered on itself: err = %v, want ErrLayerCycle", err)
+	}
+
+	base := createLayer(t, cs, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`1`)}})
+	child := createLayer(t, cs, &Config{Version: "v1", Parent: &ConfigRef{ID: base.ID, Version: "v1"}})
+	if _, err := cs.DeleteConfig(ctx, base.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = cs.MergeConfig(ctx, child, false)
+	if !errors.Is(err, ErrDanglingRef) || !strings.Contains(err.Error(), base.ID) {
+		t.Errorf("deleted parent: err = %v, want ErrDanglingRef naming it", err)
+	}
+}
@@ -0,0 +1,347 @@
+package configstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTombstones is how many tombstones are kept before the older half is
+// pruned.
+const maxTombstones = 1024
+
+// memoryBackend keeps every key in process memory. It follows Consul KV
+// semantics: List returns keys sorted lexically, DeleteTree removes a raw
+// prefix and every write (or whole transaction) gets a new ModifyIndex.
+// Deleted keys leave a tombstone so that Watch on a prefix also wakes up
+// for deletes. Only the newest tombstones are kept, see pruneTombstones.
+type memoryBackend struct {
+	mu         sync.RWMutex
+	pairs      map[string]*KVPair
+	tombstones map[string]uint64
+	// reaped is the index of the newest pruned tombstone.
+	reaped uint64
+	index  uint64
+	// changed is closed and replaced after every write.
+	changed chan struct{}
+}
+
+func NewMemoryBackend() Backend {
+	return &memoryBackend{
+		pairs:      make(map[string]*KVPair),
+		tombstones: make(map[string]uint64),
+		changed:    make(chan struct{}),
+	}
+}
+
+func (mb *memoryBackend) Get(ctx context.Context, key string) (*KVPair, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	pair, ok := mb.pairs[key]
+	if !ok {
+		return nil, nil
+	}
+	return copyPair(pair), nil
+}
+
+func (mb *memoryBackend) Put(ctx context.Context, p *KVPair) error {
+	return mb.Txn(ctx, []*TxnOp{{Verb: TxnSet, Key: p.Key, Value: p.Value}})
+}
+
+func (mb *memoryBackend) List(ctx context.Context, prefix string) ([]*KVPair, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	var result []*KVPair
+	for _, key := range mb.keys(prefix) {
+		result = append(result, copyPair(mb.pairs[key]))
+	}
+	return result, nil
+}
+
+func (mb *memoryBackend) Keys(ctx context.Context, prefix, separator string) ([]string, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	var result []string
+	for _, key := range mb.keys(prefix) {
+		if separator != "" {
+			if i := strings.Index(key[len(prefix):], separator); i >= 0 {
+				key = key[:len(prefix)+i+len(separator)]
+			}
+		}
+		// Keys are sorted, so keys cut to the same directory are adjacent.
+		if len(result) > 0 && result[len(result)-1] == key {
+			continue
+		}
+		result = append(result, key)
+	}
+	return result, nil
+}
+
+func (mb *memoryBackend) ListIndexes(ctx context.Context, prefix string) ([]*KVPair, error) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	var result []*KVPair
+	for _, key := range mb.keys(prefix) {
+		result = append(result, &KVPair{Key: key, ModifyIndex: mb.pairs[key].ModifyIndex})
+	}
+	return result, nil
+}
+
+func (mb *memoryBackend) Delete(ctx context.Context, key string) error {
+	return mb.Txn(ctx, []*TxnOp{{Verb: TxnDelete, Key: key}})
+}
+
+func (mb *memoryBackend) DeleteTree(ctx context.Context, prefix string) error {
+	return mb.Txn(ctx, []*TxnOp{{Verb: TxnDeleteTree, Key: prefix}})
+}
+
+func (mb *memoryBackend) Txn(ctx context.Context, ops []*TxnOp) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	if err := validateTxn(ops); err != nil {
+		return err
+	}
+	if err := mb.checkCAS(ops); err != nil {
+		return err
+	}
+
+	mb.index++
+	mb.apply(ops)
+	return nil
+}
+
+func (mb *memoryBackend) Watch(ctx context.Context, prefix string, index uint64, wait time.Duration) (uint64, error) {
+	return mb.watch(ctx, index, wait, func() uint64 {
+		return mb.prefixIndex(prefix)
+	})
+}
+
+func (mb *memoryBackend) WatchKey(ctx context.Context, key string, index uint64, wait time.Duration) (uint64, error) {
+	return mb.watch(ctx, index, wait, func() uint64 {
+		return mb.keyIndex(key)
+	})
+}
+
+// watch blocks until watched, called with mb.mu held, moves past index.
+func (mb *memoryBackend) watch(ctx context.Context, index uint64, wait time.Duration, watched func() uint64) (uint64, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		mb.mu.RLock()
+		current := watched()
+		last := mb.index
+		changed := mb.changed
+		mb.mu.RUnlock()
+
+		// Like Consul an index is never 0, which would not block, an empty
+		// prefix or store is at 1.
+		if current == 0 {
+			current = 1
+		}
+		if last == 0 {
+			last = 1
+		}
+
+		// An index ahead of the store means it was reset, like Consul we
+		// answer right away so the caller can start over.
+		if index == 0 || current > index || index > last {
+			return current, nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return current, nil
+		case <-ctx.Done():
+			return current, ctx.Err()
+		}
+	}
+}
+
+// check verifies the CAS ops of a transaction against the current state.
+func (mb *memoryBackend) check(ops []*TxnOp) error {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	return mb.checkCAS(ops)
+}
+
+// checkCAS returns ErrConflict if the ModifyIndex of a key touched by a CAS
+// or delete-cas op moved away from op.Index. Callers hold mb.mu.
+func (mb *memoryBackend) checkCAS(ops []*TxnOp) error {
+	for _, op := range ops {
+		if op.Verb != TxnCAS && op.Verb != TxnDeleteCAS {
+			continue
+		}
+
+		var current uint64
+		if pair, ok := mb.pairs[op.Key]; ok {
+			current = pair.ModifyIndex
+		}
+		if current != op.Index {
+			return fmt.Errorf("%w: %s", ErrConflict, op.Key)
+		}
+	}
+	return nil
+}
+
+// replay applies ops as if they had been committed at index. It is used to
+// rebuild the store from a durable log.
+func (mb *memoryBackend) replay(index uint64, ops []*TxnOp) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	mb.index = index
+	mb.apply(ops)
+}
+
+// prefixIndex is the highest index at which a key under prefix was written
+// or deleted. Deletes whose tombstones were pruned are no longer known, so
+// like Consul the index of the newest pruned tombstone stands in for them.
+// Callers hold mb.mu.
+func (mb *memoryBackend) prefixIndex(prefix string) uint64 {
+	index := mb.reaped
+	for key, pair := range mb.pairs {
+		if pair.ModifyIndex > index && strings.HasPrefix(key, prefix) {
+			index = pair.ModifyIndex
+		}
+	}
+	for key, deleted := range mb.tombstones {
+		if deleted > index && strings.HasPrefix(key, prefix) {
+			index = deleted
+		}
+	}
+	return index
+}
+
+// keyIndex is prefixIndex for the key alone. Callers hold mb.mu.
+func (mb *memoryBackend) keyIndex(key string) uint64 {
+	if pair, ok := mb.pairs[key]; ok {
+		return pair.ModifyIndex
+	}
+	if deleted, ok := mb.tombstones[key]; ok {
+		return deleted
+	}
+	return mb.reaped
+}
+
+func (mb *memoryBackend) lastIndex() uint64 {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	return mb.index
+}
+
+func (mb *memoryBackend) size() int {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	return len(mb.pairs)
+}
+
+func (mb *memoryBackend) apply(ops []*TxnOp) {
+	for _, op := range ops {
+		switch op.Verb {
+		case TxnSet, TxnCAS:
+			mb.set(op.Key, op.Value)
+		case TxnDelete, TxnDeleteCAS:
+			mb.delete(op.Key)
+		case TxnDeleteTree:
+			mb.deleteTree(op.Key)
+		}
+	}
+	mb.pruneTombstones()
+
+	close(mb.changed)
+	mb.changed = make(chan struct{})
+}
+
+// pruneTombstones drops the older tombstones once there are more than
+// maxTombstones, keeping at most half of them. Tombstones of the same write
+// share an index and go together. Callers hold mb.mu.
+func (mb *memoryBackend) pruneTombstones() {
+	if len(mb.tombstones) <= maxTombstones {
+		return
+	}
+
+	indexes := make([]uint64, 0, len(mb.tombstones))
+	for _, deleted := range mb.tombstones {
+		indexes = append(indexes, deleted)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	cutoff := indexes[len(indexes)-maxTombstones/2-1]
+	for key, deleted := range mb.tombstones {
+		if deleted <= cutoff {
+			delete(mb.tombstones, key)
+		}
+	}
+	if cutoff > mb.reaped {
+		mb.reaped = cutoff
+	}
+}
+
+func validateTxn(ops []*TxnOp) error {
+	for i, op := range ops {
+		switch op.Verb {
+		case TxnSet, TxnDelete, TxnDeleteTree, TxnCAS, TxnDeleteCAS:
+		default:
+			return fmt.Errorf("transaction rolled back: op %d: unknown verb %q", i, op.Verb)
+		}
+	}
+	return nil
+}
+
+// set stores value under key at the current index. Callers hold mb.mu and
+// have already advanced mb.index.
+func (mb *memoryBackend) set(key string, value []byte) {
+	mb.pairs[key] = &KVPair{
+		Key:         key,
+		Value:       append([]byte(nil), value...),
+		ModifyIndex: mb.index,
+	}
+	delete(mb.tombstones, key)
+}
+
+func (mb *memoryBackend) delete(key string) {
+	if _, ok := mb.pairs[key]; ok {
+		delete(mb.pairs, key)
+		mb.tombstones[key] = mb.index
+	}
+}
+
+func (mb *memoryBackend) deleteTree(prefix string) {
+	for key := range mb.pairs {
+		if strings.HasPrefix(key, prefix) {
+			mb.delete(key)
+		}
+	}
+}
+
+func (mb *memoryBackend) keys(prefix string) []string {
+	var keys []string
+	for key := range mb.pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func copyPair(pair *KVPair) *KVPair {
+	return &KVPair{
+		Key:         pair.Key,
+		Value:       append([]byte(nil), pair.Value...),
+		ModifyIndex: pair.ModifyIndex,
+	}
+}
@@ -0,0 +1,157 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// backends returns a fresh memory and file backend.
+func backends(t *testing.T) map[string]Backend {
+	t.Helper()
+
+	fb, err := openFileBackend(t, filepath.Join(t.TempDir(), "configstore.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Backend{"memory": NewMemoryBackend(), "file": fb}
+}
+
+func mustGet(t *testing.T, db Backend, key string) *KVPair {
+	t.Helper()
+
+	pair, err := db.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pair
+}
+
+func TestTxnIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := db.Put(ctx, &KVPair{Key: "a", Value: []byte("1")}); err != nil {
+				t.Fatal(err)
+			}
+			before := mustGet(t, db, "a").ModifyIndex
+
+			// The CAS at the end fails, so neither the set nor the delete
+			// before it may be applied.
+			err := db.Txn(ctx, []*TxnOp{
+				{Verb: TxnSet, Key: "b", Value: []byte("2")},
+				{Verb: TxnDelete, Key: "a"},
+				{Verb: TxnCAS, Key: "a", Value: []byte("3"), Index: before + 1},
+			})
+			if !errors.Is(err, ErrConflict) {
+				t.Fatalf("err = %v, want ErrConflict", err)
+			}
+			if mustGet(t, db, "b") != nil {
+				t.Errorf("b was written by a failed transaction")
+			}
+			a := mustGet(t, db, "a")
+			if a == nil || string(a.Value) != "1" || a.ModifyIndex != before {
+				t.Errorf("a = %+v, want it unchanged at index %d", a, before)
+			}
+
+			// Nothing was committed, so a watch at the old index still blocks.
+			index, err := db.Watch(ctx, "", before, 10*time.Millisecond)
+			if err != nil || index != before {
+				t.Errorf("watch = %d, %v, want %d", index, err, before)
+			}
+
+			if err := db.Txn(ctx, []*TxnOp{{Verb: "bogus", Key: "b"}}); err == nil {
+				t.Errorf("unknown verb was accepted")
+			}
+			if mustGet(t, db, "b") != nil {
+				t.Errorf("b was written by a rejected transaction")
+			}
+		})
+	}
+}
+
+func TestTxnCAS(t *testing.T) {
+	ctx := context.Background()
+	for name, db := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			create := []*TxnOp{{Verb: TxnCAS, Key: "k", Value: []byte("1")}}
+			if err := db.Txn(ctx, create); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Txn(ctx, create); !errors.Is(err, ErrConflict) {
+				t.Errorf("second create: err = %v, want ErrConflict", err)
+			}
+
+			index := mustGet(t, db, "k").ModifyIndex
+			if err := db.Txn(ctx, []*TxnOp{{Verb: TxnCAS, Key: "k", Value: []byte("2"), Index: index}}); err != nil {
+				t.Fatal(err)
+			}
+			// The index moved, so the same update is stale now.
+			if err := db.Txn(ctx, []*TxnOp{{Verb: TxnCAS, Key: "k", Value: []byte("3"), Index: index}}); !errors.Is(err, ErrConflict) {
+				t.Errorf("stale update: err = %v, want ErrConflict", err)
+			}
+			if err := db.Txn(ctx, []*TxnOp{{Verb: TxnDeleteCAS, Key: "k", Index: index}}); !errors.Is(err, ErrConflict) {
+				t.Errorf("stale delete: err = %v, want ErrConflict", err)
+			}
+			if got := mustGet(t, db, "k"); got == nil || string(got.Value) != "2" {
+				t.Errorf("k = %+v, want 2", got)
+			}
+		})
+	}
+}
+
+func TestWatchWakesOnDelete(t *testing.T) {
+	ctx := context.Background()
+	db := NewMemoryBackend()
+
+	if err := db.Put(ctx, &KVPair{Key: "config/a", Value: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	index, err := db.Watch(ctx, "config/", 0, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go db.Delete(ctx, "config/a")
+	next, err := db.Watch(ctx, "config/", index, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next <= index {
+		t.Errorf("watch returned %d after a delete, want more than %d", next, index)
+	}
+}
+
+func TestTombstonesArePruned(t *testing.T) {
+	ctx := context.Background()
+	mb := NewMemoryBackend().(*memoryBackend)
+
+	for i := 0; i < maxTombstones+10; i++ {
+		key := fmt.Sprintf("config/k%d", i)
+		if err := mb.Put(ctx, &KVPair{Key: key, Value: []byte("1")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mb.Delete(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(mb.tombstones) > maxTombstones {
+		t.Errorf("%d tombstones kept, want at most %d", len(mb.tombstones), maxTombstones)
+	}
+	if mb.reaped == 0 {
+		t.Fatalf("no tombstone was pruned")
+	}
+
+	// The first key's delete is forgotten, a watch from before it still
+	// wakes up at the pruned index instead of blocking.
+	index, err := mb.Watch(ctx, "config/k0", 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != mb.reaped {
+		t.Errorf("watch on a pruned prefix = %d, want %d", index, mb.reaped)
+	}
+}
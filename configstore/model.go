@@ -1,13 +1,74 @@
 package configstore
 
 type Config struct {
-	ID      string            `json:"id"`
-	Version string            `json:"version"`
-	Entries map[string]string `json:"entries"`
+	ID      string           `json:"id"`
+	Version string           `json:"version"`
+	Entries map[string]Value `json:"entries"`
+	// Metadata is written by the store, e.g. MetaRollbackFrom. It is
+	// ignored when sent by clients.
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Parent is the config version this one is layered on, its entries
+	// only override those of the parent. Labels are not used.
+	Parent *ConfigRef `json:"parent,omitempty"`
+	// Secrets names the entries encrypted at rest. Their values are
+	// redacted unless revealed.
+	Secrets []string `json:"secrets,omitempty"`
+	// Sealed holds the encrypted secret entries while stored, it is never
+	// sent to or accepted from clients.
+	Sealed map[string]*Envelope `json:"sealed,omitempty"`
+}
+
+// Rollback asks for the entries of version From to be published again as
+// Version.
+type Rollback struct {
+	From    string `json:"from"`
+	Version string `json:"version"`
+}
+
+// Promote asks for a version to be copied into the next environment.
+// PromotedBy names who asked for it.
+type Promote struct {
+	PromotedBy string `json:"promotedBy"`
 }
 
 type Group struct {
 	ID      string              `json:"id"`
 	Configs []map[string]string `json:"configs"`
+	Refs    []*ConfigRef        `json:"refs,omitempty"`
 	Version string              `json:"version"`
 }
+
+// ConfigRef is a group member pointing at a stored config. Version is a
+// pinned version or LatestVersion to follow the newest one.
+type ConfigRef struct {
+	ID      string            `json:"id"`
+	Version string            `json:"version"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// ResolvedRef is a ConfigRef together with the config it points at. It is
+// dangling when that config version does not exist anymore.
+type ResolvedRef struct {
+	*ConfigRef
+	Config   *Config `json:"config,omitempty"`
+	Dangling bool    `json:"dangling,omitempty"`
+}
+
+// ResolvedGroup is a group as returned to clients, with its references
+// resolved.
+type ResolvedGroup struct {
+	*Group
+	Refs []*ResolvedRef `json:"refs,omitempty"`
+}
+
+// Event is a change to a config or group version seen in the store. Index
+// is the store index the change was made at, Seq tells apart the events
+// sharing it.
+type Event struct {
+	Index   uint64 `json:"index"`
+	Seq     int    `json:"seq"`
+	Type    string `json:"type"`
+	Kind    string `json:"kind"`
+	ID      string `json:"id"`
+	Version string `json:"version"`
+}
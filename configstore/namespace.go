@@ -0,0 +1,234 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultNamespace holds everything written without a namespace, it always
+// exists.
+const DefaultNamespace = "default"
+
+var (
+	ErrInvalidNamespace  = errors.New("Namespace names are 1 to 63 lowercase letters, digits and dashes")
+	ErrNamespaceNotEmpty = errors.New("Namespace is not empty")
+
+	namespaceName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// Namespace separates the configs and groups of one team from the others.
+// Every key of a namespace lives under its own prefix, so nothing read in
+// one namespace can see another.
+type Namespace struct {
+	Name string `json:"name"`
+	// CreatedAt is missing for the default namespace.
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+type namespaceCtxKey struct{}
+
+// WithNamespace returns ctx with the namespace every store call made with
+// it works in.
+func WithNamespace(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, namespaceCtxKey{}, name)
+}
+
+// NamespaceFrom returns the namespace of ctx, DefaultNamespace if it has
+// none.
+func NamespaceFrom(ctx context.Context) string {
+	if name, ok := ctx.Value(namespaceCtxKey{}).(string); ok && name != "" {
+		return name
+	}
+	return DefaultNamespace
+}
+
+func (cs *ConfigStore) CreateNamespace(ctx context.Context, name string) (*Namespace, error) {
+	span := tracer.StartSpanFromContext(ctx, "CreateNamespace")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if !namespaceName.MatchString(name) {
+		tracer.LogError(span, ErrInvalidNamespace)
+		return nil, ErrInvalidNamespace
+	}
+	if name == DefaultNamespace {
+		return nil, ErrConflict
+	}
+
+	now := time.Now().UTC()
+	ns := &Namespace{Name: name, CreatedAt: &now}
+	data, err := json.Marshal(ns)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base cas")
+	err = cs.db.Txn(ctx, []*TxnOp{{Verb: TxnCAS, Key: constructNamespaceKey(childCtx, name), Value: data}})
+	if err != nil {
+		tracer.LogError(putSpan, err)
+		return nil, err
+	}
+	putSpan.Finish()
+
+	return ns, nil
+}
+
+// FindNamespaces lists every namespace by name, the default one included.
+func (cs *ConfigStore) FindNamespaces(ctx context.Context) ([]*Namespace, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindNamespaces")
+	defer span.Finish()
+
+	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
+	data, err := cs.db.List(ctx, namespaceRoot)
+	if err != nil {
+		tracer.LogError(listSpan, err)
+		return nil, err
+	}
+	listSpan.Finish()
+
+	namespaces := []*Namespace{{Name: DefaultNamespace}}
+	for _, pair := range data {
+		ns := &Namespace{}
+		if err := json.Unmarshal(pair.Value, ns); err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		namespaces = append(namespaces, ns)
+	}
+
+	sort.SliceStable(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+// NamespaceExists reports whether the namespace was created. The default
+// namespace always exists.
+func (cs *ConfigStore) NamespaceExists(ctx context.Context, name string) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "NamespaceExists")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if name == DefaultNamespace {
+		return true, nil
+	}
+	if !namespaceName.MatchString(name) {
+		return false, nil
+	}
+
+	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
+	pair, err := cs.db.Get(ctx, constructNamespaceKey(childCtx, name))
+	if err != nil {
+		tracer.LogError(getSpan, err)
+		return false, err
+	}
+	getSpan.Finish()
+
+	return pair != nil, nil
+}
+
+// DeleteNamespace removes a namespace with everything in it, deleted
+// versions included, for good. Unless force is set only an empty namespace
+// is removed. The default namespace can not be deleted.
+func (cs *ConfigStore) DeleteNamespace(ctx context.Context, name string, force bool) error {
+	span := tracer.StartSpanFromContext(ctx, "DeleteNamespace")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if name == DefaultNamespace {
+		err := fmt.Errorf("%w: the %s namespace can not be deleted", ErrConflict, DefaultNamespace)
+		tracer.LogError(span, err)
+		return err
+	}
+
+	key := constructNamespaceKey(childCtx, name)
+
+	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
+	pair, err := cs.db.Get(ctx, key)
+	if err != nil {
+		tracer.LogError(getSpan, err)
+		return err
+	}
+	getSpan.Finish()
+	if pair == nil {
+		return ErrNotFound
+	}
+
+	prefix := constructNamespacePrefix(childCtx, name)
+	if !force {
+		for _, scopeCtx := range cs.environmentContexts(WithNamespace(childCtx, name)) {
+			for _, root := range []string{constructConfigRoot(scopeCtx), constructGroupRoot(scopeCtx)} {
+				keys, err := cs.db.Keys(ctx, root, "/")
+				if err != nil {
+					tracer.LogError(span, err)
+					return err
+				}
+				if len(keys) > 0 {
+					return ErrNamespaceNotEmpty
+				}
+			}
+		}
+	}
+
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.db.Txn(ctx, []*TxnOp{
+		{Verb: TxnDeleteCAS, Key: key, Index: pair.ModifyIndex},
+		{Verb: TxnDeleteTree, Key: prefix},
+	})
+	if err != nil {
+		tracer.LogError(txnSpan, err)
+		return err
+	}
+	txnSpan.Finish()
+
+	return nil
+}
+
+// scopeContexts returns a context for every namespace and every environment
+// in it, for jobs that go through all of them.
+func (cs *ConfigStore) scopeContexts(ctx context.Context) ([]context.Context, error) {
+	namespaces, err := cs.FindNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ctxs []context.Context
+	for _, ns := range namespaces {
+		ctxs = append(ctxs, cs.environmentContexts(WithNamespace(ctx, ns.Name))...)
+	}
+	return ctxs, nil
+}
+
+// environmentContexts returns ctx without an environment followed by ctx in
+// every environment.
+func (cs *ConfigStore) environmentContexts(ctx context.Context) []context.Context {
+	ctxs := []context.Context{WithEnvironment(ctx, "")}
+	for _, env := range cs.environments {
+		ctxs = append(ctxs, WithEnvironment(ctx, env))
+	}
+	return ctxs
+}
+
+// trimScope turns the keys of pairs read in the namespace and environment
+// of ctx into the keys the default namespace would have.
+func trimScope(ctx context.Context, pairs []*KVPair) []*KVPair {
+	prefix := scopePrefix(ctx)
+	if prefix == "" {
+		return pairs
+	}
+	for _, pair := range pairs {
+		pair.Key = strings.TrimPrefix(pair.Key, prefix)
+	}
+	return pairs
+}
@@ -0,0 +1,209 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+var ErrInvalidToken = errors.New("Invalid continuation token")
+
+// ListConfigs returns a page of config ids in key order together with the
+// token of the next page, which is empty on the last page.
+func (cs *ConfigStore) ListConfigs(ctx context.Context, token string, limit int) ([]string, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListConfigs")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	ids, err := cs.listIds(childCtx, constructConfigRoot(childCtx))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+	return page(ids, token, limit, strings.Compare)
+}
+
+// ListGroups returns a page of group ids, see ListConfigs.
+func (cs *ConfigStore) ListGroups(ctx context.Context, token string, limit int) ([]string, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListGroups")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	ids, err := cs.listIds(childCtx, constructGroupRoot(childCtx))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+	return page(ids, token, limit, strings.Compare)
+}
+
+// ListConfigVersions returns a page of the versions of a config in version
+// order. Only the keys are scanned, values are read for the page alone.
+func (cs *ConfigStore) ListConfigVersions(ctx context.Context, id, token string, limit int) ([]*Config, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListConfigVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	prefix := constructConfigIdKey(childCtx, id) + "/"
+	versions, next, err := cs.listVersions(childCtx, prefix, token, limit)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+
+	configs := []*Config{}
+	for _, ver := range versions {
+		config, err := cs.FindConfig(childCtx, id, ver)
+		if errors.Is(err, ErrNotFound) {
+			// Deleted after the keys were listed.
+			continue
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, "", err
+		}
+		configs = append(configs, config)
+	}
+	return configs, next, nil
+}
+
+// ListGroupVersions returns a page of the versions of a group, see
+// ListConfigVersions.
+func (cs *ConfigStore) ListGroupVersions(ctx context.Context, id, token string, limit int) ([]*Group, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "ListGroupVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	versions, next, err := cs.listVersions(childCtx, constructGroupIdKey(childCtx, id), token, limit)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, "", err
+	}
+
+	groups := []*Group{}
+	for _, ver := range versions {
+		group, err := cs.FindGroup(childCtx, id, ver)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, "", err
+		}
+		groups = append(groups, group)
+	}
+	return groups, next, nil
+}
+
+// listIds returns the ids found under root, one directory per id.
+func (cs *ConfigStore) listIds(ctx context.Context, root string) ([]string, error) {
+	span := tracer.StartSpanFromContext(ctx, "listIds")
+	defer span.Finish()
+
+	keysSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base keys")
+	keys, err := cs.db.Keys(ctx, root, "/")
+	if err != nil {
+		tracer.LogError(keysSpan, err)
+		return nil, err
+	}
+	keysSpan.Finish()
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if id := strings.TrimSuffix(strings.TrimPrefix(key, root), "/"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// listVersions returns a page of the version names stored directly under
+// prefix. Group labels live in directories below the versions and are
+// skipped by the separator.
+func (cs *ConfigStore) listVersions(ctx context.Context, prefix, token string, limit int) ([]string, string, error) {
+	span := tracer.StartSpanFromContext(ctx, "listVersions")
+	defer span.Finish()
+
+	keysSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base keys")
+	keys, err := cs.db.Keys(ctx, prefix, "/")
+	if err != nil {
+		tracer.LogError(keysSpan, err)
+		return nil, "", err
+	}
+	keysSpan.Finish()
+
+	versions := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			versions = append(versions, strings.TrimPrefix(key, prefix))
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+
+	return page(versions, token, limit, compareVersions)
+}
+
+// page returns up to limit items following the one the token points at.
+// items must be sorted by cmp. The token only records the last item
+// returned, so a page stays correct when items are added or removed.
+func page(items []string, token string, limit int, cmp func(a, b string) int) ([]string, string, error) {
+	start := 0
+	if token != "" {
+		after, err := decodeToken(token)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(items), func(i int) bool {
+			return cmp(items[i], after) > 0
+		})
+	}
+
+	switch {
+	case limit <= 0:
+		limit = DefaultPageSize
+	case limit > MaxPageSize:
+		limit = MaxPageSize
+	}
+	end := start + limit
+	if end >= len(items) {
+		return items[start:], "", nil
+	}
+	return items[start:end], encodeToken(items[end-1]), nil
+}
+
+type pageToken struct {
+	After string `json:"after"`
+}
+
+func encodeToken(after string) string {
+	data, _ := json.Marshal(&pageToken{After: after})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeToken(token string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	pt := &pageToken{}
+	if err := json.Unmarshal(data, pt); err != nil || pt.After == "" {
+		return "", ErrInvalidToken
+	}
+	return pt.After, nil
+}
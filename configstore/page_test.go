@@ -0,0 +1,161 @@
+package configstore
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// allPages follows the tokens of page from the first page to the last.
+func allPages(t *testing.T, items []string, limit int, cmp func(a, b string) int) []string {
+	t.Helper()
+
+	var got []string
+	token := ""
+	for i := 0; ; i++ {
+		if i > len(items)+1 {
+			t.Fatalf("tokens do not end, got %q so far", got)
+		}
+		p, next, err := page(items, token, limit, cmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p...)
+		if next == "" {
+			return got
+		}
+		token = next
+	}
+}
+
+func TestPage(t *testing.T) {
+	versions := []string{"v01", "v1", "v2", "v10", "1.0.0-rc.1", "1.0.0"}
+	ids := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		name  string
+		items []string
+		limit int
+		cmp   func(a, b string) int
+	}{
+		{"ids one by one", ids, 1, strings.Compare},
+		{"ids in pages of two", ids, 2, strings.Compare},
+		{"ids in one page", ids, 5, strings.Compare},
+		{"ids with the default limit", ids, 0, strings.Compare},
+		{"no ids", nil, 2, strings.Compare},
+		{"versions one by one", sortedVersions(versions), 1, compareVersions},
+		{"versions in pages of four", sortedVersions(versions), 4, compareVersions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allPages(t, tt.items, tt.limit, tt.cmp)
+			if strings.Join(got, " ") != strings.Join(tt.items, " ") {
+				t.Errorf("pages hold %q, want %q", got, tt.items)
+			}
+		})
+	}
+}
+
+func sortedVersions(versions []string) []string {
+	sorted := append([]string(nil), versions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareVersions(sorted[i], sorted[j]) < 0
+	})
+	return sorted
+}
+
+func TestPageTokenSurvivesChanges(t *testing.T) {
+	first, token, err := page([]string{"a", "b", "c", "d"}, "", 2, strings.Compare)
+	if err != nil || strings.Join(first, " ") != "a b" {
+		t.Fatalf("first page = %q, %v", first, err)
+	}
+
+	// b, the last item returned, is gone and a new item sorts before the
+	// token, the next page starts right after b anyway.
+	next, _, err := page([]string{"a", "aa", "c", "d"}, token, 2, strings.Compare)
+	if err != nil || strings.Join(next, " ") != "c d" {
+		t.Errorf("next page = %q, %v, want c d", next, err)
+	}
+}
+
+func TestPageLimits(t *testing.T) {
+	items := make([]string, MaxPageSize+10)
+	for i := range items {
+		items[i] = fmt.Sprintf("%05d", i)
+	}
+
+	tests := []struct {
+		limit int
+		want  int
+	}{
+		{-1, DefaultPageSize},
+		{0, DefaultPageSize},
+		{7, 7},
+		{MaxPageSize + 1, MaxPageSize},
+	}
+	for _, tt := range tests {
+		got, _, err := page(items, "", tt.limit, strings.Compare)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.want {
+			t.Errorf("limit %d returned %d items, want %d", tt.limit, len(got), tt.want)
+		}
+	}
+}
+
+func TestPageInvalidToken(t *testing.T) {
+	tests := []string{
+		"not base64!",
+		encodeRaw("not json"),
+		encodeRaw(`{"after": ""}`),
+		encodeRaw(`{}`),
+	}
+	for _, token := range tests {
+		if _, _, err := page([]string{"a"}, token, 1, strings.Compare); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("token %q: err = %v, want ErrInvalidToken", token, err)
+		}
+	}
+}
+
+func encodeRaw(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func TestListGroupVersionsSkipsLabels(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	group, err := cs.CreateGroup(ctx, &Group{Version: "v1", Configs: []map[string]string{{"env": "prod"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ver := range []string{"v10", "v2"} {
+		if _, err := cs.UpdateGroupVersion(ctx, &Group{ID: group.ID, Version: ver, Configs: group.Configs}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var versions []string
+	token := ""
+	for {
+		groups, next, err := cs.ListGroupVersions(ctx, group.ID, token, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, g := range groups {
+			versions = append(versions, g.Version)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	if got := strings.Join(versions, " "); got != "v1 v2 v10" {
+		t.Errorf("versions = %s, want v1 v2 v10", got)
+	}
+}
@@ -0,0 +1,252 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// compiledSchema is a schema version as cached by compileSchema. raw is
+// kept to notice a version registered again after its namespace was
+// deleted.
+type compiledSchema struct {
+	raw    []byte
+	schema *Schema
+}
+
+// SchemaVersion is a JSON Schema registered for a config. Every config ID
+// has its own list of schemas, numbered from 1, and new config versions are
+// checked against the newest one.
+type SchemaVersion struct {
+	ID      string          `json:"id"`
+	Version int             `json:"version"`
+	Schema  json.RawMessage `json:"schema"`
+}
+
+// RegisterSchema adds schema as the next schema version of the config.
+func (cs *ConfigStore) RegisterSchema(ctx context.Context, id string, schema []byte) (*SchemaVersion, error) {
+	span := tracer.StartSpanFromContext(ctx, "RegisterSchema")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if _, err := CompileSchema(schema); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	keysSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base keys")
+	keys, err := cs.db.Keys(ctx, constructConfigIdKey(childCtx, id)+"/", "/")
+	if err != nil {
+		tracer.LogError(keysSpan, err)
+		return nil, err
+	}
+	keysSpan.Finish()
+	if len(keys) == 0 {
+		return nil, ErrNotFound
+	}
+
+	versions, err := cs.schemaVersions(childCtx, id)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, schema); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	sv := &SchemaVersion{ID: id, Version: len(versions) + 1, Schema: buf.Bytes()}
+	if len(versions) > 0 {
+		sv.Version = versions[len(versions)-1] + 1
+	}
+
+	data, err := json.Marshal(sv)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// Two schemas registered at once get the same number, the CAS lets only
+	// one of them through.
+	putSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base cas")
+	key := constructSchemaKey(childCtx, id, sv.Version)
+	err = cs.db.Txn(ctx, []*TxnOp{{Verb: TxnCAS, Key: key, Value: data}})
+	if err != nil {
+		tracer.LogError(putSpan, err)
+		return nil, err
+	}
+	putSpan.Finish()
+
+	return sv, nil
+}
+
+// FindSchemas returns every schema version of the config, oldest first.
+func (cs *ConfigStore) FindSchemas(ctx context.Context, id string) ([]*SchemaVersion, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindSchemas")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
+	data, err := cs.db.List(ctx, constructSchemaIdKey(childCtx, id))
+	if err != nil {
+		tracer.LogError(listSpan, err)
+		return nil, err
+	}
+	listSpan.Finish()
+
+	schemas := []*SchemaVersion{}
+	for _, pair := range data {
+		sv := &SchemaVersion{}
+		if err := json.Unmarshal(pair.Value, sv); err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		schemas = append(schemas, sv)
+	}
+
+	sort.SliceStable(schemas, func(i, j int) bool {
+		return schemas[i].Version < schemas[j].Version
+	})
+	return schemas, nil
+}
+
+// FindSchema returns one schema version of the config, ver being its number
+// or LatestVersion.
+func (cs *ConfigStore) FindSchema(ctx context.Context, id, ver string) (*SchemaVersion, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindSchema")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	var n int
+	if ver == LatestVersion {
+		versions, err := cs.schemaVersions(childCtx, id)
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, ErrNotFound
+		}
+		n = versions[len(versions)-1]
+	} else {
+		var err error
+		if n, err = strconv.Atoi(ver); err != nil {
+			return nil, ErrNotFound
+		}
+	}
+
+	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
+	data, err := cs.db.Get(ctx, constructSchemaKey(childCtx, id, n))
+	if err != nil || data == nil {
+		tracer.LogError(getSpan, err)
+		return nil, ErrNotFound
+	}
+	getSpan.Finish()
+
+	sv := &SchemaVersion{}
+	if err := json.Unmarshal(data.Value, sv); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return sv, nil
+}
+
+// schemaVersions returns the schema version numbers of the config, sorted.
+func (cs *ConfigStore) schemaVersions(ctx context.Context, id string) ([]int, error) {
+	span := tracer.StartSpanFromContext(ctx, "schemaVersions")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	prefix := constructSchemaIdKey(childCtx, id)
+	keys, err := cs.db.Keys(ctx, prefix, "/")
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(keys))
+	for _, key := range keys {
+		if n, err := strconv.Atoi(strings.TrimPrefix(key, prefix)); err == nil {
+			versions = append(versions, n)
+		}
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+// validateConfig checks config against the newest schema of its config ID.
+// A config without a schema is always valid.
+func (cs *ConfigStore) validateConfig(ctx context.Context, config *Config) error {
+	span := tracer.StartSpanFromContext(ctx, "validateConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	sv, err := cs.FindSchema(childCtx, config.ID, LatestVersion)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	schema, err := cs.compileSchema(childCtx, sv)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	// A layered config only holds overrides, the schema applies to what
+	// it merges into.
+	merged, err := cs.MergeConfig(childCtx, config, true)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	violations, err := schema.Validate(merged.Entries)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if len(violations) > 0 {
+		err := &ValidationError{SchemaVersion: sv.Version, Violations: violations}
+		tracer.LogError(span, err)
+		return err
+	}
+	return nil
+}
+
+// compileSchema returns sv compiled. Schema versions never change, so each
+// one is compiled once and kept for every later validation.
+func (cs *ConfigStore) compileSchema(ctx context.Context, sv *SchemaVersion) (*Schema, error) {
+	span := tracer.StartSpanFromContext(ctx, "compileSchema")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	key := constructSchemaKey(childCtx, sv.ID, sv.Version)
+	if cached, ok := cs.schemas.Load(key); ok {
+		if c := cached.(*compiledSchema); bytes.Equal(c.raw, sv.Schema) {
+			return c.schema, nil
+		}
+	}
+
+	schema, err := CompileSchema(sv.Schema)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	cs.schemas.Store(key, &compiledSchema{raw: sv.Schema, schema: schema})
+	return schema, nil
+}
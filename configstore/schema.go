@@ -0,0 +1,625 @@
+package configstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxSchemaDepth bounds how deep $ref may nest, so a schema referring
+	// to itself without descending into the instance can not loop forever.
+	maxSchemaDepth = 64
+
+	// Numbers are compared exactly, so their size is bounded: 1e999999999
+	// would otherwise be expanded digit by digit. Both limits are far
+	// beyond what a float64 holds.
+	maxNumberLength   = 100
+	maxNumberExponent = 400
+)
+
+var ErrInvalidSchema = errors.New("Invalid schema")
+
+// Violation is one way a config failed its schema. Path is a JSON pointer
+// into the entries of the config, "" being the entries themselves.
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a new config version does not satisfy the
+// schema registered for its config.
+type ValidationError struct {
+	SchemaVersion int         `json:"schemaVersion"`
+	Violations    []Violation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = fmt.Sprintf("%s: %s", v.Path, v.Message)
+	}
+	return fmt.Sprintf("config does not match schema version %d: %s", e.SchemaVersion, strings.Join(msgs, "; "))
+}
+
+// Schema is a compiled JSON Schema. The keywords of draft 2020-12 that
+// concern validation of plain JSON are supported: type, enum, const, the
+// numeric, string, array and object constraints, allOf, anyOf, oneOf, not
+// and $ref to the schema itself ("#", "#/$defs/name"). Other keywords such
+// as format are ignored, as the specification allows.
+type Schema struct {
+	root interface{}
+	// patterns holds every pattern and patternProperties name of the
+	// schema, compiled once.
+	patterns map[string]*regexp.Regexp
+}
+
+// CompileSchema parses a schema and checks that the supported keywords are
+// well formed.
+func CompileSchema(data []byte) (*Schema, error) {
+	root, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSchema, err)
+	}
+
+	s := &Schema{root: root, patterns: make(map[string]*regexp.Regexp)}
+	if err := s.check(root, "", 0); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Validate returns the violations of entries, sorted by path.
+func (s *Schema) Validate(entries map[string]Value) ([]Violation, error) {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := s.validate(s.root, instance, "", 0)
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Path < violations[j].Path
+	})
+	return violations, nil
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+func (s *Schema) check(node interface{}, path string, depth int) error {
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: at %q: %s", ErrInvalidSchema, path, fmt.Sprintf(format, args...))
+	}
+
+	if _, ok := node.(bool); ok {
+		return nil
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return fail("a schema must be an object or a boolean")
+	}
+	if depth > maxSchemaDepth {
+		return fail("schema is nested too deep")
+	}
+
+	for key, value := range obj {
+		sub := path + "/" + escapePointer(key)
+		switch key {
+		case "type":
+			types, ok := stringList(value)
+			if !ok {
+				return fail("type must be a string or a list of strings")
+			}
+			for _, t := range types {
+				if !contains([]string{"null", "boolean", "object", "array", "number", "string", "integer"}, t) {
+					return fail("unknown type %q", t)
+				}
+			}
+		case "enum":
+			if _, ok := value.([]interface{}); !ok {
+				return fail("enum must be a list")
+			}
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			if _, ok := toRat(value); !ok {
+				return fail("%s must be a number of at most %d characters and exponent %d", key, maxNumberLength, maxNumberExponent)
+			}
+		case "multipleOf":
+			if r, ok := toRat(value); !ok || r.Sign() <= 0 {
+				return fail("multipleOf must be a number greater than 0")
+			}
+		case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+			if n, ok := toRat(value); !ok || !n.IsInt() || n.Sign() < 0 {
+				return fail("%s must be a non-negative integer", key)
+			}
+		case "uniqueItems":
+			if _, ok := value.(bool); !ok {
+				return fail("uniqueItems must be a boolean")
+			}
+		case "pattern":
+			p, ok := value.(string)
+			if !ok {
+				return fail("pattern must be a string")
+			}
+			if err := s.compilePattern(p); err != nil {
+				return fail("pattern: %v", err)
+			}
+		case "required":
+			if _, ok := stringList(value); !ok {
+				return fail("required must be a list of strings")
+			}
+		case "properties", "patternProperties", "$defs", "definitions":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return fail("%s must be an object", key)
+			}
+			for name, prop := range props {
+				if key == "patternProperties" {
+					if err := s.compilePattern(name); err != nil {
+						return fail("patternProperties: %v", err)
+					}
+				}
+				if err := s.check(prop, sub+"/"+escapePointer(name), depth+1); err != nil {
+					return err
+				}
+			}
+		case "additionalProperties", "items", "not":
+			if err := s.check(value, sub, depth+1); err != nil {
+				return err
+			}
+		case "allOf", "anyOf", "oneOf":
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return fail("%s must be a non-empty list of schemas", key)
+			}
+			for i, item := range list {
+				if err := s.check(item, fmt.Sprintf("%s/%d", sub, i), depth+1); err != nil {
+					return err
+				}
+			}
+		case "$ref":
+			ref, ok := value.(string)
+			if !ok {
+				return fail("$ref must be a string")
+			}
+			if _, err := s.resolve(ref); err != nil {
+				return fail("%v", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compilePattern(p string) error {
+	if _, ok := s.patterns[p]; ok {
+		return nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return err
+	}
+	s.patterns[p] = re
+	return nil
+}
+
+// resolve looks up a $ref in the schema itself. References to other
+// documents are not supported.
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("$ref %q: only references within the schema (#/...) are supported", ref)
+	}
+
+	node := s.root
+	for _, token := range strings.Split(ref[2:], "/") {
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref %q does not point into the schema", ref)
+		}
+		if node, ok = obj[unescapePointer(token)]; !ok {
+			return nil, fmt.Errorf("$ref %q does not point into the schema", ref)
+		}
+	}
+	return node, nil
+}
+
+func (s *Schema) validate(node, instance interface{}, path string, depth int) []Violation {
+	var violations []Violation
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if b, ok := node.(bool); ok {
+		if !b {
+			add("no value is allowed here")
+		}
+		return violations
+	}
+	obj, _ := node.(map[string]interface{})
+	if depth > maxSchemaDepth {
+		add("schema recursion is too deep")
+		return violations
+	}
+
+	if ref, ok := obj["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			add("%v", err)
+		} else {
+			violations = append(violations, s.validate(target, instance, path, depth+1)...)
+		}
+	}
+
+	if types, ok := stringList(obj["type"]); ok {
+		matched := false
+		for _, t := range types {
+			if hasType(instance, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			add("expected %s, got %s", strings.Join(types, " or "), typeName(instance))
+			// The remaining keywords would only repeat the same problem.
+			return violations
+		}
+	}
+
+	if enum, ok := obj["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, instance) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			add("must be one of %s", compactJSON(enum))
+		}
+	}
+	if c, ok := obj["const"]; ok && !jsonEqual(c, instance) {
+		add("must be %s", compactJSON(c))
+	}
+
+	switch v := instance.(type) {
+	case json.Number:
+		violations = append(violations, validateNumber(obj, v, path)...)
+	case string:
+		violations = append(violations, s.validateString(obj, v, path)...)
+	case []interface{}:
+		violations = append(violations, s.validateArray(obj, v, path, depth)...)
+	case map[string]interface{}:
+		violations = append(violations, s.validateObject(obj, v, path, depth)...)
+	}
+
+	if all, ok := obj["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			violations = append(violations, s.validate(sub, instance, path, depth+1)...)
+		}
+	}
+	if anyOf, ok := obj["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, sub := range anyOf {
+			if len(s.validate(sub, instance, path, depth+1)) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			add("must match at least one schema in anyOf")
+		}
+	}
+	if oneOf, ok := obj["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range oneOf {
+			if len(s.validate(sub, instance, path, depth+1)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			add("must match exactly one schema in oneOf, matched %d", matched)
+		}
+	}
+	if not, ok := obj["not"]; ok && len(s.validate(not, instance, path, depth+1)) == 0 {
+		add("must not match the schema in not")
+	}
+
+	return violations
+}
+
+func validateNumber(obj map[string]interface{}, n json.Number, path string) []Violation {
+	var violations []Violation
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	value, ok := toRat(n)
+	if !ok {
+		add("number is too large to be validated")
+		return violations
+	}
+	if limit, ok := toRat(obj["minimum"]); ok && value.Cmp(limit) < 0 {
+		add("must be >= %s", obj["minimum"])
+	}
+	if limit, ok := toRat(obj["maximum"]); ok && value.Cmp(limit) > 0 {
+		add("must be <= %s", obj["maximum"])
+	}
+	if limit, ok := toRat(obj["exclusiveMinimum"]); ok && value.Cmp(limit) <= 0 {
+		add("must be > %s", obj["exclusiveMinimum"])
+	}
+	if limit, ok := toRat(obj["exclusiveMaximum"]); ok && value.Cmp(limit) >= 0 {
+		add("must be < %s", obj["exclusiveMaximum"])
+	}
+	if m, ok := toRat(obj["multipleOf"]); ok && m.Sign() > 0 {
+		if !new(big.Rat).Quo(value, m).IsInt() {
+			add("must be a multiple of %s", obj["multipleOf"])
+		}
+	}
+	return violations
+}
+
+func (s *Schema) validateString(obj map[string]interface{}, str string, path string) []Violation {
+	var violations []Violation
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	length := utf8.RuneCountInString(str)
+	if n, ok := toInt(obj["minLength"]); ok && length < n {
+		add("must be at least %d characters long", n)
+	}
+	if n, ok := toInt(obj["maxLength"]); ok && length > n {
+		add("must be at most %d characters long", n)
+	}
+	if p, ok := obj["pattern"].(string); ok {
+		if re, ok := s.patterns[p]; ok && !re.MatchString(str) {
+			add("must match pattern %q", p)
+		}
+	}
+	return violations
+}
+
+func (s *Schema) validateArray(obj map[string]interface{}, items []interface{}, path string, depth int) []Violation {
+	var violations []Violation
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n, ok := toInt(obj["minItems"]); ok && len(items) < n {
+		add("must have at least %d items", n)
+	}
+	if n, ok := toInt(obj["maxItems"]); ok && len(items) > n {
+		add("must have at most %d items", n)
+	}
+	if unique, _ := obj["uniqueItems"].(bool); unique {
+		for i := range items {
+			for j := i + 1; j < len(items); j++ {
+				if jsonEqual(items[i], items[j]) {
+					add("items %d and %d must not be equal", i, j)
+				}
+			}
+		}
+	}
+	if sub, ok := obj["items"]; ok {
+		for i, item := range items {
+			violations = append(violations, s.validate(sub, item, fmt.Sprintf("%s/%d", path, i), depth+1)...)
+		}
+	}
+	return violations
+}
+
+func (s *Schema) validateObject(obj map[string]interface{}, props map[string]interface{}, path string, depth int) []Violation {
+	var violations []Violation
+	add := func(format string, args ...interface{}) {
+		violations = append(violations, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if n, ok := toInt(obj["minProperties"]); ok && len(props) < n {
+		add("must have at least %d properties", n)
+	}
+	if n, ok := toInt(obj["maxProperties"]); ok && len(props) > n {
+		add("must have at most %d properties", n)
+	}
+	if required, ok := stringList(obj["required"]); ok {
+		for _, name := range required {
+			if _, ok := props[name]; !ok {
+				add("missing required property %q", name)
+			}
+		}
+	}
+
+	defined, _ := obj["properties"].(map[string]interface{})
+	patterns, _ := obj["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := obj["additionalProperties"]
+
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := props[name]
+		sub := path + "/" + escapePointer(name)
+
+		matched := false
+		if schema, ok := defined[name]; ok {
+			matched = true
+			violations = append(violations, s.validate(schema, value, sub, depth+1)...)
+		}
+		for pattern, schema := range patterns {
+			if re, ok := s.patterns[pattern]; ok && re.MatchString(name) {
+				matched = true
+				violations = append(violations, s.validate(schema, value, sub, depth+1)...)
+			}
+		}
+		if matched || !hasAdditional {
+			continue
+		}
+		if b, ok := additional.(bool); ok && !b {
+			violations = append(violations, Violation{Path: sub, Message: "property is not allowed"})
+			continue
+		}
+		violations = append(violations, s.validate(additional, value, sub, depth+1)...)
+	}
+	return violations
+}
+
+func hasType(v interface{}, t string) bool {
+	switch t {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		r, ok := toRat(v)
+		return ok && r.IsInt()
+	}
+	return false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	}
+	return "unknown"
+}
+
+// toRat converts a JSON number exactly. Numbers longer than
+// maxNumberLength or with an exponent beyond maxNumberExponent are refused.
+func toRat(v interface{}) (*big.Rat, bool) {
+	n, ok := v.(json.Number)
+	if !ok || len(n) > maxNumberLength {
+		return nil, false
+	}
+	if i := strings.IndexAny(string(n), "eE"); i >= 0 {
+		exp, err := strconv.Atoi(string(n[i+1:]))
+		if err != nil || exp > maxNumberExponent || exp < -maxNumberExponent {
+			return nil, false
+		}
+	}
+	return new(big.Rat).SetString(string(n))
+}
+
+func toInt(v interface{}) (int, bool) {
+	r, ok := toRat(v)
+	if !ok || !r.IsInt() || !r.Num().IsInt64() {
+		return 0, false
+	}
+	return int(r.Num().Int64()), true
+}
+
+func stringList(v interface{}) ([]string, bool) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			list[i] = s
+		}
+		return list, true
+	}
+	return nil, false
+}
+
+// jsonEqual compares decoded JSON values, numbers by value so 1 equals 1.0.
+func jsonEqual(a, b interface{}) bool {
+	ra, okA := toRat(a)
+	rb, okB := toRat(b)
+	if okA || okB {
+		return okA && okB && ra.Cmp(rb) == 0
+	}
+
+	switch a := a.(type) {
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func compactJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func escapePointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
+
+func unescapePointer(token string) string {
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+}
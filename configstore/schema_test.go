@@ -0,0 +1,168 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// violationPaths validates entries, a JSON object, against schema and
+// returns the paths of the violations.
+func violationPaths(t *testing.T, schema, entries string) []string {
+	t.Helper()
+
+	s, err := CompileSchema([]byte(schema))
+	if err != nil {
+		t.Fatalf("compiling %s: %v", schema, err)
+	}
+	instance := map[string]Value{}
+	if err := decodeEntries(entries, &instance); err != nil {
+		t.Fatalf("decoding %s: %v", entries, err)
+	}
+	violations, err := s.Validate(instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{}
+	for _, v := range violations {
+		paths = append(paths, v.Path)
+	}
+	return paths
+}
+
+func decodeEntries(entries string, instance *map[string]Value) error {
+	v, err := decodeJSON([]byte(entries))
+	if err != nil {
+		return err
+	}
+	for k, e := range v.(map[string]interface{}) {
+		(*instance)[k] = Value(compactJSON(e))
+	}
+	return nil
+}
+
+func TestSchemaKeywords(t *testing.T) {
+	tests := []struct {
+		keyword string
+		schema  string
+		entries string
+		want    []string
+	}{
+		{"type", `{"properties": {"a": {"type": "string"}}}`, `{"a": "x"}`, nil},
+		{"type", `{"properties": {"a": {"type": "string"}}}`, `{"a": 1}`, []string{"/a"}},
+		{"type list", `{"properties": {"a": {"type": ["string", "null"]}}}`, `{"a": null}`, nil},
+		{"type integer", `{"properties": {"a": {"type": "integer"}}}`, `{"a": 1.0}`, nil},
+		{"type integer", `{"properties": {"a": {"type": "integer"}}}`, `{"a": 1.5}`, []string{"/a"}},
+		{"enum", `{"properties": {"a": {"enum": ["x", 1]}}}`, `{"a": 1.0}`, nil},
+		{"enum", `{"properties": {"a": {"enum": ["x", 1]}}}`, `{"a": "y"}`, []string{"/a"}},
+		{"const", `{"properties": {"a": {"const": {"b": [1]}}}}`, `{"a": {"b": [1]}}`, nil},
+		{"const", `{"properties": {"a": {"const": {"b": [1]}}}}`, `{"a": {"b": [2]}}`, []string{"/a"}},
+		{"minimum", `{"properties": {"a": {"minimum": 2}}}`, `{"a": 2}`, nil},
+		{"minimum", `{"properties": {"a": {"minimum": 2}}}`, `{"a": 1.99}`, []string{"/a"}},
+		{"maximum", `{"properties": {"a": {"maximum": 2}}}`, `{"a": 2.01}`, []string{"/a"}},
+		{"exclusiveMinimum", `{"properties": {"a": {"exclusiveMinimum": 2}}}`, `{"a": 2}`, []string{"/a"}},
+		{"exclusiveMaximum", `{"properties": {"a": {"exclusiveMaximum": 2}}}`, `{"a": 1}`, nil},
+		{"multipleOf", `{"properties": {"a": {"multipleOf": 0.1}}}`, `{"a": 0.3}`, nil},
+		{"multipleOf", `{"properties": {"a": {"multipleOf": 0.1}}}`, `{"a": 0.35}`, []string{"/a"}},
+		{"large number", `{"properties": {"a": {"minimum": 0}}}`, `{"a": 1e999999999}`, []string{"/a"}},
+		{"minLength", `{"properties": {"a": {"minLength": 2}}}`, `{"a": "é"}`, []string{"/a"}},
+		{"maxLength", `{"properties": {"a": {"maxLength": 2}}}`, `{"a": "éé"}`, nil},
+		{"pattern", `{"properties": {"a": {"pattern": "^v[0-9]+$"}}}`, `{"a": "v10"}`, nil},
+		{"pattern", `{"properties": {"a": {"pattern": "^v[0-9]+$"}}}`, `{"a": "x10"}`, []string{"/a"}},
+		{"minItems", `{"properties": {"a": {"minItems": 1}}}`, `{"a": []}`, []string{"/a"}},
+		{"maxItems", `{"properties": {"a": {"maxItems": 1}}}`, `{"a": [1, 2]}`, []string{"/a"}},
+		{"uniqueItems", `{"properties": {"a": {"uniqueItems": true}}}`, `{"a": [1, 1.0]}`, []string{"/a"}},
+		{"items", `{"properties": {"a": {"items": {"type": "string"}}}}`, `{"a": ["x", 1]}`, []string{"/a/1"}},
+		{"required", `{"required": ["a", "b"]}`, `{"a": 1}`, []string{""}},
+		{"minProperties", `{"minProperties": 2}`, `{"a": 1}`, []string{""}},
+		{"maxProperties", `{"maxProperties": 1}`, `{"a": 1, "b": 2}`, []string{""}},
+		{"additionalProperties", `{"properties": {"a": {}}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, []string{"/b"}},
+		{"additionalProperties", `{"additionalProperties": {"type": "number"}}`, `{"a": 1, "b": "x"}`, []string{"/b"}},
+		{"patternProperties", `{"patternProperties": {"^db_": {"type": "string"}}, "additionalProperties": false}`, `{"db_host": "x", "db_port": 1}`, []string{"/db_port"}},
+		{"allOf", `{"properties": {"a": {"allOf": [{"minimum": 1}, {"maximum": 3}]}}}`, `{"a": 4}`, []string{"/a"}},
+		{"anyOf", `{"properties": {"a": {"anyOf": [{"type": "string"}, {"minimum": 3}]}}}`, `{"a": 4}`, nil},
+		{"anyOf", `{"properties": {"a": {"anyOf": [{"type": "string"}, {"minimum": 3}]}}}`, `{"a": 2}`, []string{"/a"}},
+		{"oneOf", `{"properties": {"a": {"oneOf": [{"minimum": 1}, {"minimum": 2}]}}}`, `{"a": 1}`, nil},
+		{"oneOf", `{"properties": {"a": {"oneOf": [{"minimum": 1}, {"minimum": 2}]}}}`, `{"a": 3}`, []string{"/a"}},
+		{"not", `{"properties": {"a": {"not": {"type": "string"}}}}`, `{"a": "x"}`, []string{"/a"}},
+		{"boolean schema", `{"properties": {"a": false}}`, `{"a": 1}`, []string{"/a"}},
+		{"$ref", `{"$defs": {"port": {"maximum": 65535}}, "properties": {"a": {"$ref": "#/$defs/port"}}}`, `{"a": 70000}`, []string{"/a"}},
+		{"$ref to root", `{"type": "object", "additionalProperties": {"anyOf": [{"type": "string"}, {"$ref": "#"}]}}`, `{"a": {"b": {"c": 1}}}`, []string{"/a"}},
+		{"escaped path", `{"properties": {"a/b": {"type": "string"}}}`, `{"a/b": 1}`, []string{"/a~1b"}},
+		{"unknown keyword", `{"properties": {"a": {"format": "email"}}}`, `{"a": "x"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keyword, func(t *testing.T) {
+			got := violationPaths(t, tt.schema, tt.entries)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("%s with %s: violations at %q, want %q", tt.schema, tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileSchemaErrors(t *testing.T) {
+	tests := []string{
+		`[]`,
+		`{"type": "float"}`,
+		`{"type": 1}`,
+		`{"enum": "x"}`,
+		`{"minimum": "1"}`,
+		`{"minimum": 1e999999999}`,
+		`{"maximum": 1` + strings.Repeat("0", maxNumberLength) + `}`,
+		`{"multipleOf": 0}`,
+		`{"minLength": -1}`,
+		`{"maxItems": 1.5}`,
+		`{"uniqueItems": "yes"}`,
+		`{"pattern": "("}`,
+		`{"patternProperties": {"(": {}}}`,
+		`{"required": [1]}`,
+		`{"properties": []}`,
+		`{"allOf": []}`,
+		`{"$ref": "other.json"}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"not": 1}`,
+	}
+	for _, schema := range tests {
+		if _, err := CompileSchema([]byte(schema)); !errors.Is(err, ErrInvalidSchema) {
+			t.Errorf("CompileSchema(%s) = %v, want ErrInvalidSchema", schema, err)
+		}
+	}
+}
+
+func TestSchemaRecursionIsBounded(t *testing.T) {
+	paths := violationPaths(t, `{"$ref": "#"}`, `{}`)
+	if len(paths) != 1 {
+		t.Errorf("self reference gave violations at %q, want one", paths)
+	}
+}
+
+func TestCompileSchemaCache(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	sv := &SchemaVersion{ID: "c", Version: 1, Schema: []byte(`{"properties": {"a": {"pattern": "^x"}}}`)}
+	first, err := cs.compileSchema(ctx, sv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := cs.compileSchema(ctx, sv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("schema version was compiled twice")
+	}
+
+	// The same version registered again with another schema.
+	changed := &SchemaVersion{ID: "c", Version: 1, Schema: []byte(`{"properties": {"a": {"pattern": "^y"}}}`)}
+	third, err := cs.compileSchema(ctx, changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third == first {
+		t.Errorf("changed schema was served from the cache")
+	}
+}
@@ -0,0 +1,319 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	MatchExact  = "exact"
+	MatchPrefix = "prefix"
+	MatchRegex  = "regex"
+
+	// Only this many bytes of a value go into its index key. Longer values
+	// share a key with their prefix and are told apart by the stored hit.
+	maxIndexedValue = 256
+
+	// The index is written in transactions of at most this many ops, which
+	// is what Consul accepts.
+	indexBatchSize = maxConsulTxnOps
+)
+
+var ErrInvalidSearch = errors.New("Invalid search")
+
+// SearchQuery looks for config entries by key, value or both. Match applies
+// to both patterns and is one of MatchExact, MatchPrefix or MatchRegex.
+// Values are matched by their Text, so 5432 finds the number and the string.
+type SearchQuery struct {
+	Key   string
+	Value string
+	Match string
+}
+
+// SearchHit is one entry of one config version matching a SearchQuery.
+type SearchHit struct {
+	ID      string `json:"id"`
+	Version string `json:"version"`
+	Key     string `json:"key"`
+	Value   Value  `json:"value"`
+}
+
+// Search finds config entries through the search index. Every entry is
+// indexed twice, under its key and under its value, so exact and prefix
+// searches read a single index range. A regex search scans the index, never
+// the configs themselves.
+func (cs *ConfigStore) Search(ctx context.Context, q *SearchQuery) ([]*SearchHit, error) {
+	span := tracer.StartSpanFromContext(ctx, "Search")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	matchKey, err := matcher(q.Key, q.Match)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	matchValue, err := matcher(q.Value, q.Match)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if matchKey == nil && matchValue == nil {
+		err := fmt.Errorf("%w: a key or value is required", ErrInvalidSearch)
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
+	pairs, err := cs.db.List(ctx, searchRange(childCtx, q))
+	if err != nil {
+		tracer.LogError(listSpan, err)
+		return nil, err
+	}
+	listSpan.Finish()
+
+	hits := []*SearchHit{}
+	for _, pair := range pairs {
+		hit := &SearchHit{}
+		if err := json.Unmarshal(pair.Value, hit); err != nil {
+			log.Default().Printf("skipping malformed search index key %q", pair.Key)
+			continue
+		}
+		if matchKey != nil && !matchKey(hit.Key) || matchValue != nil && !matchValue(hit.Value.Text()) {
+			continue
+		}
+		hits = append(hits, hit)
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		if c := compareVersions(a.Version, b.Version); c != 0 {
+			return c < 0
+		}
+		return a.Key < b.Key
+	})
+	return hits, nil
+}
+
+// searchRange picks the smallest index range holding every possible hit of
+// q. Keys and values are query escaped, which keeps prefixes intact.
+func searchRange(ctx context.Context, q *SearchQuery) string {
+	span := tracer.StartSpanFromContext(ctx, "searchRange")
+	defer span.Finish()
+
+	switch {
+	case q.Match == MatchRegex:
+		return constructSearchKeyPrefix(ctx, "")
+	case q.Key != "" && q.Match == MatchExact:
+		return constructSearchKeyPrefix(ctx, q.Key) + "/"
+	case q.Key != "":
+		return constructSearchKeyPrefix(ctx, q.Key)
+	case q.Match == MatchExact || len(q.Value) >= maxIndexedValue:
+		return constructSearchValuePrefix(ctx, q.Value) + "/"
+	}
+	return constructSearchValuePrefix(ctx, q.Value)
+}
+
+func matcher(pattern, match string) (func(string) bool, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	switch match {
+	case MatchExact:
+		return func(s string) bool { return s == pattern }, nil
+	case MatchPrefix:
+		return func(s string) bool { return strings.HasPrefix(s, pattern) }, nil
+	case MatchRegex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSearch, err)
+		}
+		return re.MatchString, nil
+	}
+	return nil, fmt.Errorf("%w: unknown match %q, expected %s, %s or %s", ErrInvalidSearch, match, MatchExact, MatchPrefix, MatchRegex)
+}
+
+// searchPairs returns the search index keys of the entries of a stored
+// config version with their values. A nil config has none.
+func searchPairs(ctx context.Context, config *Config) (map[string][]byte, error) {
+	span := tracer.StartSpanFromContext(ctx, "searchPairs")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	pairs := make(map[string][]byte)
+	if config == nil {
+		return pairs, nil
+	}
+	for k, v := range config.Entries {
+		hit, err := json.Marshal(&SearchHit{ID: config.ID, Version: config.Version, Key: k, Value: v})
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		for _, key := range searchKeys(childCtx, config.ID, config.Version, k, v.Text()) {
+			pairs[key] = hit
+		}
+	}
+	return pairs, nil
+}
+
+// indexOps returns the ops turning the search index of config version old
+// into that of config, either of which may be nil, and in the same order
+// the ops reverting each of them.
+func indexOps(ctx context.Context, old, config *Config) ([]*TxnOp, []*TxnOp, error) {
+	span := tracer.StartSpanFromContext(ctx, "indexOps")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	before, err := searchPairs(childCtx, old)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+	after, err := searchPairs(childCtx, config)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, nil, err
+	}
+
+	var ops, undo []*TxnOp
+	for _, key := range sortedKeys(before) {
+		if _, ok := after[key]; !ok {
+			ops = append(ops, &TxnOp{Verb: TxnDelete, Key: key})
+			undo = append(undo, &TxnOp{Verb: TxnSet, Key: key, Value: before[key]})
+		}
+	}
+	for _, key := range sortedKeys(after) {
+		ops = append(ops, &TxnOp{Verb: TxnSet, Key: key, Value: after[key]})
+		if value, ok := before[key]; ok {
+			undo = append(undo, &TxnOp{Verb: TxnSet, Key: key, Value: value})
+		} else {
+			undo = append(undo, &TxnOp{Verb: TxnDelete, Key: key})
+		}
+	}
+	return ops, undo, nil
+}
+
+func sortedKeys(pairs map[string][]byte) []string {
+	keys := make([]string, 0, len(pairs))
+	for key := range pairs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// txnIndexed applies ops, which write or delete a config version, in one
+// transaction with index, the ops of indexOps for that change. Index ops
+// that do not fit into a Consul transaction next to ops are applied first
+// and reverted with undo if ops fail, so the index never misses a stored
+// version. Undo may be nil for index ops that always fit.
+func (cs *ConfigStore) txnIndexed(ctx context.Context, ops, index, undo []*TxnOp) error {
+	span := tracer.StartSpanFromContext(ctx, "txnIndexed")
+	defer span.Finish()
+
+	room := indexBatchSize - len(ops)
+	if room < 0 {
+		room = 0
+	}
+	if room > len(index) {
+		room = len(index)
+	}
+	early := index[room:]
+	var revert []*TxnOp
+	if len(undo) > room {
+		revert = undo[room:]
+	}
+
+	if err := cs.txnBatches(ctx, early); err != nil {
+		tracer.LogError(span, err)
+		cs.undoIndex(ctx, revert)
+		return err
+	}
+	if err := cs.db.Txn(ctx, append(ops, index[:room]...)); err != nil {
+		tracer.LogError(span, err)
+		cs.undoIndex(ctx, revert)
+		return err
+	}
+	return nil
+}
+
+// undoIndex reverts index ops applied ahead of a failed transaction. The
+// transaction already failed, so a failure here is only logged.
+func (cs *ConfigStore) undoIndex(ctx context.Context, undo []*TxnOp) {
+	if err := cs.txnBatches(ctx, undo); err != nil {
+		log.Default().Printf("reverting search index ops failed: %v", err)
+	}
+}
+
+// BuildSearchIndex indexes every config of the namespace once, for stores
+// written before the search index existed. Later calls return right away.
+func (cs *ConfigStore) BuildSearchIndex(ctx context.Context) error {
+	span := tracer.StartSpanFromContext(ctx, "BuildSearchIndex")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	marker := scopePrefix(childCtx) + searchMarker
+	pair, err := cs.db.Get(ctx, marker)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if pair != nil {
+		return nil
+	}
+
+	pairs, err := cs.db.List(ctx, constructConfigRoot(childCtx))
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	for _, pair := range pairs {
+		config := &Config{}
+		if err := json.Unmarshal(pair.Value, config); err != nil {
+			log.Default().Printf("skipping malformed config %q", pair.Key)
+			continue
+		}
+		ops, _, err := indexOps(childCtx, nil, config)
+		if err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+		if err := cs.txnBatches(ctx, ops); err != nil {
+			tracer.LogError(span, err)
+			return err
+		}
+	}
+
+	return cs.db.Put(ctx, &KVPair{Key: marker, Value: []byte("1")})
+}
+
+// txnBatches applies ops in transactions of at most indexBatchSize ops.
+// Unlike Txn the ops are not applied atomically as a whole.
+func (cs *ConfigStore) txnBatches(ctx context.Context, ops []*TxnOp) error {
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > indexBatchSize {
+			n = indexBatchSize
+		}
+		if err := cs.db.Txn(ctx, ops[:n]); err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
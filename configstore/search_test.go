@@ -0,0 +1,165 @@
+package configstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// bigConfig has more entries than their index keys fit into a Consul
+// transaction.
+func bigConfig(n int) map[string]Value {
+	entries := make(map[string]Value, n)
+	for i := 0; i < n; i++ {
+		entries[fmt.Sprintf("k%d", i)] = Value(fmt.Sprintf(`"v%d"`, i))
+	}
+	return entries
+}
+
+func searchHits(t *testing.T, cs *ConfigStore, q *SearchQuery) []string {
+	t.Helper()
+
+	hits, err := cs.Search(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, hit := range hits {
+		got = append(got, hit.ID+"/"+hit.Version+" "+hit.Key+"="+hit.Value.Text())
+	}
+	return got
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{
+		"db.host": Value(`"db.internal"`),
+		"db.port": Value(`5432`),
+		"port":    Value(`"5432"`),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := config.ID
+
+	tests := []struct {
+		name string
+		q    SearchQuery
+		want []string
+	}{
+		{"exact key", SearchQuery{Key: "port", Match: MatchExact}, []string{id + "/v1 port=5432"}},
+		{"key prefix", SearchQuery{Key: "db.", Match: MatchPrefix}, []string{id + "/v1 db.host=db.internal", id + "/v1 db.port=5432"}},
+		{"value of any type", SearchQuery{Value: "5432", Match: MatchExact}, []string{id + "/v1 db.port=5432", id + "/v1 port=5432"}},
+		{"key and value", SearchQuery{Key: "db.", Value: "db", Match: MatchPrefix}, []string{id + "/v1 db.host=db.internal"}},
+		{"regex", SearchQuery{Key: `^db\.(host|name)$`, Match: MatchRegex}, []string{id + "/v1 db.host=db.internal"}},
+		{"no hit", SearchQuery{Key: "db", Match: MatchExact}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := searchHits(t, cs, &tt.q)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, q := range []*SearchQuery{
+		{Match: MatchExact},
+		{Key: "(", Match: MatchRegex},
+		{Key: "port", Match: "fuzzy"},
+	} {
+		if _, err := cs.Search(ctx, q); !errors.Is(err, ErrInvalidSearch) {
+			t.Errorf("%+v: err = %v, want ErrInvalidSearch", q, err)
+		}
+	}
+}
+
+func TestSearchIndexFollowsWrites(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+	last := &SearchQuery{Key: "k99", Match: MatchExact}
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: bigConfig(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := searchHits(t, cs, last); len(got) != 1 {
+		t.Errorf("after create: got %q, want k99 of v1", got)
+	}
+
+	var archive bytes.Buffer
+	if err := cs.ExportArchive(ctx, &archive, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchHits(t, cs, &SearchQuery{Key: "k", Match: MatchPrefix}); len(got) != 0 {
+		t.Errorf("after delete: got %d hits, want none", len(got))
+	}
+
+	if _, err := cs.RestoreConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchHits(t, cs, last); len(got) != 1 {
+		t.Errorf("after restore: got %q, want k99 of v1", got)
+	}
+
+	// Importing over the version drops the index keys of the entries it
+	// no longer has.
+	if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v2", Entries: bigConfig(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v1", Entries: map[string]Value{"stale": Value(`"x"`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.ImportArchive(ctx, &archive, ConflictOverwrite); err != nil {
+		t.Fatal(err)
+	}
+	if got := searchHits(t, cs, last); len(got) != 1 {
+		t.Errorf("after import: got %q, want k99 of v1", got)
+	}
+	if got := searchHits(t, cs, &SearchQuery{Key: "stale", Match: MatchExact}); len(got) != 0 {
+		t.Errorf("after import: got %q, want the replaced entry gone", got)
+	}
+}
+
+func TestFailedWriteLeavesNoIndex(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: bigConfig(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The index keys that do not fit go first, they are reverted once the
+	// version fails.
+	db := cs.db
+	cs.db = failingTxn{db, constructConfigKey(ctx, config.ID, "v2")}
+	_, err = cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v2", Entries: bigConfig(100)})
+	if !errors.Is(err, errTxnFailed) {
+		t.Fatalf("err = %v, want the failed transaction", err)
+	}
+	if got := searchHits(t, cs, &SearchQuery{Key: "k", Match: MatchPrefix}); len(got) != 1 {
+		t.Errorf("got %q, want only k0 of v1", got)
+	}
+
+	// A failing index fails the write instead of leaving the version
+	// unsearchable.
+	cs.db = failingTxn{db, searchKeys(ctx, config.ID, "v2", "k0", "v0")[0]}
+	if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v2", Entries: bigConfig(1)}); !errors.Is(err, errTxnFailed) {
+		t.Errorf("err = %v, want the failed transaction", err)
+	}
+	if mustGet(t, db, constructConfigKey(ctx, config.ID, "v2")) != nil {
+		t.Errorf("version was written without its index")
+	}
+}
@@ -0,0 +1,429 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// Redacted replaces the value of a secret entry unless it is revealed.
+	Redacted = "******"
+
+	masterKeySize = 32
+)
+
+var (
+	ErrNoMasterKey = errors.New("Secrets are disabled, no master key is configured")
+	ErrUnknownKey  = errors.New("Secret was encrypted with a master key that is not in the keyfile")
+)
+
+// Envelope is one encrypted secret entry. The value is encrypted with its
+// own data key, which is stored encrypted with the master key KeyID. Rotating
+// the master key only re-encrypts DataKey. Both fields are the GCM nonce
+// followed by the ciphertext.
+type Envelope struct {
+	KeyID      string `json:"keyId"`
+	DataKey    []byte `json:"dataKey"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Keyring holds the master keys read from the keyfile. New secrets are
+// encrypted with the primary key, the others are only kept to decrypt
+// secrets until they are rotated.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// LoadKeyring reads a keyfile with one "<key id>:<base64 of 32 bytes>" line
+// per master key. The first key is the primary one, so a key is rotated by
+// adding the new key on top and running RotateSecrets. Empty lines and lines
+// starting with # are skipped.
+func LoadKeyring(path string) (*Keyring, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	kr := &Keyring{keys: make(map[string][]byte)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(text, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected <key id>:<base64 key>", path, line)
+		}
+		id := text[:i]
+		key, err := base64.StdEncoding.DecodeString(text[i+1:])
+		if err != nil || len(key) != masterKeySize {
+			return nil, fmt.Errorf("%s:%d: key %q must be %d bytes in base64", path, line, id, masterKeySize)
+		}
+		if _, ok := kr.keys[id]; ok {
+			return nil, fmt.Errorf("%s:%d: key %q is listed twice", path, line, id)
+		}
+
+		kr.keys[id] = key
+		if kr.primary == "" {
+			kr.primary = id
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if kr.primary == "" {
+		return nil, fmt.Errorf("%s: no master key", path)
+	}
+	return kr, nil
+}
+
+func (kr *Keyring) seal(plaintext, aad []byte) (*Envelope, error) {
+	dataKey := make([]byte, masterKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+
+	ciphertext, err := gcmSeal(dataKey, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := gcmSeal(kr.keys[kr.primary], dataKey, []byte(kr.primary))
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{KeyID: kr.primary, DataKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+func (kr *Keyring) open(env *Envelope, aad []byte) ([]byte, error) {
+	dataKey, err := kr.dataKey(env)
+	if err != nil {
+		return nil, err
+	}
+	return gcmOpen(dataKey, env.Ciphertext, aad)
+}
+
+// rewrap encrypts the data key of env with the primary key. It reports
+// false if that was already the case.
+func (kr *Keyring) rewrap(env *Envelope) (bool, error) {
+	if env.KeyID == kr.primary {
+		return false, nil
+	}
+
+	dataKey, err := kr.dataKey(env)
+	if err != nil {
+		return false, err
+	}
+	wrapped, err := gcmSeal(kr.keys[kr.primary], dataKey, []byte(kr.primary))
+	if err != nil {
+		return false, err
+	}
+	env.KeyID, env.DataKey = kr.primary, wrapped
+	return true, nil
+}
+
+func (kr *Keyring) dataKey(env *Envelope) ([]byte, error) {
+	master, ok := kr.keys[env.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, env.KeyID)
+	}
+	return gcmOpen(master, env.DataKey, []byte(env.KeyID))
+}
+
+func gcmSeal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func gcmOpen(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretAAD binds an encrypted value to its entry and to the namespace and
+// environment of ctx, so it can not be copied into another config, entry or
+// scope and still decrypt.
+func secretAAD(ctx context.Context, id, ver, key string) []byte {
+	return []byte(scopePrefix(ctx) + id + "/" + ver + "/" + key)
+}
+
+// sealConfig returns config as it is stored: every secret entry moved out of
+// Entries into Sealed, encrypted.
+func (cs *ConfigStore) sealConfig(ctx context.Context, config *Config) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "sealConfig")
+	defer span.Finish()
+
+	if len(config.Secrets) == 0 {
+		return config, nil
+	}
+	if cs.keyring == nil {
+		tracer.LogError(span, ErrNoMasterKey)
+		return nil, ErrNoMasterKey
+	}
+
+	stored := *config
+	stored.Entries = make(map[string]Value, len(config.Entries))
+	stored.Sealed = make(map[string]*Envelope, len(config.Secrets))
+	for k, v := range config.Entries {
+		stored.Entries[k] = v
+	}
+
+	secrets := make([]string, 0, len(config.Secrets))
+	for _, k := range config.Secrets {
+		v, ok := config.Entries[k]
+		if !ok {
+			err := fmt.Errorf("Secret %q is not an entry of the config", k)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		if _, done := stored.Sealed[k]; done {
+			continue
+		}
+
+		env, err := cs.keyring.seal(v, secretAAD(ctx, config.ID, config.Version, k))
+		if err != nil {
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		stored.Sealed[k] = env
+		delete(stored.Entries, k)
+		secrets = append(secrets, k)
+	}
+	sort.Strings(secrets)
+	stored.Secrets = secrets
+
+	return &stored, nil
+}
+
+// redactConfig returns a stored config as clients see it by default, with
+// the secret entries present but their values hidden.
+func redactConfig(config *Config) *Config {
+	if len(config.Secrets) == 0 && config.Sealed == nil {
+		return config
+	}
+
+	redacted := *config
+	redacted.Sealed = nil
+	redacted.Entries = make(map[string]Value, len(config.Entries)+len(config.Secrets))
+	for k, v := range config.Entries {
+		redacted.Entries[k] = v
+	}
+	for _, k := range config.Secrets {
+		redacted.Entries[k] = StringValue(Redacted)
+	}
+	return &redacted
+}
+
+// redactValue returns the value of entry k of config as shown to clients.
+func redactValue(config *Config, k string, v Value) Value {
+	if config.IsSecret(k) {
+		return StringValue(Redacted)
+	}
+	return v
+}
+
+// IsSecret reports whether entry k of the config is a secret.
+func (c *Config) IsSecret(k string) bool {
+	for _, secret := range c.Secrets {
+		if secret == k {
+			return true
+		}
+	}
+	return false
+}
+
+// revealConfig returns a stored config with its secret entries decrypted.
+func (cs *ConfigStore) revealConfig(ctx context.Context, config *Config) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "revealConfig")
+	defer span.Finish()
+
+	if len(config.Sealed) == 0 {
+		return redactConfig(config), nil
+	}
+	if cs.keyring == nil {
+		tracer.LogError(span, ErrNoMasterKey)
+		return nil, ErrNoMasterKey
+	}
+
+	revealed := *config
+	revealed.Sealed = nil
+	revealed.Entries = make(map[string]Value, len(config.Entries)+len(config.Sealed))
+	for k, v := range config.Entries {
+		revealed.Entries[k] = v
+	}
+	for k, env := range config.Sealed {
+		plaintext, err := cs.keyring.open(env, secretAAD(ctx, config.ID, config.Version, k))
+		if err != nil {
+			err = fmt.Errorf("decrypting secret %q: %w", k, err)
+			tracer.LogError(span, err)
+			return nil, err
+		}
+		revealed.Entries[k] = Value(plaintext)
+	}
+	return &revealed, nil
+}
+
+// RevealConfig is FindConfig with the secret entries decrypted. Callers
+// must have checked that the client may see secrets.
+func (cs *ConfigStore) RevealConfig(ctx context.Context, id, ver string) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "RevealConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	config, err := cs.findConfig(childCtx, id, ver)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return cs.revealConfig(childCtx, config)
+}
+
+// RotateSecrets re-encrypts the data key of every secret not encrypted with
+// the primary master key, in stored configs and in tombstones of every
+// namespace and environment. Once it reports no failures the old master keys can be dropped
+// from the keyfile.
+func (cs *ConfigStore) RotateSecrets(ctx context.Context) (int, int, error) {
+	span := tracer.StartSpanFromContext(ctx, "RotateSecrets")
+	defer span.Finish()
+
+	if cs.keyring == nil {
+		tracer.LogError(span, ErrNoMasterKey)
+		return 0, 0, ErrNoMasterKey
+	}
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	scopeCtxs, err := cs.scopeContexts(childCtx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, 0, err
+	}
+
+	rotated, failed := 0, 0
+	for _, scopeCtx := range scopeCtxs {
+		configs, err := cs.db.List(ctx, constructConfigRoot(scopeCtx))
+		if err != nil {
+			tracer.LogError(span, err)
+			return rotated, failed, err
+		}
+		tombstones, err := cs.db.List(ctx, constructTombstonePrefix(scopeCtx, ""))
+		if err != nil {
+			tracer.LogError(span, err)
+			return rotated, failed, err
+		}
+
+		for i, pair := range append(configs, tombstones...) {
+			value, changed, err := cs.rewrapPair(pair, i >= len(configs))
+			if err != nil {
+				log.Default().Printf("rotating secrets of %q failed: %v", pair.Key, err)
+				failed++
+				continue
+			}
+			if !changed {
+				continue
+			}
+
+			// The CAS skips keys that were deleted or changed meanwhile, the
+			// next run picks them up again.
+			err = cs.db.Txn(ctx, []*TxnOp{{Verb: TxnCAS, Key: pair.Key, Value: value, Index: pair.ModifyIndex}})
+			if err != nil {
+				log.Default().Printf("rotating secrets of %q failed: %v", pair.Key, err)
+				failed++
+				continue
+			}
+			rotated++
+		}
+	}
+	return rotated, failed, nil
+}
+
+// rewrapPair returns the new value of a config or tombstone key with every
+// envelope in it rewrapped.
+func (cs *ConfigStore) rewrapPair(pair *KVPair, tombstone bool) ([]byte, bool, error) {
+	if tombstone {
+		rec := &tombstoneRecord{}
+		if err := json.Unmarshal(pair.Value, rec); err != nil {
+			return nil, false, err
+		}
+		if rec.Tombstone == nil || rec.Kind != KindConfig {
+			return nil, false, nil
+		}
+
+		changed := false
+		for key, value := range rec.Pairs {
+			v, ok, err := cs.rewrapConfig(value)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				rec.Pairs[key], changed = v, true
+			}
+		}
+		if !changed {
+			return nil, false, nil
+		}
+		data, err := json.Marshal(rec)
+		return data, true, err
+	}
+	return cs.rewrapConfig(pair.Value)
+}
+
+func (cs *ConfigStore) rewrapConfig(value []byte) ([]byte, bool, error) {
+	config := &Config{}
+	if err := json.Unmarshal(value, config); err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for _, env := range config.Sealed {
+		ok, err := cs.keyring.rewrap(env)
+		if err != nil {
+			return nil, false, err
+		}
+		changed = changed || ok
+	}
+	if !changed {
+		return nil, false, nil
+	}
+	data, err := json.Marshal(config)
+	return data, true, err
+}
@@ -0,0 +1,187 @@
+package configstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeyfile writes a keyfile with a master key per id, the first one
+// primary, and returns its path.
+func writeKeyfile(t *testing.T, ids ...string) string {
+	t.Helper()
+
+	var lines []string
+	for _, id := range ids {
+		key := bytes.Repeat([]byte(id[:1]), masterKeySize)
+		lines = append(lines, id+":"+base64.StdEncoding.EncodeToString(key))
+	}
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("# master keys\n"+strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func mustKeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+
+	kr, err := LoadKeyring(writeKeyfile(t, ids...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kr
+}
+
+func newSecretStore(t *testing.T) *ConfigStore {
+	t.Helper()
+
+	cs := newTestStore()
+	cs.keyring = mustKeyring(t, "a")
+	return cs
+}
+
+func createSecret(t *testing.T, cs *ConfigStore, ctx context.Context) *Config {
+	t.Helper()
+
+	config, err := cs.CreateConfig(ctx, &Config{
+		Version: "v1",
+		Entries: map[string]Value{"user": Value(`"admin"`), "password": Value(`"hunter2"`)},
+		Secrets: []string{"password"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestSealAndReveal(t *testing.T) {
+	ctx := context.Background()
+	cs := newSecretStore(t)
+
+	config := createSecret(t, cs, ctx)
+	if got := config.Entries["password"].Text(); got != Redacted {
+		t.Errorf("created password = %q, want it redacted", got)
+	}
+
+	stored := mustGet(t, cs.db, constructConfigKey(ctx, config.ID, "v1"))
+	if strings.Contains(string(stored.Value), "hunter2") {
+		t.Errorf("password is stored in plain text: %s", stored.Value)
+	}
+
+	found, err := cs.FindConfig(ctx, config.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Entries["password"].Text() != Redacted || found.Entries["user"].Text() != "admin" || found.Sealed != nil {
+		t.Errorf("found %+v, want only the password redacted", found)
+	}
+
+	revealed, err := cs.RevealConfig(ctx, config.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revealed.Entries["password"].Text() != "hunter2" || revealed.Entries["user"].Text() != "admin" {
+		t.Errorf("revealed %v", revealed.Entries)
+	}
+
+	if _, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"a": Value(`1`)}, Secrets: []string{"b"}}); err == nil {
+		t.Errorf("secret that is not an entry was accepted")
+	}
+	cs.keyring = nil
+	if _, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"a": Value(`1`)}, Secrets: []string{"a"}}); !errors.Is(err, ErrNoMasterKey) {
+		t.Errorf("without a master key: err = %v, want ErrNoMasterKey", err)
+	}
+}
+
+func TestSealedValueIsBound(t *testing.T) {
+	ctx := context.Background()
+	cs := newSecretStore(t)
+
+	config := createSecret(t, cs, ctx)
+	stored := mustGet(t, cs.db, constructConfigKey(ctx, config.ID, "v1"))
+
+	// copied is the stored config version changed by edit.
+	copied := func(edit func(*Config)) []byte {
+		config := &Config{}
+		if err := json.Unmarshal(stored.Value, config); err != nil {
+			t.Fatal(err)
+		}
+		edit(config)
+		data, err := json.Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		id    string
+		value []byte
+	}{
+		{"other config", ctx, "copy", copied(func(c *Config) { c.ID = "copy" })},
+		{"other version", ctx, "version", copied(func(c *Config) { c.Version = "v2" })},
+		{"other entry", ctx, "entry", copied(func(c *Config) {
+			c.Sealed["user"] = c.Sealed["password"]
+			delete(c.Sealed, "password")
+			c.Secrets = []string{"user"}
+		})},
+		{"other namespace", WithNamespace(ctx, "other"), config.ID, stored.Value},
+		{"other environment", WithEnvironment(ctx, "prod"), config.ID, stored.Value},
+	}
+	for _, tt := range tests {
+		if err := cs.db.Put(tt.ctx, &KVPair{Key: constructConfigKey(tt.ctx, tt.id, "v1"), Value: tt.value}); err != nil {
+			t.Fatal(err)
+		}
+		if revealed, err := cs.RevealConfig(tt.ctx, tt.id, "v1"); err == nil {
+			t.Errorf("%s: copied secret decrypted to %v", tt.name, revealed.Entries)
+		}
+	}
+}
+
+func TestRotateSecrets(t *testing.T) {
+	ctx := context.Background()
+	cs := newSecretStore(t)
+
+	config := createSecret(t, cs, ctx)
+	deleted := createSecret(t, cs, ctx)
+	if _, err := cs.DeleteConfig(ctx, deleted.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+
+	cs.keyring = mustKeyring(t, "b", "a")
+	rotated, failed, err := cs.RotateSecrets(ctx)
+	if err != nil || rotated != 2 || failed != 0 {
+		t.Fatalf("rotated %d, failed %d, %v, want the config and the tombstone", rotated, failed, err)
+	}
+	if rotated, _, _ := cs.RotateSecrets(ctx); rotated != 0 {
+		t.Errorf("second rotation rotated %d, want none", rotated)
+	}
+
+	// The old key can be dropped now.
+	cs.keyring = mustKeyring(t, "b")
+	revealed, err := cs.RevealConfig(ctx, config.ID, "v1")
+	if err != nil || revealed.Entries["password"].Text() != "hunter2" {
+		t.Errorf("after rotation: %v, %v", revealed, err)
+	}
+	if _, err := cs.RestoreConfig(ctx, deleted.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	revealed, err = cs.RevealConfig(ctx, deleted.ID, "v1")
+	if err != nil || revealed.Entries["password"].Text() != "hunter2" {
+		t.Errorf("restored after rotation: %v, %v", revealed, err)
+	}
+
+	cs.keyring = mustKeyring(t, "c")
+	if _, err := cs.RevealConfig(ctx, config.ID, "v1"); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("with an unknown key: err = %v, want ErrUnknownKey", err)
+	}
+}
@@ -0,0 +1,316 @@
+package configstore
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Operator string
+
+const (
+	OpEquals    Operator = "="
+	OpNotEquals Operator = "!="
+	OpIn        Operator = "in"
+	OpNotIn     Operator = "notin"
+	OpExists    Operator = "exists"
+	OpNotExists Operator = "!"
+)
+
+// Requirement is a single condition on one label, e.g. region in (eu,us).
+type Requirement struct {
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector is a parsed label selector. A config matches when it satisfies
+// every requirement; an empty selector matches everything.
+//
+// The syntax follows Kubernetes label selectors, requirements are separated
+// by commas:
+//
+//	env=prod  env==prod  tier!=cache  region in (eu,us)  region notin (eu)
+//	owner  has:owner  !deprecated
+type Selector struct {
+	Requirements []Requirement
+}
+
+// SelectorError reports where parsing a selector failed. Position is the
+// 1-based byte offset into the selector.
+type SelectorError struct {
+	Position int
+	Message  string
+}
+
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("selector: position %d: %s", e.Position, e.Message)
+}
+
+func ParseSelector(input string) (*Selector, error) {
+	p := &selectorParser{lexer: &selectorLexer{input: input}}
+	p.next()
+
+	sel := &Selector{}
+	if p.tok.kind == tokEOF {
+		return sel, nil
+	}
+
+	for {
+		req, err := p.requirement()
+		if err != nil {
+			return nil, err
+		}
+		sel.Requirements = append(sel.Requirements, *req)
+
+		switch p.tok.kind {
+		case tokEOF:
+			return sel, nil
+		case tokComma:
+			p.next()
+		default:
+			return nil, p.errorf("expected \",\" or end of selector, found %s", p.tok)
+		}
+	}
+}
+
+// Matches reports whether labels satisfy every requirement.
+func (s *Selector) Matches(labels map[string]string) bool {
+	for _, req := range s.Requirements {
+		if !req.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case OpEquals:
+		return ok && value == r.Values[0]
+	case OpNotEquals:
+		return !ok || value != r.Values[0]
+	case OpIn:
+		return ok && contains(r.Values, value)
+	case OpNotIn:
+		return !ok || !contains(r.Values, value)
+	case OpExists:
+		return ok
+	case OpNotExists:
+		return !ok
+	}
+	return false
+}
+
+// equalities returns the k=v requirements of the selector, which can be
+// answered by the label index. It reports false if two of them ask for
+// different values of the same key, so nothing can match.
+func (s *Selector) equalities() (map[string]string, bool) {
+	labels := make(map[string]string)
+	for _, req := range s.Requirements {
+		if req.Operator != OpEquals {
+			continue
+		}
+		if v, ok := labels[req.Key]; ok && v != req.Values[0] {
+			return nil, false
+		}
+		labels[req.Key] = req.Values[0]
+	}
+	return labels, true
+}
+
+// contains reports whether value is one of values.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokEquals
+	tokNotEquals
+	tokBang
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of selector"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+type selectorLexer struct {
+	input string
+	pos   int
+}
+
+func (l *selectorLexer) next() token {
+	for l.pos < len(l.input) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t') {
+		l.pos++
+	}
+
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokEOF, pos: start}
+	}
+
+	switch c := l.input[l.pos]; {
+	case c == ',':
+		l.pos++
+		return token{kind: tokComma, text: ",", pos: start}
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}
+	case c == '=':
+		l.pos++
+		if l.pos < len(l.input) && l.input[l.pos] == '=' {
+			l.pos++
+		}
+		return token{kind: tokEquals, text: l.input[start:l.pos], pos: start}
+	case c == '!':
+		l.pos++
+		if l.pos < len(l.input) && l.input[l.pos] == '=' {
+			l.pos++
+			return token{kind: tokNotEquals, text: "!=", pos: start}
+		}
+		return token{kind: tokBang, text: "!", pos: start}
+	}
+
+	for l.pos < len(l.input) && !strings.ContainsRune(" \t,()=!", rune(l.input[l.pos])) {
+		l.pos++
+	}
+	return token{kind: tokIdent, text: l.input[start:l.pos], pos: start}
+}
+
+type selectorParser struct {
+	lexer *selectorLexer
+	tok   token
+}
+
+func (p *selectorParser) next() {
+	p.tok = p.lexer.next()
+}
+
+func (p *selectorParser) errorf(format string, args ...interface{}) error {
+	return &SelectorError{Position: p.tok.pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *selectorParser) requirement() (*Requirement, error) {
+	if p.tok.kind == tokBang {
+		p.next()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		return &Requirement{Key: key, Operator: OpNotExists}, nil
+	}
+
+	if p.tok.kind == tokIdent && strings.HasPrefix(p.tok.text, "has:") {
+		key := strings.TrimPrefix(p.tok.text, "has:")
+		if key == "" {
+			return nil, p.errorf("expected label key after \"has:\"")
+		}
+		p.next()
+		return &Requirement{Key: key, Operator: OpExists}, nil
+	}
+
+	key, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case p.tok.kind == tokEquals || p.tok.kind == tokNotEquals:
+		op := OpEquals
+		if p.tok.kind == tokNotEquals {
+			op = OpNotEquals
+		}
+		p.next()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return &Requirement{Key: key, Operator: op, Values: []string{value}}, nil
+	case p.tok.kind == tokIdent && (p.tok.text == "in" || p.tok.text == "notin"):
+		op := OpIn
+		if p.tok.text == "notin" {
+			op = OpNotIn
+		}
+		p.next()
+		values, err := p.values()
+		if err != nil {
+			return nil, err
+		}
+		return &Requirement{Key: key, Operator: op, Values: values}, nil
+	case p.tok.kind == tokEOF || p.tok.kind == tokComma:
+		return &Requirement{Key: key, Operator: OpExists}, nil
+	}
+	return nil, p.errorf("expected operator after label %q, found %s", key, p.tok)
+}
+
+func (p *selectorParser) key() (string, error) {
+	if p.tok.kind != tokIdent {
+		return "", p.errorf("expected label key, found %s", p.tok)
+	}
+	key := p.tok.text
+	p.next()
+	return key, nil
+}
+
+// value reads the right hand side of = and !=, which may be empty.
+func (p *selectorParser) value() (string, error) {
+	switch p.tok.kind {
+	case tokIdent:
+		value := p.tok.text
+		p.next()
+		return value, nil
+	case tokComma, tokEOF:
+		return "", nil
+	}
+	return "", p.errorf("expected label value, found %s", p.tok)
+}
+
+func (p *selectorParser) values() ([]string, error) {
+	if p.tok.kind != tokLParen {
+		return nil, p.errorf("expected \"(\", found %s", p.tok)
+	}
+	p.next()
+
+	var values []string
+	for {
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf("expected label value, found %s", p.tok)
+		}
+		values = append(values, p.tok.text)
+		p.next()
+
+		switch p.tok.kind {
+		case tokRParen:
+			p.next()
+			return values, nil
+		case tokComma:
+			p.next()
+		default:
+			return nil, p.errorf("expected \",\" or \")\", found %s", p.tok)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package configstore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []Requirement
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"env=prod", []Requirement{{Key: "env", Operator: OpEquals, Values: []string{"prod"}}}},
+		{"env==prod", []Requirement{{Key: "env", Operator: OpEquals, Values: []string{"prod"}}}},
+		{"env=", []Requirement{{Key: "env", Operator: OpEquals, Values: []string{""}}}},
+		{"tier != cache", []Requirement{{Key: "tier", Operator: OpNotEquals, Values: []string{"cache"}}}},
+		{"region in (eu, us)", []Requirement{{Key: "region", Operator: OpIn, Values: []string{"eu", "us"}}}},
+		{"region notin (eu)", []Requirement{{Key: "region", Operator: OpNotIn, Values: []string{"eu"}}}},
+		{"owner", []Requirement{{Key: "owner", Operator: OpExists}}},
+		{"has:owner", []Requirement{{Key: "owner", Operator: OpExists}}},
+		{"!deprecated", []Requirement{{Key: "deprecated", Operator: OpNotExists}}},
+		{"env=prod,owner,!old", []Requirement{
+			{Key: "env", Operator: OpEquals, Values: []string{"prod"}},
+			{Key: "owner", Operator: OpExists},
+			{Key: "old", Operator: OpNotExists},
+		}},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.in)
+		if err != nil {
+			t.Errorf("ParseSelector(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(sel.Requirements, tt.want) {
+			t.Errorf("ParseSelector(%q) = %+v, want %+v", tt.in, sel.Requirements, tt.want)
+		}
+	}
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+	tests := []struct {
+		in       string
+		position int
+	}{
+		{"=prod", 1},
+		{",env", 1},
+		{"env=prod,", 10},
+		{"env prod", 5},
+		{"env=(prod)", 5},
+		{"region in eu", 11},
+		{"region in ()", 12},
+		{"region in (eu us)", 15},
+		{"region in (eu", 14},
+		{"has:", 1},
+		{"!=x", 1},
+		{"!", 2},
+	}
+	for _, tt := range tests {
+		_, err := ParseSelector(tt.in)
+		var selErr *SelectorError
+		if !errors.As(err, &selErr) {
+			t.Errorf("ParseSelector(%q) = %v, want a SelectorError", tt.in, err)
+			continue
+		}
+		if selErr.Position != tt.position {
+			t.Errorf("ParseSelector(%q) failed at %d, want %d: %v", tt.in, selErr.Position, tt.position, err)
+		}
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "tier": "web", "owner": ""}
+
+	tests := []struct {
+		sel  string
+		want bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"owner=", true},
+		{"tier!=db", true},
+		{"tier!=web", false},
+		{"zone!=eu", true},
+		{"env in (dev,prod)", true},
+		{"env in (dev)", false},
+		{"zone in (eu)", false},
+		{"env notin (dev)", true},
+		{"env notin (prod)", false},
+		{"zone notin (eu)", true},
+		{"owner", true},
+		{"zone", false},
+		{"!zone", true},
+		{"!env", false},
+		{"env=prod,tier=web", true},
+		{"env=prod,tier=db", false},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sel.Matches(labels); got != tt.want {
+			t.Errorf("%q matches %v = %v, want %v", tt.sel, labels, got, tt.want)
+		}
+	}
+}
+
+func TestSelectorEqualities(t *testing.T) {
+	tests := []struct {
+		sel  string
+		want map[string]string
+		ok   bool
+	}{
+		{"", map[string]string{}, true},
+		{"env=prod,tier!=db,zone", map[string]string{"env": "prod"}, true},
+		{"env=prod,env==prod", map[string]string{"env": "prod"}, true},
+		{"env=prod,env=dev", nil, false},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := sel.equalities()
+		if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q equalities = %v, %v, want %v, %v", tt.sel, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package configstore
+
+import (
+	"ARS_Projekat/tracer"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	KindConfig = "config"
+	KindGroup  = "group"
+
+	defaultRetention = 7 * 24 * time.Hour
+	purgeInterval    = time.Minute
+)
+
+// Tombstone describes a deleted config or group version. It can be restored
+// until PurgeAt, after which the purger removes it for good.
+type Tombstone struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	Version   string    `json:"version"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
+
+// tombstoneRecord is what is stored under the tombstone key: the document
+// of the version when it was deleted. Keys derived from it, such as the
+// label document of a group, are rebuilt when it is restored.
+type tombstoneRecord struct {
+	*Tombstone
+	Pairs map[string][]byte `json:"pairs"`
+}
+
+func (t *Tombstone) expired(now time.Time) bool {
+	return !now.Before(t.PurgeAt)
+}
+
+// tombstoneOp returns the op moving pairs, the keys of one version, into its
+// tombstone. The caller deletes the keys in the same transaction.
+func (cs *ConfigStore) tombstoneOp(ctx context.Context, kind, id, ver string, pairs []*KVPair) (*TxnOp, error) {
+	span := tracer.StartSpanFromContext(ctx, "tombstoneOp")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	now := time.Now().UTC()
+	rec := &tombstoneRecord{
+		Tombstone: &Tombstone{
+			Kind:      kind,
+			ID:        id,
+			Version:   ver,
+			DeletedAt: now,
+			PurgeAt:   now.Add(cs.retention),
+		},
+		Pairs: make(map[string][]byte, len(pairs)),
+	}
+	for _, pair := range pairs {
+		rec.Pairs[pair.Key] = pair.Value
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	// A version that was deleted, created again and deleted again keeps
+	// only its last tombstone.
+	key := constructTombstoneKey(childCtx, kind, id, ver)
+	return &TxnOp{Verb: TxnSet, Key: key, Value: data}, nil
+}
+
+func (cs *ConfigStore) RestoreConfig(ctx context.Context, id, ver string) (*Config, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreConfig")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	config := &Config{}
+	index := func(data []byte) ([]*TxnOp, []*TxnOp, error) {
+		if err := json.Unmarshal(data, config); err != nil {
+			return nil, nil, err
+		}
+		return indexOps(childCtx, nil, config)
+	}
+
+	if err := cs.restore(childCtx, KindConfig, id, ver, constructConfigKey(childCtx, id, ver), index); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return redactConfig(config), nil
+}
+
+func (cs *ConfigStore) RestoreGroup(ctx context.Context, id, ver string) (*Group, error) {
+	span := tracer.StartSpanFromContext(ctx, "RestoreGroup")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	group := &Group{}
+	labels := func(data []byte) ([]*TxnOp, []*TxnOp, error) {
+		if err := json.Unmarshal(data, group); err != nil {
+			return nil, nil, err
+		}
+		ops, err := labelOps(childCtx, group, id, ver)
+		return ops, nil, err
+	}
+
+	if err := cs.restore(childCtx, KindGroup, id, ver, constructGroupKey(childCtx, id, ver), labels); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return group, nil
+}
+
+// restore writes back key as saved in a tombstone, together with the ops
+// derived returns for its value, and removes the tombstone. Like txnIndexed
+// derived also returns the ops reverting its ops, when those might not fit
+// into the transaction. It fails with ErrConflict if the version was created
+// again since it was deleted, and with ErrNotFound once retention expired.
+func (cs *ConfigStore) restore(ctx context.Context, kind, id, ver, key string, derived func([]byte) ([]*TxnOp, []*TxnOp, error)) error {
+	span := tracer.StartSpanFromContext(ctx, "restore")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	tkey := constructTombstoneKey(childCtx, kind, id, ver)
+
+	getSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base get")
+	pair, err := cs.db.Get(ctx, tkey)
+	if err != nil {
+		tracer.LogError(getSpan, err)
+		return err
+	}
+	getSpan.Finish()
+	if pair == nil {
+		return ErrNotFound
+	}
+
+	rec := &tombstoneRecord{}
+	if err := json.Unmarshal(pair.Value, rec); err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+	if rec.Tombstone == nil || rec.expired(time.Now()) {
+		return ErrNotFound
+	}
+
+	data, ok := rec.Pairs[key]
+	if !ok {
+		err := fmt.Errorf("tombstone %s does not hold %s", tkey, key)
+		tracer.LogError(span, err)
+		return err
+	}
+
+	// Tombstones written before the label document existed also hold the
+	// label keys of a group, those are rebuilt like any other derived key.
+	ops := []*TxnOp{
+		{Verb: TxnDeleteCAS, Key: tkey, Index: pair.ModifyIndex},
+		{Verb: TxnCAS, Key: key, Value: data},
+	}
+	extra, undo, err := derived(data)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	txnSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base txn")
+	err = cs.txnIndexed(ctx, ops, extra, undo)
+	if err != nil {
+		tracer.LogError(txnSpan, err)
+		return err
+	}
+	txnSpan.Finish()
+
+	return nil
+}
+
+// FindTombstones lists the deleted versions that can still be restored,
+// oldest deletion first. An empty kind lists both configs and groups.
+func (cs *ConfigStore) FindTombstones(ctx context.Context, kind string) ([]*Tombstone, error) {
+	span := tracer.StartSpanFromContext(ctx, "FindTombstones")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	if kind != "" && kind != KindConfig && kind != KindGroup {
+		err := fmt.Errorf("Unknown kind %q, expected %s or %s", kind, KindConfig, KindGroup)
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	records, err := cs.listTombstones(childCtx, kind)
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	now := time.Now()
+	tombstones := []*Tombstone{}
+	for _, rec := range records {
+		if !rec.expired(now) {
+			tombstones = append(tombstones, rec.Tombstone)
+		}
+	}
+
+	sort.SliceStable(tombstones, func(i, j int) bool {
+		return tombstones[i].DeletedAt.Before(tombstones[j].DeletedAt)
+	})
+	return tombstones, nil
+}
+
+type storedTombstone struct {
+	*tombstoneRecord
+	key   string
+	index uint64
+}
+
+func (cs *ConfigStore) listTombstones(ctx context.Context, kind string) ([]*storedTombstone, error) {
+	span := tracer.StartSpanFromContext(ctx, "listTombstones")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	listSpan := tracer.StartSpanFromContext(tracer.ContextWithSpan(ctx, span), "Base list")
+	data, err := cs.db.List(ctx, constructTombstonePrefix(childCtx, kind))
+	if err != nil {
+		tracer.LogError(listSpan, err)
+		return nil, err
+	}
+	listSpan.Finish()
+
+	var records []*storedTombstone
+	for _, pair := range data {
+		rec := &tombstoneRecord{}
+		if err := json.Unmarshal(pair.Value, rec); err != nil || rec.Tombstone == nil {
+			log.Default().Printf("skipping malformed tombstone %q", pair.Key)
+			continue
+		}
+		records = append(records, &storedTombstone{tombstoneRecord: rec, key: pair.Key, index: pair.ModifyIndex})
+	}
+	return records, nil
+}
+
+// PurgeTombstones removes every tombstone whose retention expired, in every
+// namespace and environment, and returns how many were removed.
+func (cs *ConfigStore) PurgeTombstones(ctx context.Context) (int, error) {
+	span := tracer.StartSpanFromContext(ctx, "PurgeTombstones")
+	defer span.Finish()
+
+	childCtx := tracer.ContextWithSpan(ctx, span)
+
+	scopeCtxs, err := cs.scopeContexts(childCtx)
+	if err != nil {
+		tracer.LogError(span, err)
+		return 0, err
+	}
+
+	var records []*storedTombstone
+	for _, scopeCtx := range scopeCtxs {
+		recs, err := cs.listTombstones(scopeCtx, "")
+		if err != nil {
+			tracer.LogError(span, err)
+			return 0, err
+		}
+		records = append(records, recs...)
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, rec := range records {
+		if !rec.expired(now) {
+			continue
+		}
+
+		// The CAS leaves a tombstone alone if the version was deleted
+		// again in the meantime.
+		err := cs.db.Txn(ctx, []*TxnOp{{Verb: TxnDeleteCAS, Key: rec.key, Index: rec.index}})
+		if errors.Is(err, ErrConflict) {
+			continue
+		}
+		if err != nil {
+			tracer.LogError(span, err)
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunPurger purges expired tombstones periodically until ctx is done.
+func (cs *ConfigStore) RunPurger(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := cs.PurgeTombstones(ctx)
+		if err != nil {
+			log.Default().Printf("purging tombstones failed: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Default().Printf("purged %d tombstones", n)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// beforeTxn is a backend calling hook before the next transaction.
+type beforeTxn struct {
+	Backend
+	hook *func()
+}
+
+func (b beforeTxn) Txn(ctx context.Context, ops []*TxnOp) error {
+	if hook := *b.hook; hook != nil {
+		*b.hook = nil
+		hook()
+	}
+	return b.Backend.Txn(ctx, ops)
+}
+
+func tombstoneVersions(t *testing.T, cs *ConfigStore, kind string) []string {
+	t.Helper()
+
+	tombstones, err := cs.FindTombstones(context.Background(), kind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, tombstone := range tombstones {
+		versions = append(versions, tombstone.Kind+"/"+tombstone.ID+"/"+tombstone.Version)
+	}
+	return versions
+}
+
+func TestDeleteAndRestoreConfig(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.FindConfig(ctx, config.ID, "v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted version: err = %v, want ErrNotFound", err)
+	}
+
+	if got := tombstoneVersions(t, cs, ""); len(got) != 1 || got[0] != "config/"+config.ID+"/v1" {
+		t.Errorf("tombstones = %q, want the deleted version", got)
+	}
+	if got := tombstoneVersions(t, cs, KindGroup); len(got) != 0 {
+		t.Errorf("group tombstones = %q, want none", got)
+	}
+	if _, err := cs.FindTombstones(ctx, "schema"); err == nil {
+		t.Errorf("unknown kind was accepted")
+	}
+
+	restored, err := cs.RestoreConfig(ctx, config.ID, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Entries["k"].Text() != "v" {
+		t.Errorf("restored entries = %v", restored.Entries)
+	}
+	if _, err := cs.FindConfig(ctx, config.ID, "v1"); err != nil {
+		t.Errorf("restored version: %v", err)
+	}
+	if got := tombstoneVersions(t, cs, ""); len(got) != 0 {
+		t.Errorf("tombstones = %q after the restore, want none", got)
+	}
+	if _, err := cs.RestoreConfig(ctx, config.ID, "v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second restore: err = %v, want ErrNotFound", err)
+	}
+
+	// A version created again after its delete is not overwritten.
+	if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v1", Entries: map[string]Value{"k": Value(`"new"`)}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.RestoreConfig(ctx, config.ID, "v1"); !errors.Is(err, ErrConflict) {
+		t.Errorf("restore over a new version: err = %v, want ErrConflict", err)
+	}
+}
+
+func TestDeleteAndRestoreGroup(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	group, err := cs.CreateGroup(ctx, &Group{Version: "v1", Configs: []map[string]string{{"env": "prod"}, {"env": "dev"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.DeleteGroup(ctx, group.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if mustGet(t, cs.db, constructGroupLabelsKey(ctx, group.ID, "v1")) != nil {
+		t.Errorf("label document outlived its group")
+	}
+	if got := tombstoneVersions(t, cs, KindGroup); len(got) != 1 || got[0] != "group/"+group.ID+"/v1" {
+		t.Errorf("tombstones = %q, want the deleted version", got)
+	}
+
+	if _, err := cs.RestoreGroup(ctx, group.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	checkLabelDoc(t, cs, group.ID, "v1")
+}
+
+func TestTombstoneRetention(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+	cs.retention = time.Millisecond
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if got := tombstoneVersions(t, cs, ""); len(got) != 0 {
+		t.Errorf("tombstones = %q past the retention, want none", got)
+	}
+	if _, err := cs.RestoreConfig(ctx, config.ID, "v1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("restore past the retention: err = %v, want ErrNotFound", err)
+	}
+
+	purged, err := cs.PurgeTombstones(ctx)
+	if err != nil || purged != 1 {
+		t.Errorf("purged %d, %v, want 1", purged, err)
+	}
+	if mustGet(t, cs.db, constructTombstoneKey(ctx, KindConfig, config.ID, "v1")) != nil {
+		t.Errorf("expired tombstone was not purged")
+	}
+}
+
+func TestPurgeSkipsRewrittenTombstone(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+	cs.retention = time.Millisecond
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// The version is created and deleted again between the purger listing
+	// the tombstone and removing it.
+	key := constructTombstoneKey(ctx, KindConfig, config.ID, "v1")
+	db := cs.db
+	hook := func() {
+		cs.retention = time.Hour
+		if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: "v1", Entries: map[string]Value{"k": Value(`"again"`)}}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cs.DeleteConfig(ctx, config.ID, "v1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cs.db = beforeTxn{db, &hook}
+
+	purged, err := cs.PurgeTombstones(ctx)
+	if err != nil || purged != 0 {
+		t.Errorf("purged %d, %v, want the rewritten tombstone left alone", purged, err)
+	}
+	if mustGet(t, db, key) == nil {
+		t.Fatalf("rewritten tombstone was purged")
+	}
+	restored, err := cs.RestoreConfig(ctx, config.ID, "v1")
+	if err != nil || restored.Entries["k"].Text() != "again" {
+		t.Errorf("restored %+v, %v, want the version deleted last", restored, err)
+	}
+}
@@ -0,0 +1,124 @@
+package configstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidPointer = errors.New("Invalid JSON pointer")
+
+// Value is the value of a config entry, any JSON value. Entries used to be
+// strings only, which are still accepted and stored as JSON strings.
+type Value json.RawMessage
+
+// StringValue returns s as a JSON string value.
+func StringValue(s string) Value {
+	data, _ := json.Marshal(s)
+	return Value(data)
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	if len(v) == 0 {
+		return []byte("null"), nil
+	}
+	return v, nil
+}
+
+// UnmarshalJSON keeps the value as sent, without insignificant whitespace,
+// so numbers keep their exact form.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return err
+	}
+	*v = buf.Bytes()
+	return nil
+}
+
+// Text is the value as plain text: the contents of a string, the JSON of
+// anything else.
+func (v Value) Text() string {
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s
+	}
+	return string(v)
+}
+
+// Equal reports whether both values are the same JSON value, regardless of
+// the order of object keys.
+func (v Value) Equal(w Value) bool {
+	if bytes.Equal(v, w) {
+		return true
+	}
+	a, errA := decodeValue(v)
+	b, errB := decodeValue(w)
+	return errA == nil && errB == nil && reflect.DeepEqual(a, b)
+}
+
+func decodeValue(v Value) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(v))
+	dec.UseNumber()
+
+	var out interface{}
+	err := dec.Decode(&out)
+	return out, err
+}
+
+// Lookup returns the value the JSON pointer (RFC 6901) addresses in the
+// entries of the config, e.g. /db/hosts/0. The empty pointer addresses all
+// entries.
+func (c *Config) Lookup(pointer string) (Value, error) {
+	if pointer == "" {
+		data, err := json.Marshal(c.Entries)
+		return Value(data), err
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: %q must start with \"/\"", ErrInvalidPointer, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = unescapePointer(token)
+	}
+
+	entry, ok := c.Entries[tokens[0]]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if len(tokens) == 1 {
+		return entry, nil
+	}
+
+	current, err := decodeValue(entry)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens[1:] {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			if current, ok = node[token]; !ok {
+				return nil, ErrNotFound
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || strconv.Itoa(i) != token {
+				return nil, fmt.Errorf("%w: %q is not an array index", ErrInvalidPointer, token)
+			}
+			if i >= len(node) {
+				return nil, ErrNotFound
+			}
+			current = node[i]
+		default:
+			return nil, ErrNotFound
+		}
+	}
+
+	data, err := json.Marshal(current)
+	return Value(data), err
+}
@@ -0,0 +1,60 @@
+package configstore
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	config := &Config{Entries: map[string]Value{
+		"db":    Value(`{"hosts": ["a", {"name": "b"}], "a/b": 1, "m~n": 2, "": 3}`),
+		"a/b":   Value(`"slash"`),
+		"m~n":   Value(`"tilde"`),
+		"~1":    Value(`"escaped tilde"`),
+		"port":  Value(`5432`),
+		"empty": Value(`[]`),
+	}}
+
+	tests := []struct {
+		pointer string
+		want    string
+		err     error
+	}{
+		{"/port", `5432`, nil},
+		{"/db/hosts/0", `"a"`, nil},
+		{"/db/hosts/1/name", `"b"`, nil},
+		{"/db/", `3`, nil},
+		{"/a~1b", `"slash"`, nil},
+		{"/m~0n", `"tilde"`, nil},
+		{"/db/a~1b", `1`, nil},
+		{"/db/m~0n", `2`, nil},
+		// ~01 is ~1, not /.
+		{"/~01", `"escaped tilde"`, nil},
+		{"/missing", "", ErrNotFound},
+		{"/db/hosts/2", "", ErrNotFound},
+		{"/empty/0", "", ErrNotFound},
+		{"/port/0", "", ErrNotFound},
+		{"/db/missing", "", ErrNotFound},
+		{"port", "", ErrInvalidPointer},
+		{"/db/hosts/01", "", ErrInvalidPointer},
+		{"/db/hosts/-", "", ErrInvalidPointer},
+		{"/db/hosts/x", "", ErrInvalidPointer},
+	}
+	for _, tt := range tests {
+		got, err := config.Lookup(tt.pointer)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Lookup(%q) = %s, %v, want %v", tt.pointer, got, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Lookup(%q) = %s, %v, want %s", tt.pointer, got, err, tt.want)
+		}
+	}
+
+	all, err := config.Lookup("")
+	if err != nil || !all.Equal(Value(`{"db": {"hosts": ["a", {"name": "b"}], "a/b": 1, "m~n": 2, "": 3}, "a/b": "slash", "m~n": "tilde", "~1": "escaped tilde", "port": 5432, "empty": []}`)) {
+		t.Errorf("Lookup(\"\") = %s, %v, want every entry", all, err)
+	}
+}
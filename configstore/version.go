@@ -0,0 +1,218 @@
+package configstore
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidVersion = errors.New("Invalid version")
+
+// reservedVersions can not be used as version names because they are
+// aliases or routes in the {ver} position.
+var reservedVersions = []string{LatestVersion, "diff", "schema", "promotions"}
+
+type semver struct {
+	major, minor, patch uint64
+	pre                 []string
+}
+
+// parseSemver accepts MAJOR.MINOR.PATCH[-PRERELEASE][+BUILD] with an
+// optional leading "v", as in v1.2.3.
+func parseSemver(version string) (*semver, bool) {
+	v := strings.TrimPrefix(version, "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		if !validIdentifiers(v[i+1:], false) {
+			return nil, false
+		}
+		v = v[:i]
+	}
+
+	var pre []string
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		if !validIdentifiers(v[i+1:], true) {
+			return nil, false
+		}
+		pre = strings.Split(v[i+1:], ".")
+		v = v[:i]
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+
+	nums := make([]uint64, 3)
+	for i, part := range parts {
+		if !isNumeric(part) || (len(part) > 1 && part[0] == '0') {
+			return nil, false
+		}
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		nums[i] = n
+	}
+
+	return &semver{major: nums[0], minor: nums[1], patch: nums[2], pre: pre}, true
+}
+
+func validIdentifiers(s string, pre bool) bool {
+	for _, id := range strings.Split(s, ".") {
+		if id == "" {
+			return false
+		}
+		for _, c := range id {
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '-') {
+				return false
+			}
+		}
+		if pre && isNumeric(id) && len(id) > 1 && id[0] == '0' {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// compare follows semver precedence: a version with a pre-release is lower
+// than the same version without one.
+func (a *semver) compare(b *semver) int {
+	for _, d := range [][2]uint64{{a.major, b.major}, {a.minor, b.minor}, {a.patch, b.patch}} {
+		if d[0] != d[1] {
+			if d[0] < d[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		x, y := a.pre[i], b.pre[i]
+		if x == y {
+			continue
+		}
+		xn, yn := isNumeric(x), isNumeric(y)
+		switch {
+		case xn && yn:
+			return compareNumeric(x, y)
+		case xn:
+			return -1
+		case yn:
+			return 1
+		}
+		return strings.Compare(x, y)
+	}
+	return compareInts(len(a.pre), len(b.pre))
+}
+
+// compareVersions orders two versions. Semantic versions are compared by
+// precedence, anything else in natural order so that v2 sorts before v10.
+// Only equal names compare equal, page tokens rely on it.
+func compareVersions(a, b string) int {
+	sa, okA := parseSemver(a)
+	sb, okB := parseSemver(b)
+	if okA && okB {
+		if c := sa.compare(sb); c != 0 {
+			return c
+		}
+	} else if c := compareNatural(a, b); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// compareNatural compares runs of digits by their numeric value and
+// everything else byte by byte.
+func compareNatural(a, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			x, restA := splitDigits(a)
+			y, restB := splitDigits(b)
+			if c := compareNumeric(x, y); c != 0 {
+				return c
+			}
+			a, b = restA, restB
+			continue
+		}
+		if a[0] != b[0] {
+			if a[0] < b[0] {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[1:], b[1:]
+	}
+	return compareInts(len(a), len(b))
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// compareNumeric compares two digit strings of any length by value.
+func compareNumeric(x, y string) int {
+	x = strings.TrimLeft(x, "0")
+	y = strings.TrimLeft(y, "0")
+	if len(x) != len(y) {
+		return compareInts(len(x), len(y))
+	}
+	return strings.Compare(x, y)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// validateVersion rejects versions that can not be stored or addressed. With
+// strict set only semantic versions are accepted.
+func validateVersion(version string, strict bool) error {
+	switch {
+	case version == "":
+		return fmt.Errorf("%w: version is empty", ErrInvalidVersion)
+	case contains(reservedVersions, version):
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidVersion, version)
+	case strings.Contains(version, "/"):
+		return fmt.Errorf("%w: %q contains a \"/\"", ErrInvalidVersion, version)
+	}
+
+	if _, ok := parseSemver(version); strict && !ok {
+		return fmt.Errorf("%w: %q is not a semantic version", ErrInvalidVersion, version)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package configstore
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		in string
+		ok bool
+	}{
+		{"1.2.3", true},
+		{"v1.2.3", true},
+		{"0.0.0", true},
+		{"1.2.3-rc.1", true},
+		{"1.2.3-rc.1+build.5", true},
+		{"1.2.3+20240101", true},
+		{"1.2.3-0a", true},
+		{"1.2", false},
+		{"1.2.3.4", false},
+		{"01.2.3", false},
+		{"1.2.3-01", false},
+		{"1.2.3-", false},
+		{"1.2.3-rc..1", false},
+		{"1.2.3+", false},
+		{"1.2.3-rc_1", false},
+		{"a.b.c", false},
+		{"v2", false},
+		{"1.2.99999999999999999999", false},
+	}
+	for _, tt := range tests {
+		if _, ok := parseSemver(tt.in); ok != tt.ok {
+			t.Errorf("parseSemver(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"1.10.0", "1.9.0", 1},
+		{"1.0.10", "1.0.9", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-rc.1", "1.0.0-beta.11", 1},
+		{"v1.0.0", "1.0.0", 1},
+		{"1.0.0+b", "1.0.0+a", 1},
+		{"v2", "v10", -1},
+		{"v10", "v9", 1},
+		{"v1", "v1", 0},
+		{"v1", "v1a", -1},
+		{"v01", "v1", -1},
+		{"a", "b", -1},
+		{"release-99999999999999999999", "release-100000000000000000000", -1},
+		{"1.0.0", "v2", -1},
+	}
+	for _, tt := range tests {
+		if got := compareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	// The semver precedence example, shuffled.
+	versions := []string{
+		"1.0.0", "1.0.0-rc.1", "1.0.0-alpha.beta", "1.0.0-beta.11",
+		"1.0.0-alpha", "1.0.0-beta", "1.0.0-alpha.1", "1.0.0-beta.2",
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	want := "1.0.0-alpha 1.0.0-alpha.1 1.0.0-alpha.beta 1.0.0-beta 1.0.0-beta.2 1.0.0-beta.11 1.0.0-rc.1 1.0.0"
+	if got := strings.Join(versions, " "); got != want {
+		t.Errorf("sorted versions = %s, want %s", got, want)
+	}
+
+	versions = []string{"v10", "v2", "v1", "v1.1", "v9"}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	want = "v1 v1.1 v2 v9 v10"
+	if got := strings.Join(versions, " "); got != want {
+		t.Errorf("sorted versions = %s, want %s", got, want)
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		strict  bool
+		ok      bool
+	}{
+		{"v1", false, true},
+		{"1.2.3", false, true},
+		{"1.2.3", true, true},
+		{"v1", true, false},
+		{"", false, false},
+		{"latest", false, false},
+		{"diff", false, false},
+		{"v1/v2", false, false},
+	}
+	for _, tt := range tests {
+		err := validateVersion(tt.version, tt.strict)
+		if (err == nil) != tt.ok {
+			t.Errorf("validateVersion(%q, %v) = %v", tt.version, tt.strict, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidVersion) {
+			t.Errorf("validateVersion(%q, %v) = %v, want ErrInvalidVersion", tt.version, tt.strict, err)
+		}
+	}
+}
+
+func TestLatestVersion(t *testing.T) {
+	ctx := context.Background()
+	cs := newTestStore()
+
+	config, err := cs.CreateConfig(ctx, &Config{Version: "v1", Entries: map[string]Value{"k": Value(`"v"`)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ver := range []string{"v10", "v2"} {
+		if _, err := cs.UpdateConfigVersion(ctx, &Config{ID: config.ID, Version: ver, Entries: config.Entries}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	configs, err := cs.FindConfVersions(ctx, config.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var versions []string
+	for _, c := range configs {
+		versions = append(versions, c.Version)
+	}
+	if got := strings.Join(versions, " "); got != "v1 v2 v10" {
+		t.Errorf("versions = %s, want v1 v2 v10", got)
+	}
+
+	ver, err := cs.ConfigVersion(ctx, config.ID, LatestVersion)
+	if err != nil || ver != "v10" {
+		t.Errorf("latest = %q, %v, want v10", ver, err)
+	}
+	if _, err := cs.ConfigVersion(ctx, "missing", LatestVersion); !errors.Is(err, ErrNotFound) {
+		t.Errorf("latest of a missing config = %v, want ErrNotFound", err)
+	}
+}
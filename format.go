@@ -0,0 +1,643 @@
+package main
+
+import (
+	cs "ARS_Projekat/configstore"
+	"ARS_Projekat/tracer"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	formatJSON       = "json"
+	formatYAML       = "yaml"
+	formatTOML       = "toml"
+	formatDotenv     = "env"
+	formatProperties = "properties"
+)
+
+// format is a way of rendering config entries. Everything other than JSON
+// renders the entries only.
+type format struct {
+	name      string
+	mediaType string
+	// aliases are other names accepted by ?format= and other media types
+	// accepted in the Accept header.
+	aliases    []string
+	mediaTypes []string
+	encode     func(b *bytes.Buffer, entries map[string]interface{}) error
+}
+
+var formats = []*format{
+	{name: formatJSON, mediaType: "application/json"},
+	{name: formatYAML, mediaType: "application/yaml", aliases: []string{"yml"},
+		mediaTypes: []string{"application/x-yaml", "text/yaml", "text/x-yaml"}, encode: encodeYAML},
+	{name: formatTOML, mediaType: "application/toml", encode: encodeTOML},
+	{name: formatDotenv, mediaType: "text/x-dotenv", aliases: []string{"dotenv"}, encode: encodeDotenv},
+	{name: formatProperties, mediaType: "text/x-java-properties", encode: encodeProperties},
+}
+
+var (
+	errUnknownFormat = errors.New("Unknown format, expected json, yaml, toml, env or properties")
+	errNotAcceptable = errors.New("None of the accepted media types can be rendered, expected application/json, application/yaml, application/toml, text/x-dotenv or text/x-java-properties")
+	errOnlyJSON      = errors.New("Pointer and provenance are only available as JSON")
+)
+
+// document is one config rendered into a multi-document output, name says
+// which one.
+type document struct {
+	name    string
+	entries map[string]cs.Value
+}
+
+// labelDocuments makes a document of every label set, named by its labels.
+func labelDocuments(configs []map[string]string) []*document {
+	docs := make([]*document, 0, len(configs))
+	for _, labels := range configs {
+		entries := make(map[string]cs.Value, len(labels))
+		for k, v := range labels {
+			entries[k] = cs.StringValue(v)
+		}
+		docs = append(docs, &document{name: cs.LabelString(labels), entries: entries})
+	}
+	return docs
+}
+
+// groupDocuments makes a document of every label set of the group followed
+// by one for every referenced config. Dangling references give an empty
+// document.
+func groupDocuments(group *cs.ResolvedGroup) []*document {
+	docs := labelDocuments(group.Configs)
+	for _, ref := range group.Refs {
+		doc := &document{name: ref.ID + "/" + ref.Version}
+		if ref.Dangling || ref.Config == nil {
+			doc.name += " (dangling)"
+		} else {
+			doc.entries = ref.Config.Entries
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// negotiateFormat picks the format of a response: ?format= if given,
+// otherwise the most preferred media type of the Accept header that can be
+// rendered. JSON is the default.
+func negotiateFormat(req *http.Request) (*format, error) {
+	if name := req.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if f.named(name) {
+				return f, nil
+			}
+		}
+		return nil, errUnknownFormat
+	}
+
+	header := req.Header.Get("Accept")
+	if strings.TrimSpace(header) == "" {
+		return formats[0], nil
+	}
+
+	type accepted struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []accepted
+	for _, part := range strings.Split(header, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, accepted{mediaType: mediaType, q: q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+		for _, f := range formats {
+			if f.matches(r.mediaType) {
+				return f, nil
+			}
+		}
+	}
+	return nil, errNotAcceptable
+}
+
+// named reports whether ?format=name asks for the format.
+func (f *format) named(name string) bool {
+	if f.name == name {
+		return true
+	}
+	for _, alias := range f.aliases {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the media range of an Accept header, e.g. text/*,
+// covers the format.
+func (f *format) matches(mediaRange string) bool {
+	if mediaRange == "*/*" {
+		return true
+	}
+	for _, mediaType := range append([]string{f.mediaType}, f.mediaTypes...) {
+		if mediaType == mediaRange {
+			return true
+		}
+		if strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatErrorStatus is the status of a failed negotiateFormat.
+func formatErrorStatus(err error) int {
+	if errors.Is(err, errUnknownFormat) {
+		return http.StatusBadRequest
+	}
+	return http.StatusNotAcceptable
+}
+
+// renderEntries renders the entries of one config in format f.
+func renderEntries(ctx context.Context, w http.ResponseWriter, f *format, entries map[string]cs.Value) {
+	renderDocuments(ctx, w, f, []*document{{entries: entries}})
+}
+
+// renderDocuments renders configs in format f one after the other. Every
+// document starts with a line naming it: "--- # name" in YAML, a "# --- name"
+// comment in the formats without documents.
+func renderDocuments(ctx context.Context, w http.ResponseWriter, f *format, docs []*document) {
+	span := tracer.StartSpanFromContext(ctx, "renderDocuments")
+	defer span.Finish()
+
+	var out bytes.Buffer
+	for _, doc := range docs {
+		entries, err := decodeEntries(doc.entries)
+		if err != nil {
+			tracer.LogError(span, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if doc.name != "" {
+			name := strings.NewReplacer("\n", " ", "\r", " ").Replace(doc.name)
+			if f.name == formatYAML {
+				out.WriteString("--- # " + name + "\n")
+			} else {
+				out.WriteString("# --- " + name + "\n")
+			}
+		}
+		var b bytes.Buffer
+		if err := f.encode(&b, entries); err != nil {
+			tracer.LogError(span, err)
+			http.Error(w, fmt.Sprintf("Can not render as %s: %v", f.name, err), http.StatusNotAcceptable)
+			return
+		}
+		out.Write(b.Bytes())
+	}
+
+	w.Header().Set("Content-Type", f.mediaType+"; charset=utf-8")
+	w.Write(out.Bytes())
+}
+
+func decodeEntries(entries map[string]cs.Value) (map[string]interface{}, error) {
+	decoded := make(map[string]interface{}, len(entries))
+	for k, v := range entries {
+		dec := json.NewDecoder(bytes.NewReader(v))
+		dec.UseNumber()
+
+		var node interface{}
+		if err := dec.Decode(&node); err != nil {
+			return nil, fmt.Errorf("entry %q: %v", k, err)
+		}
+		decoded[k] = node
+	}
+	return decoded, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// quoteString quotes s the way YAML and TOML double quoted strings are
+// written. Characters neither allows unescaped become \uXXXX.
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		default:
+			if r < 0x20 || r == 0x7f || (r >= 0x80 && r < 0xa0) || r == 0x2028 || r == 0x2029 || r == 0xfeff {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+var (
+	yamlPlain = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./-]*$`)
+	// yamlReserved are plain scalars YAML 1.1 parsers read as something
+	// other than a string.
+	yamlReserved = regexp.MustCompile(`^(?i:y|n|yes|no|on|off|true|false|null)$`)
+)
+
+func encodeYAML(b *bytes.Buffer, entries map[string]interface{}) error {
+	if len(entries) == 0 {
+		b.WriteString("{}\n")
+		return nil
+	}
+	yamlBlock(b, entries, 0, false)
+	return nil
+}
+
+// yamlBlock writes a non empty object or array as a block at indent. With
+// inline set the first line goes right after a "- " already written.
+func yamlBlock(b *bytes.Buffer, node interface{}, indent int, inline bool) {
+	pad := strings.Repeat(" ", indent)
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for i, k := range sortedKeys(n) {
+			if i > 0 || !inline {
+				b.WriteString(pad)
+			}
+			b.WriteString(yamlString(k) + ":")
+			if isBlock(n[k]) {
+				b.WriteString("\n")
+				yamlBlock(b, n[k], indent+2, false)
+			} else {
+				b.WriteString(" " + yamlScalar(n[k]) + "\n")
+			}
+		}
+	case []interface{}:
+		for i, item := range n {
+			if i > 0 || !inline {
+				b.WriteString(pad)
+			}
+			b.WriteString("- ")
+			if isBlock(item) {
+				yamlBlock(b, item, indent+2, true)
+			} else {
+				b.WriteString(yamlScalar(item) + "\n")
+			}
+		}
+	}
+}
+
+func isBlock(node interface{}) bool {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		return len(n) > 0
+	case []interface{}:
+		return len(n) > 0
+	}
+	return false
+}
+
+func yamlScalar(node interface{}) string {
+	switch n := node.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(n)
+	case json.Number:
+		return n.String()
+	case string:
+		return yamlString(n)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return quoteString(fmt.Sprint(node))
+}
+
+func yamlString(s string) string {
+	if yamlPlain.MatchString(s) && !yamlReserved.MatchString(s) {
+		return s
+	}
+	return quoteString(s)
+}
+
+var tomlBare = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// errTOMLNull is returned for entries holding a null anywhere, TOML has no
+// null and leaving it out would change the config.
+var errTOMLNull = errors.New("TOML has no null")
+
+// encodeTOML writes the entries as a TOML document. Objects become tables
+// and arrays of objects arrays of tables. Entries holding a null can not be
+// written.
+func encodeTOML(b *bytes.Buffer, entries map[string]interface{}) error {
+	return tomlTable(b, nil, entries)
+}
+
+func tomlTable(b *bytes.Buffer, path []string, table map[string]interface{}) error {
+	keys := sortedKeys(table)
+	// Plain values come first, they belong to the header above them.
+	for _, k := range keys {
+		v := table[k]
+		if _, ok := v.(map[string]interface{}); ok || isTableArray(v) {
+			continue
+		}
+		value, err := tomlValue(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", tomlPath(append(path[:len(path):len(path)], k)), err)
+		}
+		b.WriteString(tomlKey(k) + " = " + value + "\n")
+	}
+	for _, k := range keys {
+		child := append(path[:len(path):len(path)], k)
+		switch v := table[k].(type) {
+		case map[string]interface{}:
+			tomlHeader(b, "["+tomlPath(child)+"]")
+			if err := tomlTable(b, child, v); err != nil {
+				return err
+			}
+		case []interface{}:
+			if !isTableArray(v) {
+				continue
+			}
+			for _, item := range v {
+				tomlHeader(b, "[["+tomlPath(child)+"]]")
+				if err := tomlTable(b, child, item.(map[string]interface{})); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func tomlHeader(b *bytes.Buffer, header string) {
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	b.WriteString(header + "\n")
+}
+
+// isTableArray reports whether node is an array of objects only, written
+// as an array of tables.
+func isTableArray(node interface{}) bool {
+	items, ok := node.([]interface{})
+	if !ok || len(items) == 0 {
+		return false
+	}
+	for _, item := range items {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func tomlValue(node interface{}) (string, error) {
+	switch n := node.(type) {
+	case nil:
+		return "", errTOMLNull
+	case bool:
+		return strconv.FormatBool(n), nil
+	case json.Number:
+		return n.String(), nil
+	case string:
+		return quoteString(n), nil
+	case []interface{}:
+		values := make([]string, len(n))
+		for i, item := range n {
+			value, err := tomlValue(item)
+			if err != nil {
+				return "", err
+			}
+			values[i] = value
+		}
+		return "[" + strings.Join(values, ", ") + "]", nil
+	case map[string]interface{}:
+		keys := sortedKeys(n)
+		if len(keys) == 0 {
+			return "{}", nil
+		}
+		values := make([]string, len(keys))
+		for i, k := range keys {
+			value, err := tomlValue(n[k])
+			if err != nil {
+				return "", err
+			}
+			values[i] = tomlKey(k) + " = " + value
+		}
+		return "{ " + strings.Join(values, ", ") + " }", nil
+	}
+	return quoteString(fmt.Sprint(node)), nil
+}
+
+func tomlKey(k string) string {
+	if tomlBare.MatchString(k) {
+		return k
+	}
+	return quoteString(k)
+}
+
+func tomlPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, k := range path {
+		keys[i] = tomlKey(k)
+	}
+	return strings.Join(keys, ".")
+}
+
+// flatEntry is a leaf of the entries, for the formats that only have flat
+// keys. path holds the object keys and array indexes leading to it.
+type flatEntry struct {
+	path  []interface{}
+	value interface{}
+}
+
+// flatten returns the leaves of the entries in key order. Empty objects and
+// arrays are leaves as well.
+func flatten(entries map[string]interface{}) []*flatEntry {
+	var out []*flatEntry
+	var walk func(path []interface{}, node interface{})
+	walk = func(path []interface{}, node interface{}) {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			if len(n) > 0 {
+				for _, k := range sortedKeys(n) {
+					walk(append(path[:len(path):len(path)], k), n[k])
+				}
+				return
+			}
+		case []interface{}:
+			if len(n) > 0 {
+				for i, item := range n {
+					walk(append(path[:len(path):len(path)], i), item)
+				}
+				return
+			}
+		}
+		out = append(out, &flatEntry{path: path, value: node})
+	}
+	for _, k := range sortedKeys(entries) {
+		walk([]interface{}{k}, entries[k])
+	}
+	return out
+}
+
+// flatText is a leaf value as text: strings as they are, null as nothing
+// and anything else as JSON.
+func flatText(node interface{}) string {
+	switch n := node.(type) {
+	case nil:
+		return ""
+	case string:
+		return n
+	case json.Number:
+		return n.String()
+	}
+	data, _ := json.Marshal(node)
+	return string(data)
+}
+
+var (
+	envUnsafe = regexp.MustCompile(`[^A-Z0-9_]`)
+	envPlain  = regexp.MustCompile(`^[A-Za-z0-9_./:@+-]*$`)
+)
+
+// encodeDotenv writes one NAME=value line per leaf. Names are the path in
+// upper case joined by "_", e.g. DB_HOSTS_0. Values that are not plain are
+// single quoted, or double quoted with escapes if they hold a ' or a line
+// break.
+func encodeDotenv(b *bytes.Buffer, entries map[string]interface{}) error {
+	seen := make(map[string]string)
+	for _, leaf := range flatten(entries) {
+		parts := make([]string, len(leaf.path))
+		for i, p := range leaf.path {
+			parts[i] = fmt.Sprint(p)
+		}
+		key := strings.Join(parts, ".")
+		name := envUnsafe.ReplaceAllString(strings.ToUpper(strings.Join(parts, "_")), "_")
+		if name == "" || (name[0] >= '0' && name[0] <= '9') {
+			name = "_" + name
+		}
+		if other, ok := seen[name]; ok {
+			return fmt.Errorf("entries %q and %q are both named %s", other, key, name)
+		}
+		seen[name] = key
+
+		b.WriteString(name + "=" + envValue(flatText(leaf.value)) + "\n")
+	}
+	return nil
+}
+
+func envValue(s string) string {
+	if envPlain.MatchString(s) {
+		return s
+	}
+	if !strings.ContainsAny(s, "'\n\r") {
+		return "'" + s + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// encodeProperties writes one key=value line per leaf as
+// java.util.Properties stores them. Keys are the path joined by "." with
+// array indexes in brackets, e.g. db.hosts[0].
+func encodeProperties(b *bytes.Buffer, entries map[string]interface{}) error {
+	for _, leaf := range flatten(entries) {
+		var key strings.Builder
+		for i, p := range leaf.path {
+			switch p := p.(type) {
+			case int:
+				key.WriteString("[" + strconv.Itoa(p) + "]")
+			default:
+				if i > 0 {
+					key.WriteString(".")
+				}
+				key.WriteString(fmt.Sprint(p))
+			}
+		}
+		b.WriteString(propertiesEscape(key.String(), true) + "=" + propertiesEscape(flatText(leaf.value), false) + "\n")
+	}
+	return nil
+}
+
+// propertiesEscape escapes s like Properties.store: separators and comment
+// characters get a backslash, so do spaces in keys and leading spaces in
+// values, and anything outside printable ASCII becomes \uXXXX.
+func propertiesEscape(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case ' ':
+			if key || i == 0 {
+				b.WriteString(`\ `)
+			} else {
+				b.WriteRune(r)
+			}
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		default:
+			if r < 0x20 || r > 0x7e {
+				for _, unit := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&b, `\u%04X`, unit)
+				}
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	cs "ARS_Projekat/configstore"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// encode renders entries, a JSON object, in format f.
+func encode(t *testing.T, f *format, entries string) (string, error) {
+	t.Helper()
+
+	var values map[string]cs.Value
+	if err := json.Unmarshal([]byte(entries), &values); err != nil {
+		t.Fatalf("decoding %s: %v", entries, err)
+	}
+	decoded, err := decodeEntries(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	err = f.encode(&b, decoded)
+	return b.String(), err
+}
+
+func formatNamed(t *testing.T, name string) *format {
+	t.Helper()
+
+	for _, f := range formats {
+		if f.name == name {
+			return f
+		}
+	}
+	t.Fatalf("no format %s", name)
+	return nil
+}
+
+func TestEncodeFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		entries string
+		want    string
+	}{
+		{"yaml scalars", formatYAML, `{"a": 1, "b": "x", "c": true, "d": null}`, "a: 1\nb: x\nc: true\nd: null\n"},
+		{"yaml quoting", formatYAML, `{"a": "yes", "b": "1", "c": "a b", "d": "line\nbreak"}`, "a: \"yes\"\nb: \"1\"\nc: \"a b\"\nd: \"line\\nbreak\"\n"},
+		{"yaml nested", formatYAML, `{"db": {"hosts": ["a", {"b": 1}], "tls": {}}}`, "db:\n  hosts:\n    - a\n    - b: 1\n  tls: {}\n"},
+		{"yaml empty", formatYAML, `{}`, "{}\n"},
+		{"toml scalars", formatTOML, `{"a": 1.5, "b": "x\"y", "c": false}`, "a = 1.5\nb = \"x\\\"y\"\nc = false\n"},
+		{"toml tables", formatTOML, `{"z": 1, "db": {"port": 5432, "tls": {"on": true}}}`, "z = 1\n\n[db]\nport = 5432\n\n[db.tls]\non = true\n"},
+		{"toml array of tables", formatTOML, `{"hosts": [{"name": "a"}, {"name": "b"}]}`, "[[hosts]]\nname = \"a\"\n\n[[hosts]]\nname = \"b\"\n"},
+		{"toml inline values", formatTOML, `{"a": [1, [2]], "b": [{"c": 1}, 2], "d": {}, "e.f": 1}`, "a = [1, [2]]\nb = [{ c = 1 }, 2]\n\"e.f\" = 1\n\n[d]\n"},
+		{"dotenv", formatDotenv, `{"db": {"hosts": ["a", "b"], "port": 5432}, "msg": "it's", "url": "a b", "nil": null}`, "DB_HOSTS_0=a\nDB_HOSTS_1=b\nDB_PORT=5432\nMSG=\"it's\"\nNIL=\nURL='a b'\n"},
+		{"dotenv names", formatDotenv, `{"1st": "x", "a-b": "y"}`, "_1ST=x\nA_B=y\n"},
+		{"properties", formatProperties, `{"db": {"hosts": ["a"], "name": "é"}, "a b": "=x", "c": " y"}`, "a\\ b=\\=x\nc=\\ y\ndb.hosts[0]=a\ndb.name=\\u00E9\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encode(t, formatNamed(t, tt.format), tt.entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s rendered as\n%s\nwant\n%s", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		entries string
+	}{
+		{"toml null", formatTOML, `{"a": null}`},
+		{"toml null in array", formatTOML, `{"a": [1, null]}`},
+		{"toml null in table", formatTOML, `{"a": {"b": null}}`},
+		{"toml null in inline table", formatTOML, `{"a": [{"b": null}, 1]}`},
+		{"toml null in array of tables", formatTOML, `{"a": [{"b": null}]}`},
+		{"dotenv name clash", formatDotenv, `{"a_b": 1, "a": {"b": 2}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := encode(t, formatNamed(t, tt.format), tt.entries); err == nil {
+				t.Errorf("%s rendered as %q, want an error", tt.entries, got)
+			}
+		})
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		query  string
+		accept string
+		want   string
+		err    error
+	}{
+		{"", "", formatJSON, nil},
+		{"?format=yml", "", formatYAML, nil},
+		{"?format=dotenv", "application/json", formatDotenv, nil},
+		{"?format=xml", "", "", errUnknownFormat},
+		{"", "application/toml", formatTOML, nil},
+		{"", "text/yaml", formatYAML, nil},
+		{"", "text/html;q=1, text/x-java-properties;q=0.5, application/yaml;q=0.8", formatYAML, nil},
+		{"", "application/json;q=0, text/*", formatYAML, nil},
+		{"", "*/*", formatJSON, nil},
+		{"", "text/html", "", errNotAcceptable},
+		{"", "application/toml;q=0", "", errNotAcceptable},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/config/x/v1/"+tt.query, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		f, err := negotiateFormat(req)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s Accept %q: err = %v, want %v", tt.query, tt.accept, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || f.name != tt.want {
+			t.Errorf("%s Accept %q: got %v, %v, want %s", tt.query, tt.accept, f, err, tt.want)
+		}
+	}
+}
+
+func TestRenderNullAsTOML(t *testing.T) {
+	h := newTestServer(t)
+	id := createConfig(t, h, `{"version": "v1", "entries": {"a": 1, "b": [1, null]}}`)
+
+	if rec := do(t, h, "GET", "/config/"+id+"/v1/?format=yaml", ""); rec.Code != http.StatusOK {
+		t.Errorf("yaml: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "GET", "/config/"+id+"/v1/?format=toml", ""); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("toml: status = %d, want 406: %s", rec.Code, rec.Body)
+	}
+}
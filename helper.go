@@ -4,10 +4,41 @@ import (
 	cs "ARS_Projekat/configstore"
 	"ARS_Projekat/tracer"
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// indexHeader carries the store index of a read, to be passed back as
+	// ?index= to block until the data changes.
+	indexHeader = "X-Config-Index"
+
+	// nextTokenHeader carries the token of the next page of a listing, to be
+	// passed back as ?token=. It is missing on the last page.
+	nextTokenHeader = "X-Next-Token"
+
+	// revealHeader carries the token allowing a request to reveal secrets,
+	// see REVEAL_TOKEN.
+	revealHeader = "X-Reveal-Token"
+
+	// idempotencyHeader carries the idempotence key of a write answered
+	// with JSON, to be sent back as x-idempotency-key.
+	idempotencyHeader = "X-Idempotency-Key"
+
+	defaultWait = 5 * time.Minute
+	maxWait     = 10 * time.Minute
+
+	// eventHeartbeat keeps idle event streams from being cut by proxies.
+	eventHeartbeat = 15 * time.Second
 )
 
 func decodeConfigBody(ctx context.Context, r io.Reader) (*cs.Config, error) {
@@ -22,9 +53,74 @@ func decodeConfigBody(ctx context.Context, r io.Reader) (*cs.Config, error) {
 		tracer.LogError(span, err)
 		return nil, err
 	}
+	// Metadata and the sealed secrets are owned by the store.
+	if config != nil {
+		config.Metadata = nil
+		config.Sealed = nil
+	}
 	return config, nil
 }
 
+func decodeNamespaceBody(ctx context.Context, r io.Reader) (*cs.Namespace, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeNamespaceBody")
+	defer span.Finish()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var ns *cs.Namespace
+	if err := dec.Decode(&ns); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if ns == nil || ns.Name == "" {
+		err := errors.New("name is required")
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return ns, nil
+}
+
+func decodeRollbackBody(ctx context.Context, r io.Reader) (*cs.Rollback, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeRollbackBody")
+	defer span.Finish()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var rollback *cs.Rollback
+	if err := dec.Decode(&rollback); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if rollback == nil || rollback.From == "" || rollback.Version == "" {
+		err := errors.New("from and version are required")
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return rollback, nil
+}
+
+func decodePromoteBody(ctx context.Context, r io.Reader) (*cs.Promote, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodePromoteBody")
+	defer span.Finish()
+
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+
+	var promote *cs.Promote
+	if err := dec.Decode(&promote); err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	if promote == nil || promote.PromotedBy == "" {
+		err := errors.New("promotedBy is required")
+		tracer.LogError(span, err)
+		return nil, err
+	}
+	return promote, nil
+}
+
 func decodeGroupBody(ctx context.Context, r io.Reader) (*cs.Group, error) {
 	span := tracer.StartSpanFromContext(ctx, "decodeGroupBody")
 	defer span.Finish()
@@ -40,6 +136,185 @@ func decodeGroupBody(ctx context.Context, r io.Reader) (*cs.Group, error) {
 	return group, nil
 }
 
+// decodeBlockingQuery reads the ?index=N&wait=30s parameters of a blocking
+// read. Without index the read does not block.
+func decodeBlockingQuery(ctx context.Context, req *http.Request) (uint64, time.Duration, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeBlockingQuery")
+	defer span.Finish()
+
+	query := req.URL.Query()
+
+	var index uint64
+	if v := query.Get("index"); v != "" {
+		i, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			err = errors.New("index must be a non-negative integer")
+			tracer.LogError(span, err)
+			return 0, 0, err
+		}
+		index = i
+	}
+
+	wait := defaultWait
+	if v := query.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			err = errors.New("wait must be a positive duration such as 30s")
+			tracer.LogError(span, err)
+			return 0, 0, err
+		}
+		wait = d
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	return index, wait, nil
+}
+
+var errRevealDenied = errors.New("Revealing secrets needs a valid " + revealHeader)
+
+// decodeReveal reports whether the request asks for secrets in plain text
+// with ?reveal=true. Only requests carrying the reveal token may do so, and
+// none at all when no token is configured.
+func decodeReveal(ctx context.Context, req *http.Request, token string) (bool, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeReveal")
+	defer span.Finish()
+
+	if req.URL.Query().Get("reveal") != "true" {
+		return false, nil
+	}
+
+	if !hasRevealToken(req, token) {
+		tracer.LogError(span, errRevealDenied)
+		return false, errRevealDenied
+	}
+	return true, nil
+}
+
+func hasRevealToken(req *http.Request, token string) bool {
+	given := req.Header.Get(revealHeader)
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func decodePage(ctx context.Context, req *http.Request) (string, int, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodePage")
+	defer span.Finish()
+
+	query := req.URL.Query()
+
+	limit := cs.DefaultPageSize
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l <= 0 || l > cs.MaxPageSize {
+			err = fmt.Errorf("limit must be between 1 and %d", cs.MaxPageSize)
+			tracer.LogError(span, err)
+			return "", 0, err
+		}
+		limit = l
+	}
+
+	return query.Get("token"), limit, nil
+}
+
+// decodeDiffStyle returns how a diff is rendered, ?style=json (the default)
+// or ?style=unified. Diffs used ?format= for this before it picked the
+// format of rendered configs, so it is still read when style is missing.
+func decodeDiffStyle(ctx context.Context, req *http.Request) (string, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeDiffStyle")
+	defer span.Finish()
+
+	query := req.URL.Query()
+	style := query.Get("style")
+	if style == "" {
+		style = query.Get("format")
+	}
+
+	switch style {
+	case "", "json":
+		return "json", nil
+	case "unified":
+		return style, nil
+	}
+	err := errors.New("style must be json or unified")
+	tracer.LogError(span, err)
+	return "", err
+}
+
+func setNextToken(w http.ResponseWriter, next string) {
+	if next != "" {
+		w.Header().Set(nextTokenHeader, next)
+	}
+}
+
+// decodeLabels turns label query parameters into a label set. A label given
+// more than once can never match, so only its first value is kept.
+func decodeLabels(ctx context.Context, form url.Values) map[string]string {
+	span := tracer.StartSpanFromContext(ctx, "decodeLabels")
+	defer span.Finish()
+
+	labels := make(map[string]string, len(form))
+	for k := range form {
+		labels[k] = form.Get(k)
+	}
+	return labels
+}
+
+// reservedParams are the query parameters of group config routes that are
+// never labels, "_" being the usual cache buster. A label with one of these
+// names can still be queried with ?selector=.
+var reservedParams = []string{"index", "wait", "format", "match", "reveal", "style", "limit", "token", "_"}
+
+// decodeLabelQuery parses the query of a group config route and drops the
+// reserved parameters, leaving the labels and selectors.
+func decodeLabelQuery(ctx context.Context, req *http.Request) url.Values {
+	span := tracer.StartSpanFromContext(ctx, "decodeLabelQuery")
+	defer span.Finish()
+
+	req.ParseForm()
+	for _, param := range reservedParams {
+		req.Form.Del(param)
+	}
+	return req.Form
+}
+
+// decodeSelector builds the selector of a group config query from the
+// ?selector= parameter, narrowed down by any plain label parameters.
+func decodeSelector(ctx context.Context, form url.Values) (*cs.Selector, error) {
+	span := tracer.StartSpanFromContext(ctx, "decodeSelector")
+	defer span.Finish()
+
+	selector := form["selector"]
+	form.Del("selector")
+
+	sel, err := cs.ParseSelector(strings.Join(selector, ","))
+	if err != nil {
+		tracer.LogError(span, err)
+		return nil, err
+	}
+
+	for k, v := range decodeLabels(ctx, form) {
+		sel.Requirements = append(sel.Requirements, cs.Requirement{Key: k, Operator: cs.OpEquals, Values: []string{v}})
+	}
+	return sel, nil
+}
+
+// writeEvent writes one server-sent event. Its id is unique in the stream
+// so a client can resume with Last-Event-ID.
+func writeEvent(ctx context.Context, w io.Writer, event *cs.Event) error {
+	span := tracer.StartSpanFromContext(ctx, "writeEvent")
+	defer span.Finish()
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		tracer.LogError(span, err)
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.EventID(), event.Type, data)
+	return err
+}
+
 func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}, id string) {
 	span := tracer.StartSpanFromContext(ctx, "renderJSON")
 	defer span.Finish()
@@ -55,6 +330,51 @@ func renderJSON(ctx context.Context, w http.ResponseWriter, v interface{}, id st
 	w.Write(js)
 }
 
+// renderUnprocessable answers with 422 and why the config was rejected,
+// e.g. its schema violations or unresolved references.
+func renderUnprocessable(ctx context.Context, w http.ResponseWriter, reason error) {
+	span := tracer.StartSpanFromContext(ctx, "renderUnprocessable")
+	defer span.Finish()
+
+	js, err := json.Marshal(reason)
+	if err != nil {
+		tracer.LogError(span, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	w.Write(js)
+}
+
+// requestContext is the context handlers start from. It carries the
+// namespace and environment of the route, set by namespaced and
+// environment, but is not canceled with the request so writes are not cut
+// short.
+func requestContext(req *http.Request) context.Context {
+	ctx := cs.WithNamespace(context.Background(), cs.NamespaceFrom(req.Context()))
+	return cs.WithEnvironment(ctx, cs.EnvironmentFrom(req.Context()))
+}
+
+// layerErrorStatus is the status of a config whose layers can not be
+// merged: its stored parents are missing or broken, not the request.
+func layerErrorStatus(err error) int {
+	if errors.Is(err, cs.ErrDanglingRef) || errors.Is(err, cs.ErrLayerCycle) ||
+		errors.Is(err, cs.ErrTooManyLayers) || errors.Is(err, cs.ErrInvalidOverlay) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
 func createId(ctx context.Context) string {
 	return uuid.New().String()
 }
+
+func renderText(ctx context.Context, w http.ResponseWriter, text string) {
+	span := tracer.StartSpanFromContext(ctx, "renderText")
+	defer span.Finish()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, text)
+}
@@ -12,33 +12,30 @@ import (
 )
 
 func main() {
+	// export and import run once instead of starting the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// test
-	quit := make(chan os.Signal)
+	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
-	router := mux.NewRouter()
-	router.StrictSlash(true)
-
 	server, err := NewConfigServer()
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
-	router.HandleFunc("/config/", countPostConfig(server.createConfigHandler)).Methods("POST")
-	router.HandleFunc("/config/{id}/", countGetConfigVersion(server.getConfigVersionsHandler)).Methods("GET")
-	router.HandleFunc("/config/{id}", countPostConfigVersion(server.putNewConfigVersion)).Methods("POST")
-	router.HandleFunc("/config/{id}/{ver}/", countGetConfig(server.getConfigHandler)).Methods("GET")
-	router.HandleFunc("/config/{id}/{ver}", countDeleteConfig(server.deleteConfigHandler)).Methods("DELETE")
+	router := newRouter(server)
 
-	router.HandleFunc("/group/", countPostGroup(server.createGroupHandler)).Methods("POST")
-	router.HandleFunc("/group/{id}", countPostGroupVersion(server.putNewGroupVersion)).Methods("POST")
-	router.HandleFunc("/group/{id}/{ver}/", countGetGroup(server.getGroupHandler)).Methods("GET")
-	router.HandleFunc("/group/{id}/{ver}/", countDeleteGroup(server.deleteGroupHandler)).Methods("DELETE")
-	router.HandleFunc("/group/{id}/{ver}/config/", countGetGroupConfigs(server.getConfigFromGroup)).Methods("GET")
-	router.HandleFunc("/group/{id}/{ver}/config/", countAddGroupConfig(server.addConfigToGroupHandler)).Methods("POST")
-
-	router.Path("/metrics").Handler(metricsHandler())
+	// purge deleted versions once their retention expires
+	purgeCtx, stopPurger := context.WithCancel(context.Background())
+	defer stopPurger()
+	go server.store.RunPurger(purgeCtx)
 
 	// start server
 	srv := &http.Server{Addr: "0.0.0.0:8000", Handler: router}
@@ -54,6 +51,7 @@ func main() {
 	<-quit
 
 	log.Println("service shutting down ...")
+	stopPurger()
 
 	// gracefully stop server
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -64,3 +62,82 @@ func main() {
 	}
 	log.Println("server stopped")
 }
+
+// newRouter adds every route of the server.
+func newRouter(server *Service) *mux.Router {
+	router := mux.NewRouter()
+	router.StrictSlash(true)
+
+	// namespaces, the routes below apply to the default one unless they
+	// are reached through /ns/{namespace}/
+	router.HandleFunc("/ns/", countPostNamespace(server.createNamespaceHandler)).Methods("POST")
+	router.HandleFunc("/ns/", countGetNamespaces(server.getNamespacesHandler)).Methods("GET")
+	router.HandleFunc("/ns/{namespace}", countDeleteNamespace(server.deleteNamespaceHandler)).Methods("DELETE")
+
+	// environments, the routes below also exist in each of them through
+	// /env/{env}/ and /ns/{namespace}/env/{env}/
+	router.HandleFunc("/env/", countGetEnvironments(server.getEnvironmentsHandler)).Methods("GET")
+
+	registerRoutes(router, server)
+	registerEnvironmentRoutes(router, server)
+	namespaced := router.PathPrefix("/ns/{namespace}").Subrouter()
+	namespaced.Use(server.namespaced)
+	registerRoutes(namespaced, server)
+	registerEnvironmentRoutes(namespaced, server)
+
+	router.HandleFunc("/secrets/rotate", countRotateSecrets(server.rotateSecretsHandler)).Methods("POST")
+	router.HandleFunc("/export", countExport(server.exportHandler)).Methods("GET")
+	router.HandleFunc("/import", countImport(server.importHandler)).Methods("POST")
+
+	router.Path("/metrics").Handler(metricsHandler())
+
+	return router
+}
+
+// registerRoutes adds the config and group routes, which exist once for the
+// default namespace, once below /ns/{namespace} and once more in every
+// environment of those.
+func registerRoutes(r *mux.Router, server *Service) {
+	r.HandleFunc("/config/", countPostConfig(server.createConfigHandler)).Methods("POST")
+	r.HandleFunc("/config/", countGetConfigs(server.getConfigsHandler)).Methods("GET")
+	r.HandleFunc("/config/{id}/", countGetConfigVersion(server.getConfigVersionsHandler)).Methods("GET")
+	r.HandleFunc("/config/{id}", countPostConfigVersion(server.putNewConfigVersion)).Methods("POST")
+	r.HandleFunc("/config/{id}/rollback", countRollbackConfig(server.rollbackConfigHandler)).Methods("POST")
+	r.HandleFunc("/config/{id}/schema/", countPostSchema(server.postSchemaHandler)).Methods("POST")
+	r.HandleFunc("/config/{id}/schema/", countGetSchema(server.getSchemasHandler)).Methods("GET")
+	r.HandleFunc("/config/{id}/schema/{ver}/", countGetSchema(server.getSchemaHandler)).Methods("GET")
+	r.HandleFunc("/config/{id}/promotions", countGetPromotions(server.getConfigPromotionsHandler)).Methods("GET")
+	r.HandleFunc("/config/{id}/diff", countGetConfigDiff(server.getConfigDiffHandler)).Methods("GET")
+	r.HandleFunc("/config/{id}/{ver}/", countGetConfig(server.getConfigHandler)).Methods("GET")
+	r.HandleFunc("/config/{id}/{ver}", countDeleteConfig(server.deleteConfigHandler)).Methods("DELETE")
+	r.HandleFunc("/config/{id}/{ver}/restore", countRestoreConfig(server.restoreConfigHandler)).Methods("POST")
+
+	r.HandleFunc("/group/", countPostGroup(server.createGroupHandler)).Methods("POST")
+	r.HandleFunc("/group/", countGetGroups(server.getGroupsHandler)).Methods("GET")
+	r.HandleFunc("/group/{id}/", countGetGroupVersion(server.getGroupVersionsHandler)).Methods("GET")
+	r.HandleFunc("/group/{id}", countPostGroupVersion(server.putNewGroupVersion)).Methods("POST")
+	r.HandleFunc("/group/{id}/promotions", countGetPromotions(server.getGroupPromotionsHandler)).Methods("GET")
+	r.HandleFunc("/group/{id}/diff", countGetGroupDiff(server.getGroupDiffHandler)).Methods("GET")
+	r.HandleFunc("/group/{id}/{ver}/", countGetGroup(server.getGroupHandler)).Methods("GET")
+	r.HandleFunc("/group/{id}/{ver}/", countDeleteGroup(server.deleteGroupHandler)).Methods("DELETE")
+	r.HandleFunc("/group/{id}/{ver}/restore", countRestoreGroup(server.restoreGroupHandler)).Methods("POST")
+	r.HandleFunc("/group/{id}/{ver}/config/", countGetGroupConfigs(server.getConfigFromGroup)).Methods("GET")
+	r.HandleFunc("/group/{id}/{ver}/config/", countAddGroupConfig(server.addConfigToGroupHandler)).Methods("POST")
+	r.HandleFunc("/group/{id}/{ver}/config/", countDeleteGroupConfig(server.deleteConfigFromGroupHandler)).Methods("DELETE")
+	r.HandleFunc("/group/{id}/{ver}/config/", countReplaceGroupConfig(server.replaceConfigInGroupHandler)).Methods("PUT")
+
+	r.HandleFunc("/events", countGetEvents(server.eventsHandler)).Methods("GET")
+	r.HandleFunc("/deleted/", countGetDeleted(server.getDeletedHandler)).Methods("GET")
+	r.HandleFunc("/search", countSearch(server.searchHandler)).Methods("GET")
+}
+
+// registerEnvironmentRoutes adds the routes below /env/{env}, which are the
+// config and group routes plus promotion to the next environment.
+func registerEnvironmentRoutes(r *mux.Router, server *Service) {
+	env := r.PathPrefix("/env/{env}").Subrouter()
+	env.Use(server.environment)
+
+	env.HandleFunc("/config/{id}/{ver}/promote", countPromoteConfig(server.promoteConfigHandler)).Methods("POST")
+	env.HandleFunc("/group/{id}/{ver}/promote", countPromoteGroup(server.promoteGroupHandler)).Methods("POST")
+	registerRoutes(env, server)
+}
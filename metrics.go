@@ -93,10 +93,191 @@ var (
 		},
 	)
 
+	deleteGroupConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_del_group_config_hit_total",
+			Help: "Total number of remove configs from a group hits.",
+		},
+	)
+
+	replaceGroupConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_replace_group_config_hit_total",
+			Help: "Total number of replace config in a group hits.",
+		},
+	)
+
+	rollbackConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_rollback_config_hit_total",
+			Help: "Total number of rollback config hits.",
+		},
+	)
+
+	restoreConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_restore_config_hit_total",
+			Help: "Total number of restore config hits.",
+		},
+	)
+
+	restoreGroupHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_restore_group_hit_total",
+			Help: "Total number of restore group hits.",
+		},
+	)
+
+	getDeletedHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_deleted_hit_total",
+			Help: "Total number of list deleted versions hits.",
+		},
+	)
+
+	getConfigsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_configs_hit_total",
+			Help: "Total number of list configs hits.",
+		},
+	)
+
+	getGroupsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_groups_hit_total",
+			Help: "Total number of list groups hits.",
+		},
+	)
+
+	getGroupVersionHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_group_version_hit_total",
+			Help: "Total number of get group versions hits.",
+		},
+	)
+
+	searchHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_search_hit_total",
+			Help: "Total number of search hits.",
+		},
+	)
+
+	postSchemaHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_post_schema_hit_total",
+			Help: "Total number of register schema hits.",
+		},
+	)
+
+	getSchemaHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_schema_hit_total",
+			Help: "Total number of get schema hits.",
+		},
+	)
+
+	getConfigDiffHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_config_diff_hit_total",
+			Help: "Total number of diff config versions hits.",
+		},
+	)
+
+	getGroupDiffHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_group_diff_hit_total",
+			Help: "Total number of diff group versions hits.",
+		},
+	)
+
+	getEventsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_events_hit_total",
+			Help: "Total number of event stream hits.",
+		},
+	)
+
+	rotateSecretsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_rotate_secrets_hit_total",
+			Help: "Total number of rotate secrets hits.",
+		},
+	)
+
+	postNamespaceHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_post_namespace_hit_total",
+			Help: "Total number of create namespace hits.",
+		},
+	)
+
+	getNamespacesHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_namespaces_hit_total",
+			Help: "Total number of list namespaces hits.",
+		},
+	)
+
+	deleteNamespaceHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_delete_namespace_hit_total",
+			Help: "Total number of delete namespace hits.",
+		},
+	)
+
+	getEnvironmentsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_environments_hit_total",
+			Help: "Total number of get environments hits.",
+		},
+	)
+
+	promoteConfigHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_promote_config_hit_total",
+			Help: "Total number of promote config hits.",
+		},
+	)
+
+	promoteGroupHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_promote_group_hit_total",
+			Help: "Total number of promote group hits.",
+		},
+	)
+
+	getPromotionsHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_get_promotions_hit_total",
+			Help: "Total number of get promotions hits.",
+		},
+	)
+
+	exportHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_export_hit_total",
+			Help: "Total number of export hits.",
+		},
+	)
+
+	importHits = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "configstore_import_hit_total",
+			Help: "Total number of import hits.",
+		},
+	)
+
 	metricsList = []prometheus.Collector{
 		postConfigHits, getConfigVersionHits, postConfigVersionHits, getConfigHits,
 		deleteConfigHits, postGroupHits, postGroupVersionHits, getGroupHits, deleteGroupHits,
-		getGroupConfigHits, addGroupConfigHits, httpHits,
+		getGroupConfigHits, addGroupConfigHits, deleteGroupConfigHits, replaceGroupConfigHits,
+		getConfigDiffHits, getGroupDiffHits, getEventsHits, rollbackConfigHits,
+		restoreConfigHits, restoreGroupHits, getDeletedHits, getConfigsHits, getGroupsHits,
+		getGroupVersionHits, searchHits, postSchemaHits, getSchemaHits, rotateSecretsHits,
+		postNamespaceHits, getNamespacesHits, deleteNamespaceHits, getEnvironmentsHits,
+		promoteConfigHits, promoteGroupHits, getPromotionsHits, exportHits, importHits,
+		httpHits,
 	}
 
 	prometheusRegistry = prometheus.NewRegistry()
@@ -197,3 +378,203 @@ func countAddGroupConfig(f func(http.ResponseWriter, *http.Request)) func(http.R
 		f(w, r) // original function call
 	}
 }
+
+func countDeleteGroupConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		deleteGroupConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countReplaceGroupConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		replaceGroupConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetConfigDiff(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getConfigDiffHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetGroupDiff(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getGroupDiffHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetEvents(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getEventsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRollbackConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		rollbackConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRestoreConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		restoreConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRestoreGroup(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		restoreGroupHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetDeleted(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getDeletedHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetConfigs(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getConfigsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetGroups(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getGroupsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetGroupVersion(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getGroupVersionHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countSearch(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		searchHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countPostSchema(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		postSchemaHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetSchema(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getSchemaHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countRotateSecrets(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		rotateSecretsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countPostNamespace(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		postNamespaceHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetNamespaces(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getNamespacesHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countDeleteNamespace(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		deleteNamespaceHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetEnvironments(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getEnvironmentsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countPromoteConfig(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		promoteConfigHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countPromoteGroup(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		promoteGroupHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countGetPromotions(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		getPromotionsHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countExport(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		exportHits.Inc()
+		f(w, r) // original function call
+	}
+}
+
+func countImport(f func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpHits.Inc()
+		importHits.Inc()
+		f(w, r) // original function call
+	}
+}
@@ -10,15 +10,22 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/opentracing/opentracing-go"
 	"io"
+	"log"
 	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"strconv"
+	"time"
 )
 
 type Service struct {
 	store  *cs.ConfigStore
 	tracer opentracing.Tracer
 	closer io.Closer
+	// revealToken must be sent to read secrets in plain text. Secrets can
+	// not be revealed when it is empty.
+	revealToken string
 }
 
 const (
@@ -34,9 +41,10 @@ func NewConfigServer() (*Service, error) {
 	tracer, closer := tracer.Init(name)
 	opentracing.SetGlobalTracer(tracer)
 	return &Service{
-		store:  store,
-		tracer: tracer,
-		closer: closer,
+		store:       store,
+		tracer:      tracer,
+		closer:      closer,
+		revealToken: os.Getenv("REVEAL_TOKEN"),
 	}, nil
 }
 
@@ -75,7 +83,7 @@ func (ts *Service) createConfigHandler(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
 
 	rt, err := decodeConfigBody(ctx, req.Body)
 	if err != nil || rt.Version == "" || rt.Entries == nil {
@@ -89,12 +97,22 @@ func (ts *Service) createConfigHandler(w http.ResponseWriter, req *http.Request)
 	}
 
 	config, err := ts.store.CreateConfig(ctx, rt)
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Config already exists", http.StatusConflict)
+		return
+	}
+	var verr *cs.ValidationError
+	if errors.As(err, &verr) {
+		renderUnprocessable(ctx, w, verr)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not create config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	reqId := ""
+	reqId := ts.store.SaveRequestId(ctx)
 
-	if err == nil {
-		reqId = ts.store.SaveRequestId(ctx)
-	}
 	w.Write([]byte("Config ID: " + config.ID))
 	w.Write([]byte("\n\nIdempotence key: " + reqId))
 }
@@ -124,7 +142,7 @@ func (ts *Service) putNewConfigVersion(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
 
 	rt, err := decodeConfigBody(ctx, req.Body)
 	if err != nil {
@@ -140,92 +158,119 @@ func (ts *Service) putNewConfigVersion(w http.ResponseWriter, req *http.Request)
 	}
 
 	config, err := ts.store.UpdateConfigVersion(ctx, rt)
-
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Given config version already exists! ", http.StatusConflict)
+		return
+	}
+	var verr *cs.ValidationError
+	if errors.As(err, &verr) {
+		renderUnprocessable(ctx, w, verr)
+		return
+	}
 	if err != nil {
-		http.Error(w, "Given config version already exists! ", http.StatusBadRequest)
+		http.Error(w, "Could not create config version: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	reqId := ""
-
-	if err == nil {
-
-		reqId = ts.store.SaveRequestId(ctx)
-	}
+	reqId := ts.store.SaveRequestId(ctx)
 
 	w.Write([]byte("Config ID: " + config.ID))
 	w.Write([]byte("\n\nIdempotence key: " + reqId))
 }
 
-func (ts *Service) getConfigHandler(w http.ResponseWriter, req *http.Request) {
-	span := tracer.StartSpanFromRequest("getConfigHandler", ts.tracer, req)
+func (ts *Service) rollbackConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("rollbackConfigHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling get config at %s\n", req.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling config rollback at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	contentType := req.Header.Get("Content-Type")
+	requestId := req.Header.Get("x-idempotency-key")
 
-	ver := mux.Vars(req)["ver"]
+	mediatype, _, err := mime.ParseMediaType(contentType)
 	id := mux.Vars(req)["id"]
-	task, ok := ts.store.FindConfig(ctx, id, ver)
-	if ok != nil {
-		err := errors.New("key not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	renderJSON(ctx, w, task, "")
-}
 
-func (ts *Service) getConfigVersionsHandler(w http.ResponseWriter, req *http.Request) {
-	span := tracer.StartSpanFromRequest("getConfigVersionsHandler", ts.tracer, req)
-	defer span.Finish()
+	if mediatype != "application/json" {
+		err := errors.New("Expect application/json Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
 
-	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling get config versions at %s\n", req.URL.Path)),
-	)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	rb, err := decodeRollbackBody(ctx, req.Body)
+	if err != nil {
+		http.Error(w, "Invalid JSON format: "+err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	id := mux.Vars(req)["id"]
-	task, ok := ts.store.FindConfVersions(ctx, id)
-	if ok != nil {
-		err := errors.New("key not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
 		return
 	}
-	renderJSON(ctx, w, task, "")
+
+	config, err := ts.store.RollbackConfig(ctx, id, rb.From, rb.Version)
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(w, "Source config version does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Given config version already exists! ", http.StatusConflict)
+		return
+	}
+	var verr *cs.ValidationError
+	if errors.As(err, &verr) {
+		renderUnprocessable(ctx, w, verr)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not roll back config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Config ID: " + config.ID))
+	w.Write([]byte("\n\nIdempotence key: " + reqId))
 }
 
-func (ts *Service) createGroupHandler(w http.ResponseWriter, req *http.Request) {
-	span := tracer.StartSpanFromRequest("createGroupHandler", ts.tracer, req)
+func (ts *Service) postSchemaHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("postSchemaHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling create group at %s\n", req.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling register schema at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
-
 	contentType := req.Header.Get("Content-Type")
 	requestId := req.Header.Get("x-idempotency-key")
 
 	mediatype, _, err := mime.ParseMediaType(contentType)
+	id := mux.Vars(req)["id"]
+
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if mediatype != "application/json" {
+	if mediatype != "application/json" && mediatype != "application/schema+json" {
 		err := errors.New("Expect application/json Content-Type")
 		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
 		return
 	}
 
-	rt, err := decodeGroupBody(ctx, req.Body)
-	if err != nil || rt.Version == "" || rt.Configs == nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -234,195 +279,1495 @@ func (ts *Service) createGroupHandler(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	group, err := ts.store.CreateGroup(ctx, rt)
-
-	reqId := ""
-
-	if err == nil {
-
-		reqId = ts.store.SaveRequestId(ctx)
+	sv, err := ts.store.RegisterSchema(ctx, id, body)
+	if errors.Is(err, cs.ErrInvalidSchema) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Another schema was registered at the same time, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not register schema: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	w.Write([]byte("Group ID: " + group.ID))
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Config ID: " + sv.ID))
+	w.Write([]byte("\nSchema version: " + strconv.Itoa(sv.Version)))
 	w.Write([]byte("\n\nIdempotence key: " + reqId))
 }
 
-func (ts *Service) getGroupHandler(w http.ResponseWriter, req *http.Request) {
-	span := tracer.StartSpanFromRequest("getGroupHandler", ts.tracer, req)
+func (ts *Service) getSchemasHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getSchemasHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling get group at %s\n", req.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling get schemas at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
 
-	ver := mux.Vars(req)["ver"]
 	id := mux.Vars(req)["id"]
 
-	task, ok := ts.store.FindGroup(ctx, id, ver)
-
-	if ok != nil {
-		err := errors.New("key not found")
-		http.Error(w, err.Error(), http.StatusNotFound)
+	schemas, err := ts.store.FindSchemas(ctx, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	renderJSON(ctx, w, task, "")
+	renderJSON(ctx, w, schemas, "")
 }
 
-func (ts *Service) getConfigFromGroup(w http.ResponseWriter, req *http.Request) {
-	span := tracer.StartSpanFromRequest("getConfigFromGroup", ts.tracer, req)
+func (ts *Service) getSchemaHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getSchemaHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling get config from group at %s\n", req.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling get schema at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
 
-	ver := mux.Vars(req)["ver"]
 	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
 
-	req.ParseForm()
-	params := url.Values.Encode(req.Form)
-	labels, err := ts.store.FindLabels(ctx, id, ver, params)
+	sv, err := ts.store.FindSchema(ctx, id, ver)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, "key not found", http.StatusNotFound)
 		return
 	}
-	renderJSON(ctx, w, labels, "")
+	renderJSON(ctx, w, sv, "")
 }
 
-func (ts *Service) putNewGroupVersion(w http.ResponseWriter, req *http.Request) {
-	span := tracer.StartSpanFromRequest("putNewGroupVersion", ts.tracer, req)
+func (ts *Service) getConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getConfigHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling put new group version at %s\n", req.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling get config at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
-	contentType := req.Header.Get("Content-Type")
-	requestId := req.Header.Get("x-idempotency-key")
+	index, wait, err := decodeBlockingQuery(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	mediatype, _, err := mime.ParseMediaType(contentType)
+	f, err := negotiateFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), formatErrorStatus(err))
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+
+	ver := mux.Vars(req)["ver"]
 	id := mux.Vars(req)["id"]
 
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	query := req.URL.Query()
+	_, hasPointer := query["pointer"]
+	if f.name != formatJSON && (hasPointer || query.Get("provenance") == "true") {
+		http.Error(w, errOnlyJSON.Error(), http.StatusNotAcceptable)
 		return
 	}
 
-	if mediatype != "application/json" {
-		err := errors.New("Expect application/json Content-Type")
-		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	// A request that is denied must not wait first.
+	reveal, err := decodeReveal(ctx, req, ts.revealToken)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
 
-	rt, err := decodeGroupBody(ctx, req.Body)
-	if err != nil || rt.Version == "" || rt.Configs == nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+	index, err = ts.store.WaitConfig(ctx, id, ver, index, wait)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
 
-	if ts.store.FindRequestId(ctx, requestId) == true {
-		http.Error(w, "Request has been already sent", http.StatusForbidden)
+	ver, err = ts.store.ConfigVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
 		return
 	}
 
-	rt.ID = id
+	find := ts.store.FindConfig
+	if reveal {
+		find = ts.store.RevealConfig
+	}
+	task, ok := find(ctx, id, ver)
+	if errors.Is(ok, cs.ErrNotFound) {
+		err := errors.New("key not found")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if ok != nil {
+		http.Error(w, ok.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	config, err := ts.store.UpdateGroupVersion(ctx, rt)
+	if query.Get("provenance") == "true" {
+		prov, err := ts.store.ConfigProvenance(ctx, task, reveal)
+		if err != nil {
+			http.Error(w, err.Error(), layerErrorStatus(err))
+			return
+		}
+		renderJSON(ctx, w, prov, "")
+		return
+	}
 
-	reqId := ""
+	// A layered config is served merged, merged=false asks for the layer
+	// alone.
+	if query.Get("merged") != "false" {
+		task, err = ts.store.MergeConfig(ctx, task, reveal)
+		if err != nil {
+			http.Error(w, err.Error(), layerErrorStatus(err))
+			return
+		}
+	}
 
-	if err == nil {
-		reqId = ts.store.SaveRequestId(ctx)
+	if query.Get("resolve") == "true" {
+		task, err = ts.store.ResolveConfig(ctx, task, reveal)
+		var rerr *cs.ResolveError
+		if errors.As(err, &rerr) {
+			renderUnprocessable(ctx, w, rerr)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 	}
 
-	if err != nil {
-		http.Error(w, "Given config version already exists! ", http.StatusBadRequest)
+	if pointer, ok := query["pointer"]; ok {
+		value, err := task.Lookup(pointer[0])
+		if errors.Is(err, cs.ErrInvalidPointer) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "key not found", http.StatusNotFound)
+			return
+		}
+		renderJSON(ctx, w, value, "")
 		return
 	}
-
-	w.Write([]byte("Group ID: " + config.ID))
-	w.Write([]byte("\n\nIdempotence key: " + reqId))
+	if f.name != formatJSON {
+		renderEntries(ctx, w, f, task.Entries)
+		return
+	}
+	renderJSON(ctx, w, task, "")
 }
 
-func (ts *Service) addConfigToGroupHandler(w http.ResponseWriter, r *http.Request) {
-	span := tracer.StartSpanFromRequest("addConfigToGroupHandler", ts.tracer, r)
+func (ts *Service) getConfigVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getConfigVersionsHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling add config to group at %s\n", r.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling get config versions at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
-
-	requestId := r.Header.Get("x-idempotency-key")
+	ctx := tracer.ContextWithSpan(req.Context(), span)
 
-	id := mux.Vars(r)["id"]
-	ver := mux.Vars(r)["ver"]
-	var configs []map[string]string
-	dec := json.NewDecoder(r.Body)
-	defer r.Body.Close()
+	index, wait, err := decodeBlockingQuery(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	err := dec.Decode(&configs)
+	token, limit, err := decodePage(ctx, req)
 	if err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	configs, err = ts.store.AddLabelsToGroup(ctx, configs, id, ver)
+	id := mux.Vars(req)["id"]
 
+	index, err = ts.store.WaitConfVersions(ctx, id, index, wait)
 	if err != nil {
-		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
 
-	if ts.store.FindRequestId(ctx, requestId) == true {
-		http.Error(w, "Request has been already sent", http.StatusForbidden)
+	task, next, err := ts.store.ListConfigVersions(ctx, id, token, limit)
+	if errors.Is(err, cs.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	reqId := ts.store.SaveRequestId(ctx)
-
-	w.Write([]byte("Idempotence key: " + reqId))
+	if err != nil {
+		err := errors.New("key not found")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	setNextToken(w, next)
+	renderJSON(ctx, w, task, "")
 }
 
-func (ts *Service) deleteConfigHandler(w http.ResponseWriter, r *http.Request) {
-	span := tracer.StartSpanFromRequest("deleteConfigHandler", ts.tracer, r)
+func (ts *Service) getConfigsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getConfigsHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling delete config at %s\n", r.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling list configs at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
 
-	id := mux.Vars(r)["id"]
-	ver := mux.Vars(r)["ver"]
-	_, err := ts.store.DeleteConfig(ctx, id, ver)
+	token, limit, err := decodePage(ctx, req)
 	if err != nil {
-		http.Error(w, "Could not delete config", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ids, next, err := ts.store.ListConfigs(ctx, token, limit)
+	if errors.Is(err, cs.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	setNextToken(w, next)
+	renderJSON(ctx, w, ids, "")
 }
 
-func (ts *Service) deleteGroupHandler(writer http.ResponseWriter, request *http.Request) {
-	span := tracer.StartSpanFromRequest("deleteGroupHandler", ts.tracer, request)
+func (ts *Service) createGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("createGroupHandler", ts.tracer, req)
 	defer span.Finish()
 
 	span.LogFields(
-		tracer.LogString("handler", fmt.Sprintf("Handling delete group at %s\n", request.URL.Path)),
+		tracer.LogString("handler", fmt.Sprintf("Handling create group at %s\n", req.URL.Path)),
 	)
 
-	ctx := tracer.ContextWithSpan(context.Background(), span)
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
 
-	id := mux.Vars(request)["id"]
-	ver := mux.Vars(request)["ver"]
-	err := ts.store.DeleteGroup(ctx, id, ver)
+	contentType := req.Header.Get("Content-Type")
+	requestId := req.Header.Get("x-idempotency-key")
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
 	if err != nil {
-		http.Error(writer, "Could not delete group", http.StatusBadRequest)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mediatype != "application/json" {
+		err := errors.New("Expect application/json Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	rt, err := decodeGroupBody(ctx, req.Body)
+	if err != nil || rt.Version == "" || (rt.Configs == nil && rt.Refs == nil) {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	group, err := ts.store.CreateGroup(ctx, rt)
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Group already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not create group: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Group ID: " + group.ID))
+	w.Write([]byte("\n\nIdempotence key: " + reqId))
+}
+
+func (ts *Service) getGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getGroupHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get group at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	index, wait, err := decodeBlockingQuery(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := negotiateFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), formatErrorStatus(err))
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+
+	ver := mux.Vars(req)["ver"]
+	id := mux.Vars(req)["id"]
+
+	index, err = ts.store.WaitGroup(ctx, id, ver, index, wait)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+
+	ver, err = ts.store.GroupVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	task, ok := ts.store.FindGroup(ctx, id, ver)
+
+	if ok != nil {
+		err := errors.New("key not found")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	group, err := ts.store.ResolveGroup(ctx, task)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if f.name != formatJSON {
+		renderDocuments(ctx, w, f, groupDocuments(group))
+		return
+	}
+	renderJSON(ctx, w, group, "")
+}
+
+func (ts *Service) getGroupsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getGroupsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling list groups at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	token, limit, err := decodePage(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ids, next, err := ts.store.ListGroups(ctx, token, limit)
+	if errors.Is(err, cs.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setNextToken(w, next)
+	renderJSON(ctx, w, ids, "")
+}
+
+func (ts *Service) getGroupVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getGroupVersionsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get group versions at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	index, wait, err := decodeBlockingQuery(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	token, limit, err := decodePage(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id := mux.Vars(req)["id"]
+
+	index, err = ts.store.WaitGroupVersions(ctx, id, index, wait)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+
+	groups, next, err := ts.store.ListGroupVersions(ctx, id, token, limit)
+	if errors.Is(err, cs.ErrInvalidToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	setNextToken(w, next)
+	renderJSON(ctx, w, groups, "")
+}
+
+func (ts *Service) getConfigFromGroup(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getConfigFromGroup", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get config from group at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	index, wait, err := decodeBlockingQuery(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, err := negotiateFormat(req)
+	if err != nil {
+		http.Error(w, err.Error(), formatErrorStatus(err))
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+
+	ver := mux.Vars(req)["ver"]
+	id := mux.Vars(req)["id"]
+
+	match := req.FormValue("match")
+	form := decodeLabelQuery(ctx, req)
+	_, hasSelector := form["selector"]
+
+	index, err = ts.store.WaitLabels(ctx, id, ver, index, wait)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(indexHeader, strconv.FormatUint(index, 10))
+
+	ver, err = ts.store.GroupVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	var labels []map[string]string
+	switch {
+	case hasSelector && match == "exact":
+		http.Error(w, "selector can not be combined with match=exact", http.StatusBadRequest)
+		return
+	case hasSelector:
+		var sel *cs.Selector
+		sel, err = decodeSelector(ctx, form)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		labels, err = ts.store.FindLabelsSelector(ctx, id, ver, sel)
+	case match == "exact":
+		labels, err = ts.store.FindLabels(ctx, id, ver, url.Values.Encode(form))
+	case match == "" || match == "subset":
+		labels, err = ts.store.FindLabelsMatching(ctx, id, ver, decodeLabels(ctx, form))
+	default:
+		http.Error(w, "match must be exact or subset", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if f.name != formatJSON {
+		renderDocuments(ctx, w, f, labelDocuments(labels))
+		return
+	}
+	renderJSON(ctx, w, labels, "")
+}
+
+func (ts *Service) putNewGroupVersion(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("putNewGroupVersion", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling put new group version at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	contentType := req.Header.Get("Content-Type")
+	requestId := req.Header.Get("x-idempotency-key")
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	id := mux.Vars(req)["id"]
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mediatype != "application/json" {
+		err := errors.New("Expect application/json Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	rt, err := decodeGroupBody(ctx, req.Body)
+	if err != nil || rt.Version == "" || (rt.Configs == nil && rt.Refs == nil) {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	rt.ID = id
+
+	config, err := ts.store.UpdateGroupVersion(ctx, rt)
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Given group version already exists! ", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not create group version: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Group ID: " + config.ID))
+	w.Write([]byte("\n\nIdempotence key: " + reqId))
+}
+
+func (ts *Service) addConfigToGroupHandler(w http.ResponseWriter, r *http.Request) {
+	span := tracer.StartSpanFromRequest("addConfigToGroupHandler", ts.tracer, r)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling add config to group at %s\n", r.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(r), span)
+
+	requestId := r.Header.Get("x-idempotency-key")
+
+	id := mux.Vars(r)["id"]
+	ver := mux.Vars(r)["ver"]
+	var configs []map[string]string
+	dec := json.NewDecoder(r.Body)
+	defer r.Body.Close()
+
+	err := dec.Decode(&configs)
+	if err != nil {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	ver, err = ts.store.GroupVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	configs, err = ts.store.AddLabelsToGroup(ctx, configs, id, ver)
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Group was modified concurrently, retry the request", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not add configs to group: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Idempotence key: " + reqId))
+}
+
+func (ts *Service) deleteConfigHandler(w http.ResponseWriter, r *http.Request) {
+	span := tracer.StartSpanFromRequest("deleteConfigHandler", ts.tracer, r)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling delete config at %s\n", r.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(r), span)
+
+	id := mux.Vars(r)["id"]
+	ver := mux.Vars(r)["ver"]
+	ver, err := ts.store.ConfigVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = ts.store.DeleteConfig(ctx, id, ver)
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Config was modified concurrently, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not delete config", http.StatusBadRequest)
+	}
+}
+
+func (ts *Service) deleteGroupHandler(writer http.ResponseWriter, request *http.Request) {
+	span := tracer.StartSpanFromRequest("deleteGroupHandler", ts.tracer, request)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling delete group at %s\n", request.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(request), span)
+
+	id := mux.Vars(request)["id"]
+	ver := mux.Vars(request)["ver"]
+	ver, err := ts.store.GroupVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(writer, "key not found", http.StatusNotFound)
+		return
+	}
+
+	err = ts.store.DeleteGroup(ctx, id, ver)
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(writer, "key not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(writer, "Group was modified concurrently, try again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Could not delete group", http.StatusBadRequest)
+	}
+}
+
+func (ts *Service) restoreConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreConfigHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling restore config at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	config, err := ts.store.RestoreConfig(ctx, id, ver)
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(w, "No deleted config version to restore", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Given config version exists again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not restore config: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(ctx, w, config, "")
+}
+
+func (ts *Service) restoreGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("restoreGroupHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling restore group at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	group, err := ts.store.RestoreGroup(ctx, id, ver)
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(w, "No deleted group version to restore", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Given group version exists again", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not restore group: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(ctx, w, group, "")
+}
+
+func (ts *Service) getDeletedHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getDeletedHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get deleted at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	tombstones, err := ts.store.FindTombstones(ctx, req.URL.Query().Get("kind"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	renderJSON(ctx, w, tombstones, "")
+}
+
+// namespaced serves the routes below /ns/{namespace}/ in that namespace,
+// once it is known to exist.
+func (ts *Service) namespaced(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["namespace"]
+
+		ok, err := ts.store.NamespaceExists(req.Context(), name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Namespace does not exist", http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(cs.WithNamespace(req.Context(), name)))
+	})
+}
+
+func (ts *Service) createNamespaceHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("createNamespaceHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling namespace create at %s\n", req.URL.Path)),
+	)
+
+	contentType := req.Header.Get("Content-Type")
+	requestId := req.Header.Get("x-idempotency-key")
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mediatype != "application/json" {
+		err := errors.New("Expect application/json Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	body, err := decodeNamespaceBody(ctx, req.Body)
+	if err != nil {
+		http.Error(w, "Invalid JSON format: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	ns, err := ts.store.CreateNamespace(ctx, body.Name)
+	if errors.Is(err, cs.ErrInvalidNamespace) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Namespace already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not create namespace: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Namespace: " + ns.Name))
+	w.Write([]byte("\n\nIdempotence key: " + reqId))
+}
+
+func (ts *Service) getNamespacesHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getNamespacesHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get namespaces at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	namespaces, err := ts.store.FindNamespaces(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(ctx, w, namespaces, "")
+}
+
+// deleteNamespaceHandler removes an empty namespace, or with ?force=true a
+// namespace and everything in it.
+func (ts *Service) deleteNamespaceHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("deleteNamespaceHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling delete namespace at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	name := mux.Vars(req)["namespace"]
+	force := req.URL.Query().Get("force") == "true"
+
+	err := ts.store.DeleteNamespace(ctx, name, force)
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(w, "Namespace does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrNamespaceNotEmpty) {
+		http.Error(w, err.Error()+", delete it with ?force=true", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) && name == cs.DefaultNamespace {
+		http.Error(w, "The default namespace can not be deleted", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(ctx, w, map[string]string{"Deleted namespace": name}, "")
+}
+
+// environment serves the routes below /env/{env} in that environment.
+func (ts *Service) environment(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := mux.Vars(req)["env"]
+
+		if !ts.store.EnvironmentExists(name) {
+			http.Error(w, "Environment does not exist", http.StatusNotFound)
+			return
+		}
+
+		next.ServeHTTP(w, req.WithContext(cs.WithEnvironment(req.Context(), name)))
+	})
+}
+
+// getEnvironmentsHandler lists the environments in the order versions are
+// promoted through them.
+func (ts *Service) getEnvironmentsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getEnvironmentsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get environments at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	renderJSON(ctx, w, ts.store.Environments(), "")
+}
+
+func (ts *Service) promoteConfigHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("promoteConfigHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling config promotion at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	ts.promote(ctx, w, req, cs.KindConfig)
+}
+
+func (ts *Service) promoteGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("promoteGroupHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling group promotion at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	ts.promote(ctx, w, req, cs.KindGroup)
+}
+
+// promote copies the config or group version of the route into the next
+// environment.
+func (ts *Service) promote(ctx context.Context, w http.ResponseWriter, req *http.Request, kind string) {
+	contentType := req.Header.Get("Content-Type")
+	requestId := req.Header.Get("x-idempotency-key")
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mediatype != "application/json" {
+		err := errors.New("Expect application/json Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	promote, err := decodePromoteBody(ctx, req.Body)
+	if err != nil {
+		http.Error(w, "Invalid JSON format: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	var promotion *cs.Promotion
+	if kind == cs.KindConfig {
+		promotion, err = ts.store.PromoteConfig(ctx, id, ver, promote.PromotedBy)
+	} else {
+		promotion, err = ts.store.PromoteGroup(ctx, id, ver, promote.PromotedBy)
+	}
+	if errors.Is(err, cs.ErrNotFound) {
+		http.Error(w, "Source version does not exist", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "The next environment has this version already", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, cs.ErrDanglingRef) {
+		http.Error(w, err.Error()+" in the next environment, promote it first", http.StatusConflict)
+		return
+	}
+	var verr *cs.ValidationError
+	if errors.As(err, &verr) {
+		renderUnprocessable(ctx, w, verr)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not promote: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Promoted: " + promotion.ID + "/" + promotion.Version))
+	w.Write([]byte("\nFrom: " + promotion.From + " to " + promotion.To))
+	w.Write([]byte("\n\nIdempotence key: " + reqId))
+}
+
+func (ts *Service) getConfigPromotionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getConfigPromotionsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get config promotions at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	ts.getPromotions(ctx, w, req, cs.KindConfig)
+}
+
+func (ts *Service) getGroupPromotionsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getGroupPromotionsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling get group promotions at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	ts.getPromotions(ctx, w, req, cs.KindGroup)
+}
+
+// getPromotions returns the promotion history of the config or group ID of
+// the route across the environments.
+func (ts *Service) getPromotions(ctx context.Context, w http.ResponseWriter, req *http.Request, kind string) {
+	id := mux.Vars(req)["id"]
+
+	promotions, err := ts.store.FindPromotions(ctx, kind, id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(ctx, w, promotions, "")
+}
+
+// rotateSecretsHandler re-encrypts the secrets with the primary master key,
+// after a new one was added to the keyfile. It needs the reveal token.
+func (ts *Service) rotateSecretsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("rotateSecretsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling rotate secrets at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	if !hasRevealToken(req, ts.revealToken) {
+		http.Error(w, errRevealDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	rotated, failed, err := ts.store.RotateSecrets(ctx)
+	if errors.Is(err, cs.ErrNoMasterKey) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(ctx, w, map[string]int{"rotated": rotated, "failed": failed}, "")
+}
+
+// exportHandler streams the whole store, or with ?namespace= one namespace,
+// as an archive. It needs the reveal token.
+func (ts *Service) exportHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("exportHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling export at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	if !hasRevealToken(req, ts.revealToken) {
+		http.Error(w, errRevealDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	namespace := req.URL.Query().Get("namespace")
+	if namespace != "" {
+		ok, err := ts.store.NamespaceExists(ctx, namespace)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "Namespace does not exist", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="configstore.ndjson"`)
+
+	// Once the archive is being written the status can not change anymore,
+	// a failure cuts it short without a trailing newline.
+	if err := ts.store.ExportArchive(ctx, w, namespace); err != nil {
+		tracer.LogError(span, err)
+		log.Printf("export failed: %v", err)
+	}
+}
+
+// importHandler restores an archive made by exportHandler. ?mode= is skip,
+// overwrite or fail (the default) for items the store has already. It needs
+// the reveal token.
+func (ts *Service) importHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("importHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling import at %s\n", req.URL.Path)),
+	)
+
+	contentType := req.Header.Get("Content-Type")
+	requestId := req.Header.Get("x-idempotency-key")
+
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if mediatype != "application/x-ndjson" {
+		err := errors.New("Expect application/x-ndjson Content-Type")
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	if !hasRevealToken(req, ts.revealToken) {
+		http.Error(w, errRevealDenied.Error(), http.StatusForbidden)
+		return
+	}
+
+	mode := cs.ConflictMode(req.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = cs.ConflictFail
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	result, err := ts.store.ImportArchive(ctx, req.Body, mode)
+	if errors.Is(err, cs.ErrInvalidConflictMode) || errors.Is(err, cs.ErrInvalidArchive) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var importErr *cs.ImportError
+	if errors.As(err, &importErr) {
+		// The items before the failed one were written, say how many.
+		status := http.StatusInternalServerError
+		if errors.Is(err, cs.ErrConflict) {
+			status = http.StatusConflict
+		}
+		http.Error(w, fmt.Sprintf("%s\nImported: %d\nOverwritten: %d\nSkipped: %d", err, result.Imported, result.Overwritten, result.Skipped), status)
+		return
+	}
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte(fmt.Sprintf("Imported: %d\nOverwritten: %d\nSkipped: %d", result.Imported, result.Overwritten, result.Skipped)))
+	w.Write([]byte("\n\nIdempotence key: " + reqId))
+}
+
+func (ts *Service) searchHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("searchHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling search at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	query := req.URL.Query()
+	q := &cs.SearchQuery{
+		Key:   query.Get("key"),
+		Value: query.Get("value"),
+		Match: query.Get("match"),
+	}
+	if q.Match == "" {
+		q.Match = cs.MatchExact
+	}
+
+	hits, err := ts.store.Search(ctx, q)
+	if errors.Is(err, cs.ErrInvalidSearch) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderJSON(ctx, w, hits, "")
+}
+
+func (ts *Service) eventsHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("eventsHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling event stream at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(req.Context(), span)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	lastEventId := req.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = req.URL.Query().Get("lastEventId")
+	}
+
+	var after cs.EventID
+	if lastEventId != "" {
+		id, err := cs.ParseEventID(lastEventId)
+		if err != nil {
+			http.Error(w, "Last-Event-ID: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		after = id
+	}
+
+	events, err := ts.store.Events(ctx, req.URL.Query().Get("prefix"), after)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := writeEvent(ctx, w, event); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (ts *Service) deleteConfigFromGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("deleteConfigFromGroupHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling delete config from group at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	requestId := req.Header.Get("x-idempotency-key")
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	sel, err := decodeSelector(ctx, decodeLabelQuery(ctx, req))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(sel.Requirements) == 0 {
+		http.Error(w, "A selector is required, delete the group version to remove all of its configs", http.StatusBadRequest)
+		return
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	ver, err = ts.store.GroupVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	configs, err := ts.store.RemoveLabelsFromGroup(ctx, id, ver, sel)
+	if errors.Is(err, cs.ErrConflict) {
+		http.Error(w, "Group was modified concurrently, retry the request", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Header().Set(idempotencyHeader, reqId)
+	renderJSON(ctx, w, configs, "")
+}
+
+func (ts *Service) replaceConfigInGroupHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("replaceConfigInGroupHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling replace config in group at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	requestId := req.Header.Get("x-idempotency-key")
+
+	id := mux.Vars(req)["id"]
+	ver := mux.Vars(req)["ver"]
+
+	sel, err := decodeSelector(ctx, decodeLabelQuery(ctx, req))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var config map[string]string
+	dec := json.NewDecoder(req.Body)
+	defer req.Body.Close()
+
+	err = dec.Decode(&config)
+	if err != nil || len(config) == 0 {
+		http.Error(w, "Invalid JSON format", http.StatusBadRequest)
+		return
+	}
+
+	if ts.store.FindRequestId(ctx, requestId) == true {
+		http.Error(w, "Request has been already sent", http.StatusForbidden)
+		return
+	}
+
+	ver, err = ts.store.GroupVersion(ctx, id, ver)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	_, err = ts.store.ReplaceLabelsInGroup(ctx, id, ver, sel, config)
+	switch {
+	case errors.Is(err, cs.ErrConflict):
+		http.Error(w, "Group was modified concurrently, retry the request", http.StatusConflict)
+		return
+	case errors.Is(err, cs.ErrAmbiguousMatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	reqId := ts.store.SaveRequestId(ctx)
+
+	w.Write([]byte("Idempotence key: " + reqId))
+}
+
+func (ts *Service) getConfigDiffHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getConfigDiffHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling config diff at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	id := mux.Vars(req)["id"]
+	from := req.URL.Query().Get("from")
+	to := req.URL.Query().Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "Both from and to versions are required", http.StatusBadRequest)
+		return
+	}
+
+	style, err := decodeDiffStyle(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	diff, err := ts.store.DiffConfigs(ctx, id, from, to)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	if style == "unified" {
+		renderText(ctx, w, diff.Unified())
+		return
+	}
+	renderJSON(ctx, w, diff, "")
+}
+
+func (ts *Service) getGroupDiffHandler(w http.ResponseWriter, req *http.Request) {
+	span := tracer.StartSpanFromRequest("getGroupDiffHandler", ts.tracer, req)
+	defer span.Finish()
+
+	span.LogFields(
+		tracer.LogString("handler", fmt.Sprintf("Handling group diff at %s\n", req.URL.Path)),
+	)
+
+	ctx := tracer.ContextWithSpan(requestContext(req), span)
+
+	id := mux.Vars(req)["id"]
+	from := req.URL.Query().Get("from")
+	to := req.URL.Query().Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "Both from and to versions are required", http.StatusBadRequest)
+		return
+	}
+
+	style, err := decodeDiffStyle(ctx, req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	diff, err := ts.store.DiffGroups(ctx, id, from, to)
+	if err != nil {
+		http.Error(w, "key not found", http.StatusNotFound)
+		return
+	}
+
+	if style == "unified" {
+		renderText(ctx, w, diff.Unified())
+		return
 	}
+	renderJSON(ctx, w, diff, "")
 }
@@ -0,0 +1,724 @@
+package main
+
+import (
+	cs "ARS_Projekat/configstore"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+)
+
+// newTestServer returns the routes of a server on an empty in-memory store.
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+
+	server := &Service{
+		store:  cs.NewWithBackend(cs.NewMemoryBackend()),
+		tracer: opentracing.NoopTracer{},
+	}
+	return newRouter(server)
+}
+
+// do sends one request, header holds pairs of header names and values.
+func do(t *testing.T, h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// created returns the ID of a write response such as "Config ID: ...".
+func created(t *testing.T, rec *httptest.ResponseRecorder, prefix string) string {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	line := strings.SplitN(rec.Body.String(), "\n", 2)[0]
+	if !strings.HasPrefix(line, prefix) {
+		t.Fatalf("response %q does not start with %q", rec.Body, prefix)
+	}
+	return strings.TrimPrefix(line, prefix)
+}
+
+func createConfig(t *testing.T, h http.Handler, body string) string {
+	t.Helper()
+	return created(t, do(t, h, "POST", "/config/", body), "Config ID: ")
+}
+
+func createGroup(t *testing.T, h http.Handler, body string) string {
+	t.Helper()
+	return created(t, do(t, h, "POST", "/group/", body), "Group ID: ")
+}
+
+func TestCreateAndGetConfig(t *testing.T) {
+	h := newTestServer(t)
+	id := createConfig(t, h, `{"version": "v1", "entries": {"k1": "value", "port": 8080}}`)
+
+	rec := do(t, h, "GET", "/config/"+id+"/v1/", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var config cs.Config
+	if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.ID != id || config.Version != "v1" {
+		t.Errorf("got %s/%s, want %s/v1", config.ID, config.Version, id)
+	}
+	if got := config.Entries["k1"].Text(); got != "value" {
+		t.Errorf("k1 = %q, want value", got)
+	}
+	if got := string(config.Entries["port"]); got != "8080" {
+		t.Errorf("port = %s, want 8080", got)
+	}
+}
+
+func TestCreateConfigRejects(t *testing.T) {
+	h := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		want   int
+	}{
+		{"not json", `{"version": "v1", "entries": {"k": "v"}}`, []string{"Content-Type", "text/plain"}, http.StatusUnsupportedMediaType},
+		{"malformed", `{"version": `, nil, http.StatusBadRequest},
+		{"no version", `{"entries": {"k": "v"}}`, nil, http.StatusBadRequest},
+		{"no entries", `{"version": "v1"}`, nil, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(t, h, "POST", "/config/", tt.body, tt.header...)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestGetMissingConfig(t *testing.T) {
+	h := newTestServer(t)
+
+	if rec := do(t, h, "GET", "/config/missing/v1/", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestConfigVersions(t *testing.T) {
+	h := newTestServer(t)
+	id := createConfig(t, h, `{"version": "v1", "entries": {"k": "one"}}`)
+
+	if rec := do(t, h, "POST", "/config/"+id, `{"version": "v2", "entries": {"k": "two"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("new version: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "POST", "/config/"+id, `{"version": "v2", "entries": {"k": "again"}}`); rec.Code != http.StatusConflict {
+		t.Errorf("existing version: status = %d, want 409", rec.Code)
+	}
+
+	rec := do(t, h, "GET", "/config/"+id+"/", "")
+	var configs []*cs.Config
+	if err := json.Unmarshal(rec.Body.Bytes(), &configs); err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 || configs[0].Version != "v1" || configs[1].Version != "v2" {
+		t.Errorf("versions = %+v, want v1 and v2", configs)
+	}
+
+	rec = do(t, h, "GET", "/config/"+id+"/latest/", "")
+	if !strings.Contains(rec.Body.String(), `"two"`) {
+		t.Errorf("latest = %s, want v2", rec.Body)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	h := newTestServer(t)
+
+	rec := do(t, h, "POST", "/config/", `{"version": "v1", "entries": {"k": "v"}}`)
+	parts := strings.SplitN(rec.Body.String(), "Idempotence key: ", 2)
+	if len(parts) != 2 {
+		t.Fatalf("no idempotence key in %q", rec.Body)
+	}
+
+	rec = do(t, h, "POST", "/config/", `{"version": "v1", "entries": {"k": "v"}}`, "x-idempotency-key", parts[1])
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("repeated request: status = %d, want 403", rec.Code)
+	}
+}
+
+func TestConfigPointer(t *testing.T) {
+	h := newTestServer(t)
+	id := createConfig(t, h, `{"version": "v1", "entries": {"db": {"hosts": ["a", "b"]}, "a/b": 1, "m~n": 2}}`)
+
+	tests := []struct {
+		pointer string
+		want    int
+		body    string
+	}{
+		{"/db/hosts/1", http.StatusOK, `"b"`},
+		{"/a~1b", http.StatusOK, `1`},
+		{"/m~0n", http.StatusOK, `2`},
+		{"/db/hosts/2", http.StatusNotFound, ""},
+		{"/missing", http.StatusNotFound, ""},
+		{"db", http.StatusBadRequest, ""},
+		{"/db/hosts/01", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		rec := do(t, h, "GET", "/config/"+id+"/v1/?pointer="+url.QueryEscape(tt.pointer), "")
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.pointer, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if tt.body != "" && strings.TrimSpace(rec.Body.String()) != tt.body {
+			t.Errorf("%s = %s, want %s", tt.pointer, rec.Body, tt.body)
+		}
+	}
+}
+
+func TestGetLayeredConfig(t *testing.T) {
+	h := newTestServer(t)
+	base := createConfig(t, h, `{"version": "v1", "entries": {"host": "db", "db": {"pool": {"min": 1, "max": 10}}}}`)
+	id := createConfig(t, h, `{"version": "v1", "parent": {"id": "`+base+`", "version": "latest"}, "entries": {"db": {"pool": {"max": 20}}}}`)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", `{"db":{"pool":{"max":20,"min":1}},"host":"db"}`},
+		{"?merged=true", `{"db":{"pool":{"max":20,"min":1}},"host":"db"}`},
+		{"?merged=false", `{"db":{"pool":{"max":20}}}`},
+	}
+	for _, tt := range tests {
+		rec := do(t, h, "GET", "/config/"+id+"/v1/"+tt.query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, want 200: %s", tt.query, rec.Code, rec.Body)
+		}
+		var config cs.Config
+		if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+			t.Fatal(err)
+		}
+		got, err := json.Marshal(config.Entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%q: entries = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	if rec := do(t, h, "DELETE", "/config/"+base+"/v1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete parent: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "GET", "/config/"+id+"/v1/", ""); rec.Code != http.StatusConflict {
+		t.Errorf("missing parent: status = %d, want 409: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "GET", "/config/"+id+"/v1/?merged=false", ""); rec.Code != http.StatusOK {
+		t.Errorf("layer of a missing parent: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+// idempotenceKey returns the idempotency key of a text write response.
+func idempotenceKey(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	parts := strings.SplitN(rec.Body.String(), "Idempotence key: ", 2)
+	if len(parts) != 2 {
+		t.Fatalf("no idempotence key in %q", rec.Body)
+	}
+	return parts[1]
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	h := newRouter(&Service{
+		store:       cs.NewWithBackend(cs.NewMemoryBackend()),
+		tracer:      opentracing.NoopTracer{},
+		revealToken: "secret",
+	})
+	for _, ns := range []string{"a", "b"} {
+		created(t, do(t, h, "POST", "/ns/", `{"name": "`+ns+`"}`), "Namespace: ")
+	}
+
+	// The same ids are written in both namespaces, with their own versions,
+	// entries and labels.
+	id := created(t, do(t, h, "POST", "/ns/a/config/", `{"version": "a1", "entries": {"owner": "team-a"}}`), "Config ID: ")
+	rec := do(t, h, "POST", "/ns/b/config/"+id, `{"version": "b1", "entries": {"owner": "team-b"}}`)
+	if got := created(t, rec, "Config ID: "); got != id {
+		t.Fatalf("b wrote config %s, want %s", got, id)
+	}
+	keyB := idempotenceKey(t, rec)
+	group := created(t, do(t, h, "POST", "/ns/a/group/", `{"version": "v1", "configs": [{"env": "prod", "team": "a"}]}`), "Group ID: ")
+	created(t, do(t, h, "POST", "/ns/b/group/"+group, `{"version": "v1", "configs": [{"env": "prod", "team": "b"}, {"env": "prod", "team": "b2"}]}`), "Group ID: ")
+
+	for _, ns := range []string{"a", "b"} {
+		other := map[string]string{"a": "b", "b": "a"}[ns]
+
+		rec := do(t, h, "GET", "/ns/"+ns+"/config/"+id+"/", "")
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":"`+ns+`1"`) || strings.Contains(rec.Body.String(), `"version":"`+other+`1"`) {
+			t.Errorf("%s: versions %d %s, want only its own", ns, rec.Code, rec.Body)
+		}
+		var ids []string
+		if err := json.Unmarshal(do(t, h, "GET", "/ns/"+ns+"/config/", "").Body.Bytes(), &ids); err != nil || len(ids) != 1 {
+			t.Errorf("%s: listing %q, %v, want the one config", ns, ids, err)
+		}
+		if rec := do(t, h, "GET", "/ns/"+ns+"/config/"+id+"/"+other+"1/", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: version of %s: status = %d, want 404", ns, other, rec.Code)
+		}
+
+		var labels []map[string]string
+		if err := json.Unmarshal(do(t, h, "GET", "/ns/"+ns+"/group/"+group+"/v1/config/?env=prod", "").Body.Bytes(), &labels); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range labels {
+			if !strings.HasPrefix(l["team"], ns) {
+				t.Errorf("%s: label query found %v", ns, l)
+			}
+		}
+		if want := map[string]int{"a": 1, "b": 2}[ns]; len(labels) != want {
+			t.Errorf("%s: label query found %d configs, want %d", ns, len(labels), want)
+		}
+
+		var hits []cs.SearchHit
+		if err := json.Unmarshal(do(t, h, "GET", "/ns/"+ns+"/search?key=owner&match=exact", "").Body.Bytes(), &hits); err != nil {
+			t.Fatal(err)
+		}
+		if len(hits) != 1 || hits[0].Value.Text() != "team-"+ns {
+			t.Errorf("%s: search found %+v, want only its own entry", ns, hits)
+		}
+
+		export := do(t, h, "GET", "/export?namespace="+ns, "", "X-Reveal-Token", "secret")
+		if export.Code != http.StatusOK || !strings.Contains(export.Body.String(), "team-"+ns) || strings.Contains(export.Body.String(), "team-"+other) {
+			t.Errorf("%s: export %d %s, want only its own configs", ns, export.Code, export.Body)
+		}
+	}
+
+	// Events replayed in a namespace are the writes made in it.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/ns/a/events?lastEventId=1", nil).WithContext(ctx)
+	events := httptest.NewRecorder()
+	h.ServeHTTP(events, req)
+	if body := events.Body.String(); !strings.Contains(body, `"version":"a1"`) || strings.Contains(body, `"version":"b1"`) {
+		t.Errorf("events of a: %d %s", events.Code, body)
+	}
+
+	// An idempotency key is only known where it was issued.
+	if rec := do(t, h, "POST", "/ns/b/config/"+id, `{"version": "b2", "entries": {}}`, "x-idempotency-key", keyB); rec.Code != http.StatusForbidden {
+		t.Errorf("key reused in b: status = %d, want 403", rec.Code)
+	}
+	if rec := do(t, h, "POST", "/ns/a/config/"+id, `{"version": "a2", "entries": {}}`, "x-idempotency-key", keyB); rec.Code != http.StatusOK {
+		t.Errorf("key of b in a: status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestPromotions(t *testing.T) {
+	h := newTestServer(t)
+	id := created(t, do(t, h, "POST", "/env/dev/config/", `{"version": "v1", "entries": {"k": "v"}}`), "Config ID: ")
+
+	rec := do(t, h, "POST", "/env/dev/config/"+id+"/v1/promote", `{"promotedBy": "alice"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "From: dev to staging") {
+		t.Fatalf("promote: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "GET", "/env/staging/config/"+id+"/v1/", ""); rec.Code != http.StatusOK {
+		t.Errorf("promoted version: status = %d, want 200", rec.Code)
+	}
+
+	tests := []struct {
+		name string
+		path string
+		body string
+		want int
+	}{
+		{"again", "/env/dev/config/" + id + "/v1/promote", `{"promotedBy": "alice"}`, http.StatusConflict},
+		{"missing version", "/env/dev/config/" + id + "/v9/promote", `{"promotedBy": "alice"}`, http.StatusNotFound},
+		{"last environment", "/env/prod/config/" + id + "/v1/promote", `{"promotedBy": "alice"}`, http.StatusBadRequest},
+		{"without promoter", "/env/staging/config/" + id + "/v1/promote", `{}`, http.StatusBadRequest},
+		{"unknown environment", "/env/qa/config/" + id + "/v1/promote", `{"promotedBy": "alice"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := do(t, h, "POST", tt.path, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	// A group with the ID of the config keeps a history of its own.
+	created(t, do(t, h, "POST", "/env/dev/group/"+id, `{"version": "g1", "configs": [{"env": "prod"}]}`), "Group ID: ")
+	if rec := do(t, h, "POST", "/env/dev/group/"+id+"/g1/promote", `{"promotedBy": "bob"}`); rec.Code != http.StatusOK {
+		t.Fatalf("promote group: status = %d: %s", rec.Code, rec.Body)
+	}
+	for kind, want := range map[string]string{"config": "alice", "group": "bob"} {
+		var promotions []cs.Promotion
+		if err := json.Unmarshal(do(t, h, "GET", "/"+kind+"/"+id+"/promotions", "").Body.Bytes(), &promotions); err != nil {
+			t.Fatal(err)
+		}
+		if len(promotions) != 1 || promotions[0].Kind != kind || promotions[0].PromotedBy != want {
+			t.Errorf("%s promotions = %+v, want the one by %s", kind, promotions, want)
+		}
+	}
+}
+
+func TestRevealSecrets(t *testing.T) {
+	keyfile := filepath.Join(t.TempDir(), "keys")
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	if err := os.WriteFile(keyfile, []byte("a:"+key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("DB", "memory")
+	t.Setenv("MASTER_KEY_FILE", keyfile)
+	store, err := cs.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newRouter(&Service{store: store, tracer: opentracing.NoopTracer{}, revealToken: "secret"})
+
+	id := createConfig(t, h, `{"version": "v1", "entries": {"user": "admin", "password": "hunter2"}, "secrets": ["password"]}`)
+	path := "/config/" + id + "/v1/"
+
+	tests := []struct {
+		name   string
+		query  string
+		header []string
+		want   int
+		body   string
+	}{
+		{"redacted", "", nil, http.StatusOK, cs.Redacted},
+		{"without token", "?reveal=true", nil, http.StatusForbidden, ""},
+		{"wrong token", "?reveal=true", []string{"X-Reveal-Token", "guess"}, http.StatusForbidden, ""},
+		{"token without reveal", "", []string{"X-Reveal-Token", "secret"}, http.StatusOK, cs.Redacted},
+		{"revealed", "?reveal=true", []string{"X-Reveal-Token", "secret"}, http.StatusOK, "hunter2"},
+	}
+	for _, tt := range tests {
+		rec := do(t, h, "GET", path+tt.query, "", tt.header...)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+			continue
+		}
+		if tt.body == "" {
+			continue
+		}
+		var config cs.Config
+		if err := json.Unmarshal(rec.Body.Bytes(), &config); err != nil {
+			t.Fatal(err)
+		}
+		if got := config.Entries["password"].Text(); got != tt.body {
+			t.Errorf("%s: password = %q, want %q", tt.name, got, tt.body)
+		}
+		if strings.Contains(rec.Body.String(), "hunter2") != (tt.body == "hunter2") {
+			t.Errorf("%s: body %s", tt.name, rec.Body)
+		}
+	}
+}
+
+func TestRollbackConfig(t *testing.T) {
+	h := newTestServer(t)
+	id := createConfig(t, h, `{"version": "v1", "entries": {"k": "good"}}`)
+	if rec := do(t, h, "POST", "/config/"+id, `{"version": "v2", "entries": {"k": "bad"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("v2: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := do(t, h, "POST", "/config/"+id+"/rollback", `{"from": "v1", "version": "v3"}`)
+	if created(t, rec, "Config ID: ") != id {
+		t.Fatalf("rollback answered %q, want config %s", rec.Body, id)
+	}
+	parts := strings.SplitN(rec.Body.String(), "Idempotence key: ", 2)
+	if len(parts) != 2 {
+		t.Fatalf("no idempotence key in %q", rec.Body)
+	}
+	key := parts[1]
+
+	var config cs.Config
+	if err := json.Unmarshal(do(t, h, "GET", "/config/"+id+"/v3/", "").Body.Bytes(), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Entries["k"].Text() != "good" || config.Metadata[cs.MetaRollbackFrom] != "v1" {
+		t.Errorf("v3 = %+v, want the entries of v1 rolled back from it", config)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		header []string
+		want   int
+	}{
+		{"same idempotency key", `{"from": "v1", "version": "v4"}`, []string{"x-idempotency-key", key}, http.StatusForbidden},
+		{"missing from", `{"version": "v4"}`, nil, http.StatusBadRequest},
+		{"missing version", `{"from": "v1"}`, nil, http.StatusBadRequest},
+		{"unknown field", `{"from": "v1", "version": "v4", "entries": {}}`, nil, http.StatusBadRequest},
+		{"missing source", `{"from": "v9", "version": "v4"}`, nil, http.StatusNotFound},
+		{"existing version", `{"from": "v1", "version": "v2"}`, nil, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if rec := do(t, h, "POST", "/config/"+id+"/rollback", tt.body, tt.header...); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+	if rec := do(t, h, "GET", "/config/"+id+"/v4/", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("v4 was written by a rejected rollback")
+	}
+}
+
+func TestDeleteConfig(t *testing.T) {
+	h := newTestServer(t)
+	id := createConfig(t, h, `{"version": "v1", "entries": {"k": "v"}}`)
+
+	if rec := do(t, h, "DELETE", "/config/"+id+"/v1", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "GET", "/config/"+id+"/v1/", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted version: status = %d, want 404", rec.Code)
+	}
+	if rec := do(t, h, "DELETE", "/config/"+id+"/v1", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("delete twice: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestGroupLabels(t *testing.T) {
+	h := newTestServer(t)
+	id := createGroup(t, h, `{"version": "v1", "configs": [
+		{"env": "prod", "tier": "web"},
+		{"env": "prod", "tier": "db"},
+		{"env": "dev", "tier": "web"}
+	]}`)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"", 3},
+		{"?env=prod", 2},
+		{"?env=prod&tier=web", 1},
+		{"?env=prod&tier=web&match=exact", 1},
+		{"?env=prod&match=exact", 0},
+		{"?env=staging", 0},
+		{"?selector=tier+in+(web,db),env!=dev", 2},
+		// Reserved parameters are not labels.
+		{"?env=prod&reveal=true&style=unified&limit=1&token=x&_=1700000000", 2},
+		{"?env=prod&tier=web&match=exact&_=1700000000", 1},
+		{"?selector=tier=web&reveal=true&limit=1", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := do(t, h, "GET", "/group/"+id+"/v1/config/"+tt.query, "")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			var labels []map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &labels); err != nil {
+				t.Fatal(err)
+			}
+			if len(labels) != tt.want {
+				t.Errorf("got %d configs %v, want %d", len(labels), labels, tt.want)
+			}
+		})
+	}
+
+	if rec := do(t, h, "GET", "/group/"+id+"/v1/config/?selector=x&match=exact", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("selector with match=exact: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestRemoveConfigsFromGroup(t *testing.T) {
+	h := newTestServer(t)
+	id := createGroup(t, h, `{"version": "v1", "configs": [{"env": "prod"}, {"env": "dev"}, {"env": "dev", "tier": "db"}]}`)
+
+	rec := do(t, h, "DELETE", "/group/"+id+"/v1/config/?env=dev&tier=db", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	key := rec.Header().Get(idempotencyHeader)
+	if key == "" {
+		t.Fatalf("no %s header", idempotencyHeader)
+	}
+
+	// Sent again the removal is refused, even though other configs match.
+	if rec := do(t, h, "DELETE", "/group/"+id+"/v1/config/?env=dev", "", "x-idempotency-key", key); rec.Code != http.StatusForbidden {
+		t.Errorf("repeated request: status = %d, want 403", rec.Code)
+	}
+	var labels []map[string]string
+	if err := json.Unmarshal(do(t, h, "GET", "/group/"+id+"/v1/config/", "").Body.Bytes(), &labels); err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 {
+		t.Errorf("group has configs %v, want prod and dev", labels)
+	}
+
+	if rec := do(t, h, "DELETE", "/group/"+id+"/v1/config/", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("without a selector: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestGetGroup(t *testing.T) {
+	h := newTestServer(t)
+	id := createGroup(t, h, `{"version": "v1", "configs": [{"env": "prod"}]}`)
+
+	rec := do(t, h, "GET", "/group/"+id+"/v1/", "")
+	var group cs.Group
+	if err := json.Unmarshal(rec.Body.Bytes(), &group); err != nil {
+		t.Fatal(err)
+	}
+	if group.ID != id || len(group.Configs) != 1 || group.Configs[0]["env"] != "prod" {
+		t.Errorf("group = %+v", group)
+	}
+
+	if rec := do(t, h, "GET", "/group/"+id+"/v2/", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("missing version: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestDiffStyle(t *testing.T) {
+	h := newTestServer(t)
+	configID := createConfig(t, h, `{"version": "v1", "entries": {"k": "one"}}`)
+	if rec := do(t, h, "POST", "/config/"+configID, `{"version": "v2", "entries": {"k": "two"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("new version: status = %d: %s", rec.Code, rec.Body)
+	}
+	groupID := createGroup(t, h, `{"version": "v1", "configs": [{"env": "prod"}]}`)
+	if rec := do(t, h, "POST", "/group/"+groupID, `{"version": "v2", "configs": [{"env": "dev"}]}`); rec.Code != http.StatusOK {
+		t.Fatalf("new version: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		query string
+		want  int
+		// unified is whether a text diff is expected.
+		unified bool
+	}{
+		{"", http.StatusOK, false},
+		{"&style=json", http.StatusOK, false},
+		{"&style=unified", http.StatusOK, true},
+		{"&format=unified", http.StatusOK, true},
+		{"&format=json", http.StatusOK, false},
+		{"&style=unified&format=json", http.StatusOK, true},
+		{"&style=yaml", http.StatusBadRequest, false},
+		{"&format=yaml", http.StatusBadRequest, false},
+	}
+	for _, path := range []string{"/config/" + configID + "/diff", "/group/" + groupID + "/diff"} {
+		for _, tt := range tests {
+			rec := do(t, h, "GET", path+"?from=v1&to=v2"+tt.query, "")
+			if rec.Code != tt.want {
+				t.Errorf("%s%s: status = %d, want %d: %s", path, tt.query, rec.Code, tt.want, rec.Body)
+				continue
+			}
+			if rec.Code != http.StatusOK {
+				continue
+			}
+			if unified := strings.HasPrefix(rec.Body.String(), "--- "); unified != tt.unified {
+				t.Errorf("%s%s: got %s", path, tt.query, rec.Body)
+			}
+		}
+	}
+}
+
+func TestConcurrentVersionConflict(t *testing.T) {
+	h := newTestServer(t)
+	configID := createConfig(t, h, `{"version": "v1", "entries": {"k": 0}}`)
+	groupID := createGroup(t, h, `{"version": "v1", "configs": [{"n": "0"}]}`)
+
+	tests := []struct {
+		path string
+		body string
+		// stored is what the version written by writer i holds.
+		stored string
+	}{
+		{"/config/" + configID, `{"version": "v2", "entries": {"k": %d}}`, `"k":%d`},
+		{"/group/" + groupID, `{"version": "v2", "configs": [{"n": "%d"}]}`, `"n":"%d"`},
+	}
+	for _, tt := range tests {
+		const writers = 8
+		codes := make([]int, writers)
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = do(t, h, "POST", tt.path, fmt.Sprintf(tt.body, i)).Code
+			}(i)
+		}
+		wg.Wait()
+
+		winner := -1
+		for i, code := range codes {
+			switch code {
+			case http.StatusOK:
+				if winner >= 0 {
+					t.Errorf("%s: writers %d and %d both created v2", tt.path, winner, i)
+				}
+				winner = i
+			case http.StatusConflict:
+			default:
+				t.Errorf("%s: writer %d got status %d, want 200 or 409", tt.path, i, code)
+			}
+		}
+		if winner < 0 {
+			t.Fatalf("%s: no writer created v2", tt.path)
+		}
+
+		// v2 holds what the winner wrote, no loser overwrote it.
+		rec := do(t, h, "GET", tt.path+"/v2/", "")
+		if want := fmt.Sprintf(tt.stored, winner); !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("%s: v2 = %s, want %s", tt.path, rec.Body, want)
+		}
+	}
+}
+
+func TestBlockingRead(t *testing.T) {
+	h := newTestServer(t)
+	id := createConfig(t, h, `{"version": "v1", "entries": {"k": "one"}}`)
+
+	rec := do(t, h, "GET", "/config/"+id+"/latest/", "")
+	index := rec.Header().Get("X-Config-Index")
+	if index == "" {
+		t.Fatalf("no X-Config-Index in %v", rec.Header())
+	}
+
+	// Nothing changes, the read waits out and reports the same index.
+	start := time.Now()
+	rec = do(t, h, "GET", "/config/"+id+"/latest/?index="+index+"&wait=50ms", "")
+	if time.Since(start) < 50*time.Millisecond || rec.Header().Get("X-Config-Index") != index {
+		t.Errorf("unchanged read returned after %v at index %s, want to wait at %s", time.Since(start), rec.Header().Get("X-Config-Index"), index)
+	}
+
+	// A new version ends the wait with it.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- do(t, h, "GET", "/config/"+id+"/latest/?index="+index+"&wait=10s", "")
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if rec := do(t, h, "POST", "/config/"+id, `{"version": "v2", "entries": {"k": "two"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("new version: status = %d: %s", rec.Code, rec.Body)
+	}
+	select {
+	case rec := <-done:
+		if !strings.Contains(rec.Body.String(), `"two"`) || rec.Header().Get("X-Config-Index") == index {
+			t.Errorf("woke up with %s at index %s", rec.Body, rec.Header().Get("X-Config-Index"))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocking read did not wake up for a new version")
+	}
+
+	// A request that may not reveal is refused before it waits.
+	index = do(t, h, "GET", "/config/"+id+"/v1/", "").Header().Get("X-Config-Index")
+	start = time.Now()
+	rec = do(t, h, "GET", "/config/"+id+"/v1/?reveal=true&index="+index+"&wait=10s", "")
+	if rec.Code != http.StatusForbidden || time.Since(start) > time.Second {
+		t.Errorf("reveal without a token: status = %d after %v, want 403 right away", rec.Code, time.Since(start))
+	}
+}